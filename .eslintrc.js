@@ -15,6 +15,7 @@ module.exports = {
     "globals": {
         "BRACKETS": "readonly",
         "FMS_CONFIG": "readonly",
+        "RANKING_NAMES": "readonly",
     },
     "rules": {
         "indent": [
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const apiV2Prefix = "/api/v2"
+
+type apiV2Param struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// a route in /api/v2. Path variables are passed to the shared v1 handler as
+// query parameters, and {event} is also passed as X-Event.
+type apiV2Route struct {
+	Method  string
+	Path    string
+	Role    authRole
+	Handler func(w http.ResponseWriter, r *http.Request)
+	Summary string
+	// parameters still read from the query string
+	Query []apiV2Param
+	// description of the JSON request body, if any
+	Body string
+	// whether TBA event credentials are needed (from the key store, or X-Auth and X-Secret)
+	EventAuth bool
+	// whether the route writes to TBA, and so accepts dry_run
+	TbaWrite bool
+}
+
+var apiV2MatchListBody = "list of FMS match IDs (e.g. \"12-1\")"
+
+var apiV2Routes = []apiV2Route{
+	{Method: "GET", Path: "/config", Role: ROLE_VIEWER, Handler: apiGetFMSConfig, Summary: "Get server settings"},
+	{Method: "PUT", Path: "/config", Role: ROLE_ADMIN, Handler: apiSetFMSConfig, Summary: "Change server settings",
+		Body: "server settings (fms_url, tba_url, dry_run, proxy_allowed_hosts; data_folder cannot be changed)"},
+
+	{Method: "GET", Path: "/auth", Role: ROLE_NONE, Handler: apiAuthStatus, Summary: "Get the current login"},
+	{Method: "POST", Path: "/auth/login", Role: ROLE_NONE, Handler: apiAuthLogin, Summary: "Log in",
+		Body: "user (empty for a shared PIN) and password"},
+	{Method: "POST", Path: "/auth/logout", Role: ROLE_NONE, Handler: apiAuthLogout, Summary: "Log out"},
+	{Method: "PUT", Path: "/auth/accounts", Role: ROLE_ADMIN, Handler: apiAuthUpdate, Summary: "Add, change or remove an account or shared PIN",
+		Body: "user, role, password and delete"},
+
+	{Method: "GET", Path: "/keys", Role: ROLE_VIEWER, Handler: apiKeyStoreList, Summary: "List events in the key store"},
+	{Method: "PUT", Path: "/keys/{event}", Role: ROLE_ADMIN, Handler: apiKeyStoreSet, Summary: "Store TBA credentials for an event",
+		Body: "auth and secret"},
+	{Method: "DELETE", Path: "/keys/{event}", Role: ROLE_ADMIN, Handler: apiKeyStoreDelete, Summary: "Remove TBA credentials for an event"},
+
+	{Method: "GET", Path: "/queue", Role: ROLE_VIEWER, Handler: apiQueueList, Summary: "List queued TBA requests"},
+	{Method: "PUT", Path: "/queue/paused", Role: ROLE_OPERATOR, Handler: apiQueuePause, Summary: "Pause or resume the upload queue",
+		Query: []apiV2Param{{Name: "paused", Description: "true or false", Required: true}}},
+	{Method: "POST", Path: "/queue/retry", Role: ROLE_OPERATOR, Handler: apiQueueRetry, Summary: "Retry all queued requests now"},
+	{Method: "POST", Path: "/queue/{id}/retry", Role: ROLE_OPERATOR, Handler: apiQueueRetry, Summary: "Retry a queued request now"},
+	{Method: "DELETE", Path: "/queue/{id}", Role: ROLE_ADMIN, Handler: apiQueueRemove, Summary: "Remove a queued request"},
+	{Method: "GET", Path: "/audit", Role: ROLE_OPERATOR, Handler: apiAuditLog, Summary: "Query the log of TBA writes",
+		Query: []apiV2Param{
+			{Name: "event", Description: "event key"},
+			{Name: "match", Description: "match key, e.g. qm1 or 2024abc_qm1"},
+			{Name: "since", Description: "earliest time (RFC 3339)"},
+			{Name: "until", Description: "latest time (RFC 3339)"},
+			{Name: "limit", Description: "most recent records to return (default 1000, 0 for all)"},
+		}},
+
+	{Method: "GET", Path: "/auto_upload", Role: ROLE_VIEWER, Handler: apiAutoUploadStatus, Summary: "Get auto upload status for all events"},
+	{Method: "POST", Path: "/events/{event}/auto_upload", Role: ROLE_OPERATOR, Handler: apiAutoUploadStart, Summary: "Start auto upload",
+		Body: "auto upload settings", EventAuth: true},
+	{Method: "PUT", Path: "/events/{event}/auto_upload", Role: ROLE_OPERATOR, Handler: apiAutoUploadUpdate, Summary: "Change auto upload settings",
+		Body: "auto upload settings"},
+	{Method: "DELETE", Path: "/events/{event}/auto_upload", Role: ROLE_OPERATOR, Handler: apiAutoUploadStop, Summary: "Stop auto upload"},
+
+	{Method: "GET", Path: "/events/{event}/settings", Role: ROLE_VIEWER, Handler: apiGetEventSettings, Summary: "Get event settings"},
+	{Method: "PUT", Path: "/events/{event}/settings", Role: ROLE_OPERATOR, Handler: apiSetEventSettings, Summary: "Change event settings",
+		Body: "playoff_type, enabled_extra_rps and default_level"},
+
+	{Method: "PUT", Path: "/events/{event}/info", Role: ROLE_OPERATOR, Handler: apiUploadEventInfo, Summary: "Upload event info to TBA",
+		Body: "TBA event info", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/teams", Role: ROLE_OPERATOR, Handler: apiUploadTeams, Summary: "Upload the team list to TBA",
+		Body: "list of team keys", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/alliances", Role: ROLE_OPERATOR, Handler: apiUploadAlliances, Summary: "Upload alliance selections to TBA",
+		Body: "list of alliances (lists of team keys)", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/awards", Role: ROLE_OPERATOR, Handler: apiUploadAwards, Summary: "Upload awards to TBA",
+		Body: "list of TBA awards", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/rankings", Role: ROLE_OPERATOR, Handler: apiUploadRankings, Summary: "Upload rankings to TBA",
+		Body: "TBA rankings", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/videos", Role: ROLE_OPERATOR, Handler: apiUploadVideos, Summary: "Upload match videos to TBA",
+		Body: "match key -> YouTube video ID", EventAuth: true, TbaWrite: true},
+	{Method: "PUT", Path: "/events/{event}/media", Role: ROLE_OPERATOR, Handler: apiUploadMedia, Summary: "Upload event media to TBA",
+		Body: "list of YouTube video IDs", EventAuth: true, TbaWrite: true},
+	{Method: "POST", Path: "/events/{event}/undo", Role: ROLE_ADMIN, Handler: apiUndo, Summary: "Revert the last upload to a TBA endpoint",
+		Query:     []apiV2Param{{Name: "endpoint", Description: "matches/update, matches/delete, rankings/update or alliance_selections/update", Required: true}},
+		EventAuth: true, TbaWrite: true},
+	{Method: "DELETE", Path: "/events/{event}/tba_matches", Role: ROLE_ADMIN, Handler: apiDeleteMatches, Summary: "Delete matches from TBA",
+		Body: "list of TBA match keys (e.g. \"qm1\")", EventAuth: true, TbaWrite: true},
+
+	{Method: "GET", Path: "/events/{event}/bracket", Role: ROLE_VIEWER, Handler: apiBracketState, Summary: "Get playoff bracket state",
+		Query: []apiV2Param{{Name: "playoff_type", Description: "TBA playoff type", Required: true}}},
+	{Method: "POST", Path: "/events/{event}/bracket/precreate", Role: ROLE_OPERATOR, Handler: apiBracketPrecreate, Summary: "Create upcoming playoff matches on TBA",
+		Query: []apiV2Param{{Name: "playoff_type", Description: "TBA playoff type", Required: true}}, EventAuth: true, TbaWrite: true},
+
+	{Method: "GET", Path: "/events/{event}/levels/{level}/matches", Role: ROLE_VIEWER, Handler: apiListMatches, Summary: "List downloaded matches not yet uploaded to TBA"},
+	{Method: "POST", Path: "/events/{event}/levels/{level}/matches/fetch", Role: ROLE_OPERATOR, Handler: apiFetchMatches, Summary: "Download new matches from FMS",
+		Query: []apiV2Param{
+			{Name: "playoff_type", Description: "TBA playoff type", Required: true},
+			{Name: "enabled_extra_rps", Description: "comma-separated list of true/false", Required: true},
+			{Name: "all", Description: "non-empty to download all matches again"},
+		}},
+	{Method: "PUT", Path: "/events/{event}/levels/{level}/matches", Role: ROLE_OPERATOR, Handler: apiUploadMatches, Summary: "Upload matches to TBA",
+		Query: []apiV2Param{{Name: "ids", Description: "comma-separated FMS match IDs to mark as uploaded once delivered"}},
+		Body:  "list of TBA matches", EventAuth: true, TbaWrite: true},
+	{Method: "POST", Path: "/events/{event}/levels/{level}/matches", Role: ROLE_OPERATOR, Handler: apiCreateMatch, Summary: "Create an empty manual match",
+		EventAuth: true},
+	{Method: "DELETE", Path: "/events/{event}/levels/{level}/matches", Role: ROLE_ADMIN, Handler: apiPurgeMatches, Summary: "Delete downloaded matches",
+		Query: []apiV2Param{{Name: "all", Description: "non-empty to delete all matches"}},
+		Body:  apiV2MatchListBody, EventAuth: true},
+	{Method: "POST", Path: "/events/{event}/levels/{level}/receipts", Role: ROLE_OPERATOR, Handler: apiMarkMatchesUploaded, Summary: "Mark matches as uploaded",
+		Body: apiV2MatchListBody, EventAuth: true},
+	{Method: "GET", Path: "/events/{event}/levels/{level}/matches/{id}", Role: ROLE_VIEWER, Handler: apiMatchLoadExtra, Summary: "Get extra match info",
+		EventAuth: true},
+	{Method: "PUT", Path: "/events/{event}/levels/{level}/matches/{id}", Role: ROLE_OPERATOR, Handler: apiMatchSaveExtra, Summary: "Change extra match info",
+		Body: "extra match info", EventAuth: true},
+	{Method: "DELETE", Path: "/events/{event}/levels/{level}/matches/{id}", Role: ROLE_ADMIN, Handler: apiV2MatchIdBody(apiPurgeMatches), Summary: "Delete a downloaded match",
+		EventAuth: true},
+	{Method: "PUT", Path: "/events/{event}/levels/{level}/matches/{id}/receipt", Role: ROLE_OPERATOR, Handler: apiV2MatchIdBody(apiMarkMatchesUploaded), Summary: "Mark a match as uploaded",
+		EventAuth: true},
+	{Method: "GET", Path: "/events/{event}/levels/{level}/rankings", Role: ROLE_OPERATOR, Handler: apiFetchRankings, Summary: "Download rankings from FMS"},
+	{Method: "GET", Path: "/events/{event}/levels/{level}/reconcile", Role: ROLE_VIEWER, Handler: apiReconcile, Summary: "Compare local matches with TBA"},
+	{Method: "POST", Path: "/events/{event}/levels/{level}/reconcile/requeue", Role: ROLE_OPERATOR, Handler: apiReconcileRequeue, Summary: "Upload matches that differ from TBA again",
+		Body: apiV2MatchListBody + " (all differing matches if empty)", EventAuth: true, TbaWrite: true},
+
+	{Method: "GET", Path: "/reports/{report_type}", Role: ROLE_OPERATOR, Handler: apiFetchReport, Summary: "Download an FMS report"},
+}
+
+// match levels by name, for {level}; numbers are also accepted
+var apiV2Levels = map[string]int{
+	"test":     MATCH_LEVEL_TEST,
+	"practice": MATCH_LEVEL_PRACTICE,
+	"qual":     MATCH_LEVEL_QUAL,
+	"playoff":  MATCH_LEVEL_PLAYOFF,
+	"manual":   MATCH_LEVEL_MANUAL,
+}
+
+var apiV2PathVarPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func apiV2Handler(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		query := r.URL.Query()
+		for name, value := range vars {
+			if level, ok := apiV2Levels[value]; name == "level" && ok {
+				value = strconv.Itoa(level)
+			}
+			query.Set(name, value)
+		}
+		r.URL.RawQuery = query.Encode()
+		if event, ok := vars["event"]; ok {
+			r.Header.Set("X-Event", event)
+		}
+		handler(w, r)
+	}
+}
+
+// pass the {id} path variable to a handler that takes a list of match IDs
+func apiV2MatchIdBody(handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := json.Marshal([]string{mux.Vars(r)["id"]})
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler(w, r)
+	}
+}
+
+func apiV2MethodNotAllowed(methods []string) func(w http.ResponseWriter, r *http.Request) {
+	allow := strings.Join(methods, ", ")
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Allow", allow)
+		apiPanicErrorCode(http.StatusMethodNotAllowed, API_ERROR_METHOD_NOT_ALLOWED, "%s not allowed (allowed: %s)", r.Method, allow)
+	}
+}
+
+func apiV2NotFound(w http.ResponseWriter, r *http.Request) {
+	apiPanicCode(http.StatusNotFound, "no such API route: %s %s", r.Method, r.URL.Path)
+}
+
+func registerApiV2(r *mux.Router) {
+	handleFuncWrapper(r, apiV2Prefix+"/openapi.json", ROLE_NONE, apiV2OpenAPI).Methods("GET")
+	methods := make(map[string][]string)
+	paths := make([]string, 0)
+	for _, route := range apiV2Routes {
+		handleFuncWrapper(r, apiV2Prefix+route.Path, route.Role, apiV2Handler(route.Handler)).Methods(route.Method)
+		if _, ok := methods[route.Path]; !ok {
+			paths = append(paths, route.Path)
+		}
+		methods[route.Path] = append(methods[route.Path], route.Method)
+	}
+	// registered after all routes, so that they only match if no method does
+	for _, path := range paths {
+		handleFuncWrapper(r, apiV2Prefix+path, ROLE_NONE, apiV2MethodNotAllowed(methods[path]))
+	}
+	r.PathPrefix(apiV2Prefix + "/").HandlerFunc(wrapApiHandler(apiV2Prefix+"/", ROLE_NONE, apiV2NotFound))
+}
+
+func apiV2Schema(description string) map[string]interface{} {
+	return map[string]interface{}{"description": description}
+}
+
+func apiV2OpenAPIDocument() map[string]interface{} {
+	paths := make(map[string]map[string]interface{})
+	for _, route := range apiV2Routes {
+		parameters := make([]map[string]interface{}, 0)
+		for _, match := range apiV2PathVarPattern.FindAllStringSubmatch(route.Path, -1) {
+			description := match[1]
+			if match[1] == "level" {
+				description = "match level: test, practice, qual, playoff or manual"
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name": match[1], "in": "path", "required": true, "description": description,
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		for _, param := range route.Query {
+			parameters = append(parameters, map[string]interface{}{
+				"name": param.Name, "in": "query", "required": param.Required, "description": param.Description,
+				"schema": map[string]string{"type": "string"},
+			})
+		}
+		if route.TbaWrite {
+			parameters = append(parameters, map[string]interface{}{
+				"name": "dry_run", "in": "query", "required": false,
+				"description": "true to validate the request and return it (status dry_run) instead of sending it to TBA",
+				"schema":      map[string]string{"type": "string"},
+			})
+		}
+		if route.EventAuth {
+			for _, header := range []string{"X-Auth", "X-Secret"} {
+				parameters = append(parameters, map[string]interface{}{
+					"name": header, "in": "header", "required": false,
+					"description": "TBA event credentials; not needed if the event is in the key store",
+					"schema":      map[string]string{"type": "string"},
+				})
+			}
+		}
+		operation := map[string]interface{}{
+			"summary":     route.Summary,
+			"operationId": strings.ToLower(route.Method) + apiV2OperationName(route.Path),
+			"parameters":  parameters,
+			"responses": map[string]interface{}{
+				"200": map[string]interface{}{
+					"description": "success; the result is in data",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Response"}}},
+				},
+				"default": map[string]interface{}{
+					"description": "error; see error.code and error.message",
+					"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": map[string]string{"$ref": "#/components/schemas/Response"}}},
+				},
+			},
+			"x-role": route.Role.String(),
+		}
+		if route.Body != "" {
+			operation["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": apiV2Schema(route.Body)}},
+			}
+		}
+		if paths[route.Path] == nil {
+			paths[route.Path] = make(map[string]interface{})
+		}
+		paths[route.Path][strings.ToLower(route.Method)] = operation
+	}
+
+	error_codes := []string{
+		API_ERROR_BAD_REQUEST, API_ERROR_MISSING_PARAM, API_ERROR_MISSING_AUTH, API_ERROR_UNAUTHORIZED,
+		API_ERROR_FORBIDDEN, API_ERROR_NOT_FOUND, API_ERROR_METHOD_NOT_ALLOWED, API_ERROR_TOO_LARGE,
+		API_ERROR_KEY_STORE_LOCKED, API_ERROR_PROXY_DENIED, API_ERROR_UPSTREAM, API_ERROR_TBA, API_ERROR_INTERNAL,
+	}
+	sort.Strings(error_codes)
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]string{
+			"title":   "TBA-uploader API",
+			"version": Version,
+		},
+		"servers": []map[string]string{{"url": apiV2Prefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"Response": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"status"},
+					"properties": map[string]interface{}{
+						"status": map[string]interface{}{"type": "string", "enum": []string{API_STATUS_OK, API_STATUS_QUEUED, API_STATUS_DRY_RUN, API_STATUS_ERROR}},
+						"data":   map[string]string{"description": "result, if any"},
+						"error":  map[string]string{"$ref": "#/components/schemas/Error"},
+					},
+				},
+				"Error": map[string]interface{}{
+					"type":     "object",
+					"required": []string{"code", "message"},
+					"properties": map[string]interface{}{
+						"code":       map[string]interface{}{"type": "string", "enum": error_codes},
+						"message":    map[string]string{"type": "string"},
+						"tba_status": map[string]string{"type": "integer", "description": "status of the TBA response, if TBA rejected the request"},
+						"tba_body":   map[string]string{"type": "string", "description": "body of the TBA response"},
+					},
+				},
+			},
+		},
+	}
+}
+
+// e.g. /queue/{id}/retry -> QueueByIdRetry
+func apiV2OperationName(path string) string {
+	out := ""
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") {
+			out += "By"
+			part = strings.Trim(part, "{}")
+		}
+		for _, word := range strings.FieldsFunc(part, func(c rune) bool { return c == '_' || c == '.' }) {
+			out += strings.ToUpper(word[:1]) + word[1:]
+		}
+	}
+	return out
+}
+
+func apiV2OpenAPI(w http.ResponseWriter, r *http.Request) {
+	// served as-is rather than in a response envelope, so that OpenAPI tools can read it
+	out, err := json.MarshalIndent(apiV2OpenAPIDocument(), "", "  ")
+	if err != nil {
+		apiPanicInternal("%s", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestApiV2Routes(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	os.MkdirAll(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), os.ModePerm)
+
+	request, _ := http.NewRequest("PUT", server.URL+"/api/v2/events/2023test/levels/qual/matches?ids=1-1", strings.NewReader(webTestMatches))
+	request.Header.Set("X-Auth", "auth")
+	request.Header.Set("X-Secret", "secret")
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatal("upload failed:", res.StatusCode)
+	}
+	if _, ok := fake.Matches("2023test")["qm1"]; !ok {
+		t.Error("match not stored on TBA")
+	}
+	if !fileExists(path.Join(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), "1-1.receipt")) {
+		t.Error("receipt not written")
+	}
+
+	res, err = http.Get(server.URL + "/api/v2/events/2023test/levels/qual/matches")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), `"data":[]`) {
+		t.Error("unexpected match list:", res.StatusCode, string(body))
+	}
+
+	res, err = http.Post(server.URL+"/api/v2/events/2023test/teams", "application/json", strings.NewReader(`[]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != "PUT" || !strings.Contains(string(body), API_ERROR_METHOD_NOT_ALLOWED) {
+		t.Error("unexpected response for wrong method:", res.StatusCode, res.Header.Get("Allow"), string(body))
+	}
+
+	res, err = http.Get(server.URL + "/api/v2/nonexistent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Error("unexpected response for unknown route:", res.StatusCode)
+	}
+}
+
+func TestApiV2OpenAPI(t *testing.T) {
+	out, err := json.Marshal(apiV2OpenAPIDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths map[string]map[string]struct {
+			OperationId string `json:"operationId"`
+		} `json:"paths"`
+	}
+	json.Unmarshal(out, &doc)
+	if _, ok := doc.Paths["/events/{event}/levels/{level}/matches/{id}"]["delete"]; !ok {
+		t.Error("match route missing from OpenAPI document")
+	}
+	if _, ok := doc.Paths["/events/{event}/levels/{level}/matches/fetch"]["post"]; !ok {
+		t.Error("match fetch route missing from OpenAPI document")
+	}
+	operation_ids := make(map[string]bool)
+	for path, methods := range doc.Paths {
+		for method, operation := range methods {
+			if operation_ids[operation.OperationId] {
+				t.Errorf("duplicate operationId for %s %s: %s", method, path, operation.OperationId)
+			}
+			operation_ids[operation.OperationId] = true
+		}
+	}
+}
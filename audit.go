@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+const auditLogFilename = "audit.jsonl"
+
+// endpoint of AUDIT_RESULT_READ records, from the read API's event/{event}/matches
+const auditMatchReadEndpoint = "matches"
+
+const (
+	AUDIT_RESULT_SENT    = "sent"
+	AUDIT_RESULT_RETRY   = "retry"
+	AUDIT_RESULT_FAILED  = "failed"
+	AUDIT_RESULT_REMOVED = "removed"
+	AUDIT_RESULT_DRY_RUN = "dry_run"
+	// the partial match keys that TBA had when they were read for reconciliation
+	AUDIT_RESULT_READ = "read"
+)
+
+// one line of the audit log, written for every attempt to write to TBA and for
+// every match list read from TBA
+type auditRecord struct {
+	Time        time.Time       `json:"time"`
+	RequestId   int64           `json:"request_id"`
+	Operator    string          `json:"operator"`
+	Event       string          `json:"event"`
+	Endpoint    string          `json:"endpoint"`
+	PayloadHash string          `json:"payload_hash"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempt     int             `json:"attempt,omitempty"`
+	Result      string          `json:"result"`
+	TbaStatus   int             `json:"tba_status,omitempty"`
+	TbaResponse string          `json:"tba_response,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	// FMS matches marked as uploaded by this write
+	Receipts *queueReceipts `json:"receipts,omitempty"`
+	// key of the record of the write that this one undoes
+	UndoOf string `json:"undo_of,omitempty"`
+}
+
+// identifies a write in the audit log. Request IDs alone are not unique, since
+// they can be reused once the upload queue is empty.
+func (record auditRecord) key() string {
+	return fmt.Sprintf("%s/%d", record.Time.UTC().Format(time.RFC3339Nano), record.RequestId)
+}
+
+var audit_log_mutex sync.Mutex
+
+// partial keys of matches written to TBA by event: true if uploaded, false if
+// deleted since. Kept up to date by writeAuditRecord so that polling it does
+// not read the whole audit log; loaded from the log once per data folder.
+var audit_match_index struct {
+	path   string
+	events map[string]map[string]bool
+}
+
+func getAuditLogPath() string {
+	return filepath.Join(FMSConfig.DataFolder, auditLogFilename)
+}
+
+// who made a request, for the audit log: the account name, or the PIN role if
+// logged in with a shared PIN, followed by the client address
+func getRequestOperator(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	role, user := getRequestRole(r)
+	if user != "" {
+		return fmt.Sprintf("%s (%s)", user, host)
+	} else if getAuthConfig().enabled() {
+		return fmt.Sprintf("%s PIN (%s)", role, host)
+	}
+	return host
+}
+
+func newAuditRecord(entry *queueEntry, result string) auditRecord {
+	record := auditRecord{
+		Time:        time.Now(),
+		RequestId:   entry.Id,
+		Operator:    entry.Operator,
+		Event:       entry.Request.Event,
+		Endpoint:    entry.Request.Path,
+		PayloadHash: entry.Hash,
+		Payload:     json.RawMessage(entry.Request.Body),
+		Attempt:     entry.Attempts,
+		Result:      result,
+		Receipts:    entry.Receipts,
+		UndoOf:      entry.UndoOf,
+	}
+	if !json.Valid(record.Payload) {
+		record.Payload, _ = json.Marshal(string(entry.Request.Body))
+	}
+	return record
+}
+
+func writeAuditRecord(record auditRecord) {
+	line, err := json.Marshal(record)
+	if err != nil {
+		logger.Printf("audit log: %s\n", err)
+		return
+	}
+	audit_log_mutex.Lock()
+	defer audit_log_mutex.Unlock()
+	f, err := os.OpenFile(getAuditLogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		logger.Printf("audit log: %s\n", err)
+		return
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		logger.Printf("audit log: %s\n", err)
+	}
+	if audit_match_index.path == getAuditLogPath() {
+		indexAuditRecordLocked(record)
+	}
+}
+
+func indexAuditRecordLocked(record auditRecord) {
+	if record.Result != AUDIT_RESULT_SENT {
+		return
+	}
+	uploaded := record.Endpoint == (tba.MatchList{}).Path()
+	if !uploaded && record.Endpoint != (tba.MatchDeleteList{}).Path() {
+		return
+	}
+	keys := audit_match_index.events[record.Event]
+	if keys == nil {
+		keys = make(map[string]bool)
+		audit_match_index.events[record.Event] = keys
+	}
+	for _, key := range auditPayloadMatchKeys(record.Endpoint, record.Payload) {
+		keys[key] = uploaded
+	}
+}
+
+func loadAuditMatchIndexLocked() error {
+	path := getAuditLogPath()
+	if audit_match_index.path == path {
+		return nil
+	}
+	records, err := readAuditLog(auditFilter{})
+	if err != nil {
+		return err
+	}
+	audit_match_index.path = path
+	audit_match_index.events = make(map[string]map[string]bool)
+	for _, record := range records {
+		indexAuditRecordLocked(record)
+	}
+	return nil
+}
+
+func loadAuditMatchIndex() error {
+	audit_log_mutex.Lock()
+	defer audit_log_mutex.Unlock()
+	return loadAuditMatchIndexLocked()
+}
+
+// partial keys of matches written to TBA for an event, according to the audit
+// log: true if uploaded, false if deleted since
+func getAuditMatchKeys(event string) (map[string]bool, error) {
+	audit_log_mutex.Lock()
+	defer audit_log_mutex.Unlock()
+	if err := loadAuditMatchIndexLocked(); err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool)
+	for key, uploaded := range audit_match_index.events[event] {
+		out[key] = uploaded
+	}
+	return out, nil
+}
+
+// record the matches that TBA has for an event, so that undo can tell which
+// matches were created by later writes
+func writeAuditMatchRead(event string, matches []tba.ReadMatch) {
+	keys := make([]string, len(matches))
+	for i, match := range matches {
+		keys[i] = match.PartialKey()
+	}
+	payload, _ := json.Marshal(keys)
+	writeAuditRecord(auditRecord{
+		Time:     time.Now(),
+		Event:    event,
+		Endpoint: auditMatchReadEndpoint,
+		Payload:  payload,
+		Result:   AUDIT_RESULT_READ,
+	})
+}
+
+// partial match keys (e.g. "qm1") affected by a payload
+func auditPayloadMatchKeys(endpoint string, payload []byte) []string {
+	keys := make([]string, 0)
+	switch endpoint {
+	case tba.MatchList{}.Path():
+		var matches tba.MatchList
+		json.Unmarshal(payload, &matches)
+		for _, match := range matches {
+			keys = append(keys, match.PartialKey())
+		}
+	case tba.MatchDeleteList{}.Path():
+		json.Unmarshal(payload, &keys)
+	case tba.MatchVideos{}.Path():
+		var videos tba.MatchVideos
+		json.Unmarshal(payload, &videos)
+		for key := range videos {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+type auditFilter struct {
+	Event string
+	// partial match key, e.g. "qm1"
+	Match string
+	Since time.Time
+	Until time.Time
+	// most recent records to return; 0 for all
+	Limit int
+}
+
+func (filter auditFilter) matches(record auditRecord) bool {
+	if filter.Event != "" && record.Event != filter.Event {
+		return false
+	}
+	if !filter.Since.IsZero() && record.Time.Before(filter.Since) {
+		return false
+	}
+	if !filter.Until.IsZero() && record.Time.After(filter.Until) {
+		return false
+	}
+	if filter.Match != "" {
+		for _, key := range auditPayloadMatchKeys(record.Endpoint, record.Payload) {
+			if key == filter.Match {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func readAuditLog(filter auditFilter) ([]auditRecord, error) {
+	records := make([]auditRecord, 0)
+	f, err := os.Open(getAuditLogPath())
+	if os.IsNotExist(err) {
+		return records, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for scanner.Scan() {
+		var record auditRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// a partial line from a crash; keep reading
+			continue
+		}
+		if filter.matches(record) {
+			records = append(records, record)
+		}
+	}
+	if filter.Limit > 0 && len(records) > filter.Limit {
+		records = records[len(records)-filter.Limit:]
+	}
+	return records, scanner.Err()
+}
+
+func checkRequestTimeParam(r *http.Request, param string) time.Time {
+	value := r.URL.Query().Get(param)
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		apiPanicBadRequest("invalid time for parameter %s (expected RFC 3339): %s", param, err)
+	}
+	return t
+}
+
+func apiAuditLog(w http.ResponseWriter, r *http.Request) {
+	filter := auditFilter{
+		Event: r.URL.Query().Get("event"),
+		Match: r.URL.Query().Get("match"),
+		Since: checkRequestTimeParam(r, "since"),
+		Until: checkRequestTimeParam(r, "until"),
+		Limit: 1000,
+	}
+	// full keys (e.g. 2024abc_qm1) are also accepted
+	if event, match, ok := strings.Cut(filter.Match, "_"); ok {
+		filter.Match = match
+		if filter.Event == "" {
+			filter.Event = event
+		}
+	}
+	if limit := r.URL.Query().Get("limit"); limit != "" {
+		var err error
+		filter.Limit, err = strconv.Atoi(limit)
+		if err != nil || filter.Limit < 0 {
+			apiPanicBadRequest("invalid limit: %q", limit)
+		}
+	}
+	records, err := readAuditLog(filter)
+	if err != nil {
+		apiPanicInternal("failed to read audit log: %s", err)
+	}
+	sendJson(w, records)
+}
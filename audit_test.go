@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditLog(t *testing.T) {
+	server, _ := startWebTestServer(t)
+	os.MkdirAll(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), os.ModePerm)
+
+	if status, body := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids=1-1", "secret", webTestMatches); status != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", status, body)
+	}
+	if status, _ := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids=1-1", "wrong", webTestMatches); status == http.StatusOK {
+		t.Fatal("upload with the wrong secret succeeded")
+	}
+
+	records, err := readAuditLog(auditFilter{Event: "2023test", Match: "qm1"})
+	if err != nil {
+		t.Fatal("readAuditLog:", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, expected 2: %v", len(records), records)
+	}
+	if records[0].Result != AUDIT_RESULT_SENT || records[0].TbaStatus != http.StatusOK {
+		t.Error("first upload not recorded as sent:", records[0])
+	}
+	if records[1].Result != AUDIT_RESULT_FAILED || records[1].TbaStatus != http.StatusUnauthorized {
+		t.Error("second upload not recorded as failed:", records[1])
+	}
+	if !strings.HasPrefix(records[0].Operator, "127.0.0.1") {
+		t.Error("unexpected operator:", records[0].Operator)
+	}
+	if records[0].PayloadHash == "" || !strings.Contains(string(records[0].Payload), `"frc1"`) {
+		t.Error("payload not recorded:", records[0])
+	}
+
+	if records, _ := readAuditLog(auditFilter{Match: "qm2"}); len(records) != 0 {
+		t.Error("unexpected records for qm2:", records)
+	}
+	if records, _ := readAuditLog(auditFilter{Since: time.Now().Add(time.Minute)}); len(records) != 0 {
+		t.Error("unexpected records in the future:", records)
+	}
+	if records, _ := readAuditLog(auditFilter{Limit: 1}); len(records) != 1 || records[0].Result != AUDIT_RESULT_FAILED {
+		t.Error("limit did not return the most recent record:", records)
+	}
+}
+
+func TestAuditMatchIndex(t *testing.T) {
+	old_data_folder := FMSConfig.DataFolder
+	defer func() { FMSConfig.DataFolder = old_data_folder }()
+	FMSConfig.DataFolder = t.TempDir()
+
+	writeAuditRecord(auditRecord{Event: "2023test", Endpoint: "matches/update", Payload: []byte(`[{"comp_level": "qm", "set_number": 1, "match_number": 1}]`), Result: AUDIT_RESULT_SENT})
+	if keys, err := getAuditMatchKeys("2023test"); err != nil || !keys["qm1"] {
+		t.Fatal("uploaded match not indexed:", keys, err)
+	}
+
+	// later records update the index without reading the log again
+	writeAuditRecord(auditRecord{Event: "2023test", Endpoint: "matches/delete", Payload: []byte(`["qm1"]`), Result: AUDIT_RESULT_FAILED})
+	writeAuditRecord(auditRecord{Event: "2023test", Endpoint: "matches/update", Payload: []byte(`[{"comp_level": "qm", "set_number": 1, "match_number": 2}]`), Result: AUDIT_RESULT_SENT})
+	os.Remove(getAuditLogPath())
+	keys, err := getAuditMatchKeys("2023test")
+	if err != nil || !keys["qm1"] || !keys["qm2"] {
+		t.Error("index not updated:", keys, err)
+	}
+	writeAuditRecord(auditRecord{Event: "2023test", Endpoint: "matches/delete", Payload: []byte(`["qm1"]`), Result: AUDIT_RESULT_SENT})
+	if keys, _ := getAuditMatchKeys("2023test"); keys["qm1"] {
+		t.Error("deleted match still indexed as uploaded:", keys)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+type authRole int
+
+const (
+	ROLE_NONE authRole = iota
+	ROLE_VIEWER
+	ROLE_OPERATOR
+	ROLE_ADMIN
+)
+
+var authRoleNames = map[authRole]string{
+	ROLE_NONE:     "",
+	ROLE_VIEWER:   "viewer",
+	ROLE_OPERATOR: "operator",
+	ROLE_ADMIN:    "admin",
+}
+
+func (role authRole) String() string {
+	return authRoleNames[role]
+}
+
+func parseAuthRole(name string) (authRole, error) {
+	for role, role_name := range authRoleNames {
+		if role != ROLE_NONE && role_name == name {
+			return role, nil
+		}
+	}
+	return ROLE_NONE, fmt.Errorf("invalid role: %q", name)
+}
+
+const authSessionCookie = "tba_uploader_session"
+const authSessionDuration = 12 * time.Hour
+
+type authAccount struct {
+	PasswordHash string `json:"password_hash"`
+	Role         string `json:"role"`
+}
+
+// login settings, stored in config.json. Authentication is disabled (and
+// every request has admin access) until a PIN or account is added.
+type authConfig struct {
+	// shared PIN hashes, by role name
+	Pins  map[string]string      `json:"pins,omitempty"`
+	Users map[string]authAccount `json:"users,omitempty"`
+}
+
+func (config authConfig) enabled() bool {
+	return len(config.Pins) > 0 || len(config.Users) > 0
+}
+
+// whether someone can still log in as an admin, e.g. to turn authentication
+// off again
+func (config authConfig) hasAdmin() bool {
+	if _, ok := config.Pins[ROLE_ADMIN.String()]; ok {
+		return true
+	}
+	for _, account := range config.Users {
+		if account.Role == ROLE_ADMIN.String() {
+			return true
+		}
+	}
+	return false
+}
+
+func (config authConfig) validate() error {
+	for role_name := range config.Pins {
+		if _, err := parseAuthRole(role_name); err != nil {
+			return fmt.Errorf("pins: %s", err)
+		}
+	}
+	for user, account := range config.Users {
+		if _, err := parseAuthRole(account.Role); err != nil {
+			return fmt.Errorf("user %s: %s", user, err)
+		}
+	}
+	return nil
+}
+
+// guarded by config_mutex
+var auth_config authConfig
+
+type authSession struct {
+	User    string
+	Role    authRole
+	Expires time.Time
+}
+
+var auth_sessions_mutex sync.Mutex
+var auth_sessions = make(map[string]authSession)
+
+// hash a password or PIN as "scrypt$salt$hash"
+func hashAuthPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash, err := scrypt.Key([]byte(password), salt, keyStoreScryptN, keyStoreScryptR, keyStoreScryptP, 32)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("scrypt$%x$%x", salt, hash), nil
+}
+
+func checkAuthPassword(password string, password_hash string) bool {
+	parts := strings.Split(password_hash, "$")
+	if len(parts) != 3 || parts[0] != "scrypt" {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	hash, err := scrypt.Key([]byte(password), salt, keyStoreScryptN, keyStoreScryptR, keyStoreScryptP, len(expected))
+	return err == nil && subtle.ConstantTimeCompare(hash, expected) == 1
+}
+
+func getAuthConfig() authConfig {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	return auth_config
+}
+
+// check a login against the configured accounts, or against the shared PINs
+// if user is empty. Returns ROLE_NONE if the login is invalid.
+func authenticate(user string, password string) authRole {
+	config := getAuthConfig()
+	if user != "" {
+		account, ok := config.Users[user]
+		if !ok || !checkAuthPassword(password, account.PasswordHash) {
+			return ROLE_NONE
+		}
+		role, _ := parseAuthRole(account.Role)
+		return role
+	}
+	for _, role := range []authRole{ROLE_ADMIN, ROLE_OPERATOR, ROLE_VIEWER} {
+		if pin_hash, ok := config.Pins[role.String()]; ok && checkAuthPassword(password, pin_hash) {
+			return role
+		}
+	}
+	return ROLE_NONE
+}
+
+func newAuthSession(user string, role authRole) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	auth_sessions_mutex.Lock()
+	defer auth_sessions_mutex.Unlock()
+	now := time.Now()
+	for old_token, session := range auth_sessions {
+		if now.After(session.Expires) {
+			delete(auth_sessions, old_token)
+		}
+	}
+	auth_sessions[token] = authSession{User: user, Role: role, Expires: now.Add(authSessionDuration)}
+	return token, nil
+}
+
+// role of the request's session; every request is an admin if authentication is disabled
+func getRequestRole(r *http.Request) (authRole, string) {
+	if !getAuthConfig().enabled() {
+		return ROLE_ADMIN, ""
+	}
+	cookie, err := r.Cookie(authSessionCookie)
+	if err != nil {
+		return ROLE_NONE, ""
+	}
+	auth_sessions_mutex.Lock()
+	defer auth_sessions_mutex.Unlock()
+	session, ok := auth_sessions[cookie.Value]
+	if !ok || time.Now().After(session.Expires) {
+		delete(auth_sessions, cookie.Value)
+		return ROLE_NONE, ""
+	}
+	return session.Role, session.User
+}
+
+func checkRequestRole(r *http.Request, required authRole) {
+	if required == ROLE_NONE {
+		return
+	}
+	role, _ := getRequestRole(r)
+	if role == ROLE_NONE {
+		apiPanicCode(http.StatusUnauthorized, "login required")
+	} else if role < required {
+		apiPanicCode(http.StatusForbidden, "%s access required", required)
+	}
+}
+
+type authStatus struct {
+	Enabled bool   `json:"enabled"`
+	User    string `json:"user,omitempty"`
+	Role    string `json:"role"`
+}
+
+func apiAuthStatus(w http.ResponseWriter, r *http.Request) {
+	role, user := getRequestRole(r)
+	sendJson(w, authStatus{Enabled: getAuthConfig().enabled(), User: user, Role: role.String()})
+}
+
+type authLogin struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+func apiAuthLogin(w http.ResponseWriter, r *http.Request) {
+	var login authLogin
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &login); err != nil {
+		apiPanicBadRequest("invalid login: %s", err)
+	}
+	role := authenticate(login.User, login.Password)
+	if role == ROLE_NONE {
+		logger.Printf("Failed login from %s (user %q)\n", r.RemoteAddr, login.User)
+		apiPanicCode(http.StatusUnauthorized, "invalid login")
+	}
+	token, err := newAuthSession(login.User, role)
+	if err != nil {
+		apiPanicInternal("%s", err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     authSessionCookie,
+		Value:    token,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   int(authSessionDuration.Seconds()),
+	})
+	sendJson(w, authStatus{Enabled: true, User: login.User, Role: role.String()})
+}
+
+func apiAuthLogout(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(authSessionCookie); err == nil {
+		auth_sessions_mutex.Lock()
+		delete(auth_sessions, cookie.Value)
+		auth_sessions_mutex.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: authSessionCookie, Value: "", Path: "/", MaxAge: -1})
+	sendJson(w, nil)
+}
+
+type authAccountUpdate struct {
+	// empty to set the shared PIN for role
+	User     string `json:"user"`
+	Role     string `json:"role"`
+	Password string `json:"password"`
+	// remove the account or PIN instead
+	Delete bool `json:"delete"`
+}
+
+// add, change or remove an account or shared PIN
+func apiAuthUpdate(w http.ResponseWriter, r *http.Request) {
+	var update authAccountUpdate
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &update); err != nil {
+		apiPanicBadRequest("invalid update: %s", err)
+	}
+	role, err := parseAuthRole(update.Role)
+	if err != nil && !(update.Delete && update.User != "") {
+		apiPanicBadRequest("%s", err)
+	}
+	var password_hash string
+	if !update.Delete {
+		if update.Password == "" {
+			apiPanicBadRequest("empty password")
+		}
+		password_hash, err = hashAuthPassword(update.Password)
+		if err != nil {
+			apiPanicInternal("%s", err)
+		}
+	}
+
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	// copy, so that getAuthConfig callers never see a partial update
+	config := authConfig{Pins: make(map[string]string), Users: make(map[string]authAccount)}
+	for k, v := range auth_config.Pins {
+		config.Pins[k] = v
+	}
+	for k, v := range auth_config.Users {
+		config.Users[k] = v
+	}
+	switch {
+	case update.User == "" && update.Delete:
+		delete(config.Pins, role.String())
+	case update.User == "":
+		config.Pins[role.String()] = password_hash
+	case update.Delete:
+		delete(config.Users, update.User)
+	default:
+		config.Users[update.User] = authAccount{PasswordHash: password_hash, Role: role.String()}
+	}
+	if config.enabled() && !config.hasAdmin() {
+		if auth_config.enabled() {
+			apiPanicBadRequest("cannot remove the last admin PIN or account")
+		}
+		apiPanicBadRequest("add an admin PIN or account first")
+	}
+	auth_config = config
+	if err := saveServerConfigLocked(); err != nil {
+		apiPanicInternal("failed to save config: %s", err)
+	}
+	sendJson(w, nil)
+}
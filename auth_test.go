@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestAuthRoles(t *testing.T) {
+	old_config := getAuthConfig()
+	defer func() { auth_config = old_config }()
+	operator_hash, _ := hashAuthPassword("1234")
+	admin_hash, _ := hashAuthPassword("secret")
+	auth_config = authConfig{
+		Pins:  map[string]string{"operator": operator_hash},
+		Users: map[string]authAccount{"admin": {PasswordHash: admin_hash, Role: "admin"}},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("ok")) }
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/auth/login", ROLE_NONE, apiAuthLogin)
+	handleFuncWrapper(r, "/viewer", ROLE_VIEWER, ok)
+	handleFuncWrapper(r, "/operator", ROLE_OPERATOR, ok)
+	handleFuncWrapper(r, "/admin", ROLE_ADMIN, ok)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	login := func(body string) *http.Cookie {
+		res, err := http.Post(server.URL+"/api/auth/login", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		for _, cookie := range res.Cookies() {
+			if cookie.Name == authSessionCookie {
+				return cookie
+			}
+		}
+		return nil
+	}
+	status := func(route string, cookie *http.Cookie) int {
+		request, _ := http.NewRequest("GET", server.URL+route, nil)
+		if cookie != nil {
+			request.AddCookie(cookie)
+		}
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status("/viewer", nil) != http.StatusUnauthorized {
+		t.Error("anonymous request allowed")
+	}
+	if login(`{"password": "wrong"}`) != nil {
+		t.Error("logged in with wrong PIN")
+	}
+	operator := login(`{"password": "1234"}`)
+	if operator == nil {
+		t.Fatal("operator login failed")
+	}
+	if status("/viewer", operator) != http.StatusOK || status("/operator", operator) != http.StatusOK {
+		t.Error("operator denied access")
+	}
+	if status("/admin", operator) != http.StatusForbidden {
+		t.Error("operator allowed admin access")
+	}
+	admin := login(`{"user": "admin", "password": "secret"}`)
+	if admin == nil || status("/admin", admin) != http.StatusOK {
+		t.Error("admin denied access")
+	}
+
+	auth_config = authConfig{}
+	if status("/admin", nil) != http.StatusOK {
+		t.Error("request denied with authentication disabled")
+	}
+}
+
+func TestAuthUpdateKeepsAdmin(t *testing.T) {
+	old_config, old_data_folder := getAuthConfig(), FMSConfig.DataFolder
+	defer func() { auth_config, FMSConfig.DataFolder = old_config, old_data_folder }()
+	FMSConfig.DataFolder = t.TempDir()
+	auth_config = authConfig{}
+
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/auth/login", ROLE_NONE, apiAuthLogin)
+	handleFuncWrapper(r, "/api/auth/update", ROLE_ADMIN, apiAuthUpdate)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	var session *http.Cookie
+	update := func(body string) int {
+		request, _ := http.NewRequest("POST", server.URL+"/api/auth/update", strings.NewReader(body))
+		if session != nil {
+			request.AddCookie(session)
+		}
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if update(`{"role": "operator", "password": "1234"}`) != http.StatusBadRequest || getAuthConfig().enabled() {
+		t.Error("authentication enabled without an admin")
+	}
+	if update(`{"role": "admin", "password": "secret"}`) != http.StatusOK {
+		t.Fatal("failed to add admin PIN")
+	}
+	res, err := http.Post(server.URL+"/api/auth/login", "application/json", strings.NewReader(`{"password": "secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == authSessionCookie {
+			session = cookie
+		}
+	}
+	if update(`{"role": "operator", "password": "1234"}`) != http.StatusOK {
+		t.Fatal("failed to add operator PIN")
+	}
+	if update(`{"role": "admin", "delete": true}`) != http.StatusBadRequest || !getAuthConfig().hasAdmin() {
+		t.Error("removed the last admin PIN")
+	}
+	if update(`{"user": "admin", "role": "admin", "password": "secret"}`) != http.StatusOK {
+		t.Fatal("failed to add admin account")
+	}
+	if update(`{"role": "admin", "delete": true}`) != http.StatusOK {
+		t.Error("failed to remove admin PIN with an admin account left")
+	}
+}
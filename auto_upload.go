@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+type autoUploadSettings struct {
+	Level           int    `json:"level"`
+	PlayoffType     int    `json:"playoff_type"`
+	EnabledExtraRps []bool `json:"enabled_extra_rps"`
+	// seconds between FMS polls
+	PollInterval int `json:"poll_interval"`
+	// seconds to hold a new match before uploading it, to give time for score edits
+	MatchDelay int `json:"match_delay"`
+	// seconds to wait after uploading matches before uploading rankings
+	RankingsDelay  int  `json:"rankings_delay"`
+	UploadRankings bool `json:"upload_rankings"`
+	// create TBA matches for upcoming playoff matches once their alliances are known
+	PrecreatePlayoffMatches bool `json:"precreate_playoff_matches"`
+	Paused                  bool `json:"paused"`
+}
+
+func defaultAutoUploadSettings() autoUploadSettings {
+	return autoUploadSettings{
+		Level:                   MATCH_LEVEL_QUAL,
+		PlayoffType:             BRACKET_TYPE_BRACKET_8_TEAM,
+		EnabledExtraRps:         []bool{false, false},
+		PollInterval:            10,
+		MatchDelay:              0,
+		RankingsDelay:           0,
+		UploadRankings:          true,
+		PrecreatePlayoffMatches: true,
+	}
+}
+
+func (settings autoUploadSettings) validate() error {
+	switch settings.Level {
+	case MATCH_LEVEL_TEST, MATCH_LEVEL_PRACTICE, MATCH_LEVEL_QUAL, MATCH_LEVEL_PLAYOFF, MATCH_LEVEL_MANUAL:
+	default:
+		return fmt.Errorf("invalid level: %d", settings.Level)
+	}
+	if settings.PlayoffType != BRACKET_TYPE_CUSTOM && tba.GetBracket(settings.PlayoffType) == nil {
+		return fmt.Errorf("unsupported playoff_type: %d", settings.PlayoffType)
+	}
+	if settings.PollInterval <= 0 {
+		return fmt.Errorf("poll_interval must be positive: %d", settings.PollInterval)
+	}
+	if settings.MatchDelay < 0 {
+		return fmt.Errorf("match_delay must not be negative: %d", settings.MatchDelay)
+	}
+	if settings.RankingsDelay < 0 {
+		return fmt.Errorf("rankings_delay must not be negative: %d", settings.RankingsDelay)
+	}
+	return nil
+}
+
+type autoUploadStatus struct {
+	Event           string             `json:"event"`
+	Settings        autoUploadSettings `json:"settings"`
+	LastPoll        *time.Time         `json:"last_poll"`
+	LastUpload      *time.Time         `json:"last_upload"`
+	LastError       string             `json:"last_error"`
+	UploadedMatches []string           `json:"uploaded_matches"`
+	HeldMatches     []string           `json:"held_matches"`
+	InvalidMatches  map[string]string  `json:"invalid_matches"`
+	RankingsDue     *time.Time         `json:"rankings_due"`
+}
+
+type autoUploader struct {
+	// guards status. It is not held during FMS or TBA I/O, so that status
+	// requests and settings changes are not blocked by a slow poll.
+	mutex  sync.Mutex
+	params tba.EventParams
+	status autoUploadStatus
+	// when pending matches were first seen; only used by run
+	first_seen map[string]time.Time
+	// recorded in the audit log
+	operator string
+	stop     chan struct{}
+	wake     chan struct{}
+}
+
+var auto_uploaders_mutex sync.Mutex
+var auto_uploaders = make(map[string]*autoUploader)
+
+func newAutoUploader(params tba.EventParams, settings autoUploadSettings) *autoUploader {
+	return &autoUploader{
+		params: params,
+		status: autoUploadStatus{
+			Event:           params.Event,
+			Settings:        settings,
+			UploadedMatches: make([]string, 0),
+			HeldMatches:     make([]string, 0),
+			InvalidMatches:  make(map[string]string),
+		},
+		first_seen: make(map[string]time.Time),
+		stop:       make(chan struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+func (u *autoUploader) run() {
+	logger.Printf("auto upload: %s: started\n", u.params.Event)
+	for {
+		u.mutex.Lock()
+		paused := u.status.Settings.Paused
+		interval := time.Duration(u.status.Settings.PollInterval) * time.Second
+		u.mutex.Unlock()
+
+		if !paused {
+			u.poll()
+		}
+		publishAutoUploadStatus()
+
+		if interval < time.Second {
+			interval = time.Second
+		}
+		select {
+		case <-u.stop:
+			logger.Printf("auto upload: %s: stopped\n", u.params.Event)
+			return
+		case <-u.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+func (u *autoUploader) notify() {
+	select {
+	case u.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (u *autoUploader) setError(err error) {
+	logger.Printf("auto upload: %s: %s\n", u.params.Event, err)
+	u.mutex.Lock()
+	u.status.LastError = err.Error()
+	u.mutex.Unlock()
+}
+
+func (u *autoUploader) poll() {
+	u.mutex.Lock()
+	settings := u.status.Settings
+	// only changed by poll, so it can be used unlocked
+	rankings_due := u.status.RankingsDue
+	now := time.Now()
+	u.status.LastPoll = &now
+	u.status.LastError = ""
+	u.mutex.Unlock()
+
+	event := u.params.Event
+	if isDryRun() {
+		u.setError(errors.New("dry-run mode is enabled, so nothing is uploaded"))
+		return
+	}
+
+	pending, err := fetchPendingMatches(matchParseOptions{
+		Level:           settings.Level,
+		Event:           event,
+		PlayoffType:     settings.PlayoffType,
+		EnabledExtraRps: settings.EnabledExtraRps,
+	}, false)
+	if err != nil {
+		u.setError(err)
+		return
+	}
+	match_folder := getMatchDownloadPath(settings.Level, event)
+
+	ready := make([]map[string]interface{}, 0)
+	ready_ids := make([]string, 0)
+	held := make([]string, 0)
+	invalid := make(map[string]string)
+	for _, match := range pending {
+		fms_id := match["_fms_id"].(string)
+		if err := validateMatchForUpload(match); err != nil {
+			invalid[fms_id] = err.Error()
+			continue
+		}
+		if _, ok := u.first_seen[fms_id]; !ok {
+			u.first_seen[fms_id] = now
+		}
+		if now.Sub(u.first_seen[fms_id]) < time.Duration(settings.MatchDelay)*time.Second {
+			held = append(held, fms_id)
+			continue
+		}
+		ready = append(ready, match)
+		ready_ids = append(ready_ids, fms_id)
+	}
+	sort.Strings(held)
+	u.mutex.Lock()
+	u.status.HeldMatches = held
+	u.status.InvalidMatches = invalid
+	u.mutex.Unlock()
+
+	if len(ready) > 0 {
+		payload, err := matchesToPayload(ready)
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		_, err = queueTBAPayload(payload, &u.params, &queueReceipts{
+			Folder:   match_folder,
+			MatchIds: ready_ids,
+		}, u.operator)
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		for _, fms_id := range ready_ids {
+			delete(u.first_seen, fms_id)
+		}
+		logger.Printf("auto upload: %s: queued %d matches: %v\n", event, len(ready_ids), ready_ids)
+		if settings.UploadRankings && settings.Level == MATCH_LEVEL_QUAL {
+			due := now.Add(time.Duration(settings.RankingsDelay) * time.Second)
+			rankings_due = &due
+		}
+		u.mutex.Lock()
+		u.status.UploadedMatches = append(u.status.UploadedMatches, ready_ids...)
+		u.status.LastUpload = &now
+		u.status.RankingsDue = rankings_due
+		u.mutex.Unlock()
+	}
+
+	if settings.Level == MATCH_LEVEL_PLAYOFF && settings.PrecreatePlayoffMatches && isFile(getAlliancesPath(event)) {
+		err = u.precreatePlayoffMatches(settings.PlayoffType)
+		if err != nil {
+			u.setError(fmt.Errorf("playoff match creation failed: %s", err))
+			return
+		}
+	}
+
+	if rankings_due != nil && !now.Before(*rankings_due) {
+		err = u.uploadRankings(settings.Level)
+		if err != nil {
+			u.setError(err)
+			return
+		}
+		u.mutex.Lock()
+		u.status.RankingsDue = nil
+		u.mutex.Unlock()
+	}
+}
+
+func (u *autoUploader) precreatePlayoffMatches(playoff_type int) error {
+	stubs, err := getPlayoffMatchStubs(u.params.Event, playoff_type)
+	if err != nil {
+		return err
+	}
+	if len(stubs) == 0 {
+		return nil
+	}
+	_, err = queueTBAPayload(stubs, &u.params, nil, u.operator)
+	if err != nil {
+		return err
+	}
+	keys := make([]string, len(stubs))
+	for i, stub := range stubs {
+		keys[i] = stub.PartialKey()
+	}
+	logger.Printf("auto upload: %s: created playoff matches: %v\n", u.params.Event, keys)
+	return nil
+}
+
+func (u *autoUploader) uploadRankings(level int) error {
+	fms_rankings, err := downloadRankings(level, u.params.Event)
+	if err != nil {
+		return fmt.Errorf("ranking fetch failed: %s", err)
+	}
+	rankings, err := convertFMSRankings(parseEventYear(u.params.Event), fms_rankings)
+	if err != nil {
+		return err
+	}
+	_, err = queueTBAPayload(rankings, &u.params, nil, u.operator)
+	if err != nil {
+		return err
+	}
+	logger.Printf("auto upload: %s: queued rankings\n", u.params.Event)
+	return nil
+}
+
+func getAutoUploadStatuses() map[string]autoUploadStatus {
+	auto_uploaders_mutex.Lock()
+	defer auto_uploaders_mutex.Unlock()
+	out := make(map[string]autoUploadStatus)
+	for event, u := range auto_uploaders {
+		u.mutex.Lock()
+		out[event] = u.status
+		u.mutex.Unlock()
+	}
+	return out
+}
+
+func publishAutoUploadStatus() {
+	err := wsStateUpdate(map[string]interface{}{
+		"auto_upload": getAutoUploadStatuses(),
+	})
+	if err != nil {
+		logger.Printf("auto upload: failed to publish status: %s\n", err)
+	}
+}
+
+func stopAutoUploader(event string) bool {
+	auto_uploaders_mutex.Lock()
+	defer auto_uploaders_mutex.Unlock()
+	if u, ok := auto_uploaders[event]; ok {
+		close(u.stop)
+		delete(auto_uploaders, event)
+		return true
+	}
+	return false
+}
+
+func apiAutoUploadStart(w http.ResponseWriter, r *http.Request) {
+	params := checkRequestEventParams(r)
+	settings := defaultAutoUploadSettings()
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &settings)
+		if err != nil {
+			apiPanicBadRequest("invalid settings: %s", err)
+		}
+	}
+	if err := settings.validate(); err != nil {
+		apiPanicBadRequest("invalid settings: %s", err)
+	}
+
+	stopAutoUploader(params.Event)
+	u := newAutoUploader(*params, settings)
+	u.operator = fmt.Sprintf("auto upload, started by %s", getRequestOperator(r))
+	auto_uploaders_mutex.Lock()
+	auto_uploaders[params.Event] = u
+	auto_uploaders_mutex.Unlock()
+	// run changes the status as soon as it starts
+	status := u.status
+	go u.run()
+
+	sendJson(w, status)
+}
+
+func apiAutoUploadStop(w http.ResponseWriter, r *http.Request) {
+	event := checkRequestQueryParam(r, "event")
+	if !stopAutoUploader(event) {
+		apiPanicBadRequest("auto upload not running for %s", event)
+	}
+	publishAutoUploadStatus()
+}
+
+// update settings of a running pipeline, e.g. {"paused": true}
+func apiAutoUploadUpdate(w http.ResponseWriter, r *http.Request) {
+	event := checkRequestQueryParam(r, "event")
+	auto_uploaders_mutex.Lock()
+	u, ok := auto_uploaders[event]
+	auto_uploaders_mutex.Unlock()
+	if !ok {
+		apiPanicBadRequest("auto upload not running for %s", event)
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	u.mutex.Lock()
+	settings := u.status.Settings
+	err := json.Unmarshal(body, &settings)
+	if err == nil {
+		err = settings.validate()
+	}
+	if err == nil {
+		u.status.Settings = settings
+	}
+	status := u.status
+	u.mutex.Unlock()
+	if err != nil {
+		apiPanicBadRequest("invalid settings: %s", err)
+	}
+	u.notify()
+
+	sendJson(w, status)
+}
+
+func apiAutoUploadStatus(w http.ResponseWriter, r *http.Request) {
+	sendJson(w, getAutoUploadStatuses())
+}
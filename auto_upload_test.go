@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestAutoUploadSettingsValidate(t *testing.T) {
+	if err := defaultAutoUploadSettings().validate(); err != nil {
+		t.Error("default settings rejected:", err)
+	}
+	for name, change := range map[string]func(*autoUploadSettings){
+		"level":          func(s *autoUploadSettings) { s.Level = 5 },
+		"playoff_type":   func(s *autoUploadSettings) { s.PlayoffType = 100 },
+		"poll_interval":  func(s *autoUploadSettings) { s.PollInterval = 0 },
+		"match_delay":    func(s *autoUploadSettings) { s.MatchDelay = -1 },
+		"rankings_delay": func(s *autoUploadSettings) { s.RankingsDelay = -1 },
+	} {
+		settings := defaultAutoUploadSettings()
+		change(&settings)
+		if settings.validate() == nil {
+			t.Errorf("invalid %s accepted", name)
+		}
+	}
+}
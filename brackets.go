@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+var customBracketFilenames = []string{"bracket.json", "bracket.yaml", "bracket.yml"}
+
+// path to the custom bracket definition for an event, or "" if there is none
+func getCustomBracketPath(event string) string {
+	if !eventKeyPattern.MatchString(event) {
+		return ""
+	}
+	for _, filename := range customBracketFilenames {
+		bracket_path := path.Join(FMSConfig.DataFolder, event, filename)
+		if isFile(bracket_path) {
+			return bracket_path
+		}
+	}
+	return ""
+}
+
+// bracket for an event, loading custom brackets from the event's data folder
+func getEventBracket(event string, bracket_type int) (tba.Bracket, error) {
+	if bracket_type != BRACKET_TYPE_CUSTOM {
+		bracket := tba.GetBracket(bracket_type)
+		if bracket == nil {
+			return nil, fmt.Errorf("unsupported bracket type: %d", bracket_type)
+		}
+		return bracket, nil
+	}
+	bracket_path := getCustomBracketPath(event)
+	if bracket_path == "" {
+		return nil, fmt.Errorf("no custom bracket defined: add %s to %s", customBracketFilenames[0], path.Join(FMSConfig.DataFolder, event))
+	}
+	return tba.LoadCustomBracket(bracket_path)
+}
+
+func getAlliancesPath(event string) string {
+	return path.Join(FMSConfig.DataFolder, event, "alliances.json")
+}
+
+// keep a copy of uploaded alliance selections for bracket tracking
+func saveEventAlliances(event string, alliances tba.AllianceSelections) error {
+	out, err := json.Marshal(alliances)
+	if err != nil {
+		return err
+	}
+	alliances_path := getAlliancesPath(event)
+	os.MkdirAll(path.Dir(alliances_path), os.ModePerm)
+	return ioutil.WriteFile(alliances_path, out, os.ModePerm)
+}
+
+func loadEventAlliances(event string) (tba.AllianceSelections, error) {
+	contents, err := ioutil.ReadFile(getAlliancesPath(event))
+	if err != nil {
+		return nil, fmt.Errorf("no alliances uploaded: %s", err)
+	}
+	var alliances tba.AllianceSelections
+	err = json.Unmarshal(contents, &alliances)
+	return alliances, err
+}
+
+// bracket state from the event's uploaded alliances and downloaded playoff matches
+func getBracketState(event string, bracket_type int) (*tba.BracketState, error) {
+	bracket, err := getEventBracket(event, bracket_type)
+	if err != nil {
+		return nil, err
+	}
+	alliances, err := loadEventAlliances(event)
+	if err != nil {
+		return nil, err
+	}
+	local_matches, err := loadLocalMatches(getMatchDownloadPath(MATCH_LEVEL_PLAYOFF, event))
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]tba.Match, 0, len(local_matches))
+	for _, local := range local_matches {
+		matches = append(matches, local.Match)
+	}
+	return tba.ComputeBracketState(bracket_type, bracket, alliances, matches)
+}
+
+func apiBracketState(w http.ResponseWriter, r *http.Request) {
+	event := checkRequestQueryParam(r, "event")
+	state, err := getBracketState(event, checkRequestQueryParamInt(r, "playoff_type"))
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	sendJson(w, state)
+}
+
+// partial keys of matches posted to TBA by this uploader and not deleted since,
+// according to the audit log, including requests still in the upload queue
+func getUploadedMatchKeys(event string) (map[string]bool, error) {
+	out, err := getAuditMatchKeys(event)
+	if err != nil {
+		return nil, err
+	}
+	if upload_queue != nil {
+		for _, entry := range upload_queue.List() {
+			if entry.State != QUEUE_STATE_PENDING || entry.Request.Event != event {
+				continue
+			}
+			for _, key := range auditPayloadMatchKeys(entry.Request.Path, entry.Request.Body) {
+				if entry.Request.Path == (tba.MatchList{}).Path() {
+					out[key] = true
+				} else if entry.Request.Path == (tba.MatchDeleteList{}).Path() {
+					out[key] = false
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+// TBA matches with unplayed scores for the next match of each upcoming playoff
+// set whose alliances are known, so that viewers see matchups before they are
+// played. Matches already downloaded from FMS or uploaded to TBA are skipped.
+func getPlayoffMatchStubs(event string, bracket_type int) (tba.MatchList, error) {
+	state, err := getBracketState(event, bracket_type)
+	if err != nil {
+		return nil, err
+	}
+	alliances, err := loadEventAlliances(event)
+	if err != nil {
+		return nil, err
+	}
+	local_matches, err := loadLocalMatches(getMatchDownloadPath(MATCH_LEVEL_PLAYOFF, event))
+	if err != nil {
+		return nil, err
+	}
+	uploaded, err := getUploadedMatchKeys(event)
+	if err != nil {
+		return nil, err
+	}
+	alliance_teams := func(number int) []string {
+		teams := alliances[number-1]
+		if len(teams) > 3 {
+			teams = teams[:3]
+		}
+		return teams
+	}
+
+	stubs := make(tba.MatchList, 0)
+	for _, set := range state.UpcomingSets() {
+		// later matches may not be needed, and would stay on TBA unplayed
+		if set.Played() >= set.Matches {
+			continue
+		}
+		match := tba.Match{
+			CompLevel:   set.Level,
+			SetNumber:   set.Set,
+			MatchNumber: set.Played() + 1,
+			Alliances: tba.MatchAlliances{
+				Red:  tba.MatchAlliance{Teams: alliance_teams(set.Alliances[0]), Score: -1},
+				Blue: tba.MatchAlliance{Teams: alliance_teams(set.Alliances[1]), Score: -1},
+			},
+		}
+		if _, ok := local_matches[match.PartialKey()]; !ok && !uploaded[match.PartialKey()] {
+			stubs = append(stubs, match)
+		}
+	}
+	return stubs, nil
+}
+
+// queue stubs for upcoming playoff matches; responds with the keys of the created matches
+func apiBracketPrecreate(w http.ResponseWriter, r *http.Request) {
+	params := checkRequestEventParams(r)
+	stubs, err := getPlayoffMatchStubs(params.Event, checkRequestQueryParamInt(r, "playoff_type"))
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	keys := make([]string, len(stubs))
+	for i, stub := range stubs {
+		keys[i] = stub.PartialKey()
+	}
+	if len(stubs) > 0 && isRequestDryRun(r) {
+		sendTBADryRun(w, stubs, params, getRequestOperator(r))
+		return
+	} else if len(stubs) > 0 {
+		_, err = queueTBAPayload(stubs, params, nil, getRequestOperator(r))
+		if err != nil {
+			apiPanicBadRequest("%s", err)
+		}
+	}
+	sendJson(w, keys)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+const bracketTestMatch = `{
+	"comp_level": "sf", "set_number": 1, "match_number": 1,
+	"alliances": {
+		"red": {"teams": ["frc1", "frc2", "frc3"], "score": 10},
+		"blue": {"teams": ["frc10", "frc11", "frc12"], "score": 5}
+	}
+}`
+
+func TestPlayoffMatchStubs(t *testing.T) {
+	event := "2023brackettest"
+	alliances := tba.AllianceSelections{
+		{"frc1", "frc2", "frc3", "frc13"},
+		{"frc4", "frc5", "frc6"},
+		{"frc7", "frc8", "frc9"},
+		{"frc10", "frc11", "frc12"},
+	}
+	if err := saveEventAlliances(event, alliances); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(path.Join(FMSConfig.DataFolder, event))
+	match_folder := getMatchDownloadPath(MATCH_LEVEL_PLAYOFF, event)
+	os.MkdirAll(match_folder, os.ModePerm)
+	ioutil.WriteFile(path.Join(match_folder, "1-1.json"), []byte(bracketTestMatch), os.ModePerm)
+
+	stubs, err := getPlayoffMatchStubs(event, BRACKET_TYPE_BRACKET_4_TEAM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, len(stubs))
+	for i, stub := range stubs {
+		keys[i] = stub.PartialKey()
+	}
+	if !reflect.DeepEqual(keys, []string{"sf1m2", "sf2m1"}) {
+		t.Error("unexpected stubs:", keys)
+	}
+	if !reflect.DeepEqual(stubs[0].Alliances.Red.Teams, []string{"frc1", "frc2", "frc3"}) || stubs[0].Alliances.Red.Score != -1 {
+		t.Error("unexpected red alliance:", stubs[0].Alliances.Red)
+	}
+	if err := tba.MatchList(stubs).Validate(); err != nil {
+		t.Error(err)
+	}
+
+	// stubs already uploaded are not created again, e.g. after a restart
+	payload, _ := json.Marshal(tba.MatchList{stubs[1]})
+	writeAuditRecord(auditRecord{Time: time.Now(), Event: event, Endpoint: "matches/update", Payload: payload, Result: AUDIT_RESULT_SENT})
+	stubs, err = getPlayoffMatchStubs(event, BRACKET_TYPE_BRACKET_4_TEAM)
+	if err != nil || len(stubs) != 1 || stubs[0].PartialKey() != "sf1m2" {
+		t.Error("uploaded stub created again:", stubs, err)
+	}
+
+	if _, err := getPlayoffMatchStubs("2023nobracket", BRACKET_TYPE_BRACKET_4_TEAM); err == nil {
+		t.Error("stubs created without alliances")
+	}
+}
+
+func TestJsBracketsEventKey(t *testing.T) {
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/js/brackets.js", ROLE_NONE, jsBrackets)
+	for query, expected := range map[string]int{"": http.StatusOK, "?event=2023test": http.StatusOK, "?event=..%2F..%2Fetc": http.StatusBadRequest} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", "/js/brackets.js"+query, nil))
+		if w.Code != expected {
+			t.Errorf("%q: got status %d, expected %d", query, w.Code, expected)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// a subcommand that runs a single operation without the web server
+type cliCommand struct {
+	Summary string
+	// registers the command's flags and returns the function that runs it
+	Setup func(fs *flag.FlagSet) func() error
+}
+
+var cliCommands = map[string]cliCommand{
+	"fetch-matches":    {"Download new matches from FMS and print those not uploaded yet", cliFetchMatches},
+	"upload-matches":   {"Download new matches from FMS and upload them to TBA", cliUploadMatches},
+	"upload-rankings":  {"Download rankings from FMS and upload them to TBA", cliUploadRankings},
+	"upload-alliances": {"Upload alliance selections from a JSON file to TBA", cliUploadAlliances},
+	"purge":            {"Delete downloaded matches so that they are fetched and uploaded again", cliPurge},
+	"report":           {"Download an FMS report and print it", cliReport},
+	"reconcile":        {"Compare downloaded matches with TBA", cliReconcile},
+}
+
+func printCliCommands(w io.Writer) {
+	names := make([]string, 0, len(cliCommands))
+	for name := range cliCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-18s %s\n", name, cliCommands[name].Summary)
+	}
+}
+
+func runCliCommand(name string, command cliCommand, args []string, exe string) int {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s [options]\n\n%s.\n\nOptions:\n", filepath.Base(os.Args[0]), name, command.Summary)
+		fs.PrintDefaults()
+	}
+	common_flags := addCommonFlags(fs, exe)
+	run := command.Setup(fs)
+	fs.Parse(args)
+	// keep stdout for command output
+	initCommon(fs, common_flags, os.Stderr)
+
+	if err := run(); err != nil {
+		logger.Printf("%s: %s\n", name, err)
+		return 1
+	}
+	return 0
+}
+
+func printCliJson(val interface{}) error {
+	out, err := json.MarshalIndent(val, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// event, level and credential flags shared by most commands
+type cliEventFlags struct {
+	event  *string
+	level  *string
+	auth   *string
+	secret *string
+}
+
+func addCliEventFlags(fs *flag.FlagSet) cliEventFlags {
+	return cliEventFlags{
+		event:  fs.String("event", "", "TBA event key (required)"),
+		level:  fs.String("level", "", "match level: test, practice, qual, playoff, manual or a number (defaults to the event's default level, or qual)"),
+		auth:   fs.String("auth", "", "TBA auth ID (defaults to the key store)"),
+		secret: fs.String("secret", "", "TBA auth secret (defaults to the key store)"),
+	}
+}
+
+func (f cliEventFlags) checkEvent() (string, error) {
+	if *f.event == "" {
+		return "", errors.New("-event is required")
+	} else if !eventKeyPattern.MatchString(*f.event) {
+		return "", fmt.Errorf("invalid event key: %q", *f.event)
+	}
+	return *f.event, nil
+}
+
+func (f cliEventFlags) matchLevel() (int, error) {
+	if *f.level == "" {
+		if settings := getEventSettings(*f.event); settings.DefaultLevel != nil {
+			return *settings.DefaultLevel, nil
+		}
+		return MATCH_LEVEL_QUAL, nil
+	}
+	if level, ok := apiV2Levels[*f.level]; ok {
+		return level, nil
+	}
+	level, err := strconv.Atoi(*f.level)
+	if err != nil || ((level < 0 || level > 3) && level != MATCH_LEVEL_MANUAL) {
+		return -1, fmt.Errorf("invalid level: %q", *f.level)
+	}
+	return level, nil
+}
+
+// credentials from -auth and -secret, or from the key store
+func (f cliEventFlags) eventParams() (*tba.EventParams, error) {
+	event, err := f.checkEvent()
+	if err != nil {
+		return nil, err
+	}
+	if *f.auth != "" || *f.secret != "" {
+		if *f.auth == "" || *f.secret == "" {
+			return nil, errors.New("both -auth and -secret are required")
+		}
+		return &tba.EventParams{Event: event, Auth: *f.auth, Secret: *f.secret}, nil
+	}
+	if key_store == nil {
+		return nil, fmt.Errorf("no credentials for %s: pass -auth and -secret, or unlock the key store with -key-passphrase-file", event)
+	}
+	key, ok := key_store.Get(event)
+	if !ok {
+		return nil, fmt.Errorf("no credentials for %s in the key store", event)
+	}
+	return &tba.EventParams{Event: event, Auth: key.Auth, Secret: key.Secret}, nil
+}
+
+// FMS match options; the playoff type and extra RPs default to the event settings
+type cliMatchFlags struct {
+	cliEventFlags
+	playoff_type *int
+	extra_rps    *string
+	all          *bool
+}
+
+func addCliMatchFlags(fs *flag.FlagSet) cliMatchFlags {
+	return cliMatchFlags{
+		cliEventFlags: addCliEventFlags(fs),
+		playoff_type:  fs.Int("playoff-type", -1, "TBA playoff type (defaults to the event settings)"),
+		extra_rps:     fs.String("extra-rps", "", "comma-separated list of true/false for each extra RP (defaults to the event settings)"),
+		all:           fs.Bool("all", false, "download all matches again, not only new ones"),
+	}
+}
+
+func (f cliMatchFlags) parseOptions() (matchParseOptions, error) {
+	event, err := f.checkEvent()
+	if err != nil {
+		return matchParseOptions{}, err
+	}
+	level, err := f.matchLevel()
+	if err != nil {
+		return matchParseOptions{}, err
+	}
+	settings := getEventSettings(event)
+	options := matchParseOptions{
+		Level:           level,
+		Event:           event,
+		PlayoffType:     *f.playoff_type,
+		EnabledExtraRps: settings.EnabledExtraRps,
+	}
+	if options.PlayoffType == -1 {
+		if settings.PlayoffType != nil {
+			options.PlayoffType = *settings.PlayoffType
+		} else if level == MATCH_LEVEL_PLAYOFF {
+			return options, fmt.Errorf("no playoff type set for %s: pass -playoff-type", event)
+		} else {
+			options.PlayoffType = 0
+		}
+	}
+	if *f.extra_rps != "" {
+		options.EnabledExtraRps = nil
+		for _, s := range strings.Split(*f.extra_rps, ",") {
+			value, err := strconv.ParseBool(s)
+			if err != nil {
+				return options, fmt.Errorf("invalid -extra-rps: %q", *f.extra_rps)
+			}
+			options.EnabledExtraRps = append(options.EnabledExtraRps, value)
+		}
+	}
+	if options.EnabledExtraRps == nil {
+		options.EnabledExtraRps = []bool{false, false}
+	}
+	return options, nil
+}
+
+// operator recorded in the audit log for command-line uploads
+func cliOperator() string {
+	if u, err := user.Current(); err == nil {
+		return fmt.Sprintf("command line (%s)", u.Username)
+	}
+	return "command line"
+}
+
+// queue a payload and wait for TBA to accept it. In dry-run mode, the request
+// is printed to stderr instead.
+func cliSendPayload(payload tba.Payload, params *tba.EventParams, receipts *queueReceipts, timeout time.Duration) error {
+	if isDryRun() {
+		preview, err := previewTBAPayload(payload, params, cliOperator())
+		if err != nil {
+			return err
+		}
+		out, _ := json.MarshalIndent(preview, "", "  ")
+		fmt.Fprintf(os.Stderr, "dry run, not sent:\n%s\n", out)
+		return nil
+	}
+	if err := initCliUploadQueue(); err != nil {
+		return err
+	}
+	id, err := queueTBAPayload(payload, params, receipts, cliOperator())
+	if err != nil {
+		return err
+	}
+	result := upload_queue.DeliverNow(id, timeout)
+	if result.State == QUEUE_STATE_FAILED || result.State == QUEUE_STATE_UNKNOWN {
+		return errors.New(result.Error)
+	} else if result.State == QUEUE_STATE_PENDING {
+		return fmt.Errorf("not delivered within %s; request %d stays queued and will be retried the next time the uploader runs: %s", timeout, id, result.Error)
+	}
+	return nil
+}
+
+func addCliTimeoutFlag(fs *flag.FlagSet) *time.Duration {
+	return fs.Duration("timeout", 30*time.Second, "how long to wait for TBA to accept uploads")
+}
+
+func cliFetchMatches(fs *flag.FlagSet) func() error {
+	flags := addCliMatchFlags(fs)
+	return func() error {
+		options, err := flags.parseOptions()
+		if err != nil {
+			return err
+		}
+		matches, err := fetchPendingMatches(options, *flags.all)
+		if err != nil {
+			return err
+		}
+		return printCliJson(matches)
+	}
+}
+
+type cliUploadResult struct {
+	Uploaded []string          `json:"uploaded"`
+	Invalid  map[string]string `json:"invalid,omitempty"`
+}
+
+func cliUploadMatches(fs *flag.FlagSet) func() error {
+	flags := addCliMatchFlags(fs)
+	timeout := addCliTimeoutFlag(fs)
+	return func() error {
+		options, err := flags.parseOptions()
+		if err != nil {
+			return err
+		}
+		params, err := flags.eventParams()
+		if err != nil {
+			return err
+		}
+		if !isDryRun() {
+			// so that matches already in the queue are not uploaded again
+			if err := initCliUploadQueue(); err != nil {
+				return err
+			}
+		}
+		pending, err := fetchPendingMatches(options, *flags.all)
+		if err != nil {
+			return err
+		}
+
+		result := cliUploadResult{Uploaded: make([]string, 0), Invalid: make(map[string]string)}
+		ready := make([]map[string]interface{}, 0)
+		for _, match := range pending {
+			fms_id := match["_fms_id"].(string)
+			if err := validateMatchForUpload(match); err != nil {
+				logger.Printf("skipping match %s: %s\n", fms_id, err)
+				result.Invalid[fms_id] = err.Error()
+				continue
+			}
+			ready = append(ready, match)
+			result.Uploaded = append(result.Uploaded, fms_id)
+		}
+		if len(ready) > 0 {
+			payload, err := matchesToPayload(ready)
+			if err != nil {
+				return err
+			}
+			err = cliSendPayload(payload, params, &queueReceipts{
+				Folder:   getMatchDownloadPath(options.Level, options.Event),
+				MatchIds: result.Uploaded,
+			}, *timeout)
+			if err != nil {
+				return err
+			}
+		}
+		return printCliJson(result)
+	}
+}
+
+func cliUploadRankings(fs *flag.FlagSet) func() error {
+	flags := addCliEventFlags(fs)
+	timeout := addCliTimeoutFlag(fs)
+	return func() error {
+		params, err := flags.eventParams()
+		if err != nil {
+			return err
+		}
+		level, err := flags.matchLevel()
+		if err != nil {
+			return err
+		}
+		fms_rankings, err := downloadRankings(level, params.Event)
+		if err != nil {
+			return fmt.Errorf("ranking fetch failed: %s", err)
+		}
+		rankings, err := convertFMSRankings(parseEventYear(params.Event), fms_rankings)
+		if err != nil {
+			return err
+		}
+		err = cliSendPayload(rankings, params, nil, *timeout)
+		if err != nil {
+			return err
+		}
+		logger.Printf("uploaded %d rankings\n", len(rankings.Rankings))
+		return nil
+	}
+}
+
+func cliUploadAlliances(fs *flag.FlagSet) func() error {
+	flags := addCliEventFlags(fs)
+	timeout := addCliTimeoutFlag(fs)
+	filename := fs.String("file", "-", "JSON file with a list of alliances (lists of team keys), or - for stdin")
+	return func() error {
+		params, err := flags.eventParams()
+		if err != nil {
+			return err
+		}
+		var contents []byte
+		if *filename == "-" {
+			contents, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			contents, err = ioutil.ReadFile(*filename)
+		}
+		if err != nil {
+			return err
+		}
+		var alliances tba.AllianceSelections
+		err = json.Unmarshal(contents, &alliances)
+		if err != nil {
+			return fmt.Errorf("invalid alliances: %s", err)
+		}
+		// the upload queue saves the alliances once TBA has accepted them
+		return cliSendPayload(alliances, params, nil, *timeout)
+	}
+}
+
+func cliPurge(fs *flag.FlagSet) func() error {
+	flags := addCliEventFlags(fs)
+	ids := fs.String("ids", "", "comma-separated FMS match IDs (e.g. 12-1)")
+	all := fs.Bool("all", false, "purge all matches")
+	return func() error {
+		event, err := flags.checkEvent()
+		if err != nil {
+			return err
+		}
+		level, err := flags.matchLevel()
+		if err != nil {
+			return err
+		}
+		if (*ids == "") == !*all {
+			return errors.New("exactly one of -ids and -all is required")
+		}
+		match_ids := make([]string, 0)
+		if *ids != "" {
+			match_ids = strings.Split(*ids, ",")
+		}
+		return purgeMatches(level, event, match_ids, *all)
+	}
+}
+
+func cliReport(fs *flag.FlagSet) func() error {
+	report_type := fs.String("type", "", "FMS report type (required)")
+	return func() error {
+		if *report_type == "" {
+			return errors.New("-type is required")
+		}
+		report, err := downloadReport(*report_type)
+		if err != nil {
+			return fmt.Errorf("failed to download report %s: %s", *report_type, err)
+		}
+		return printCliJson(report)
+	}
+}
+
+func cliReconcile(fs *flag.FlagSet) func() error {
+	flags := addCliEventFlags(fs)
+	return func() error {
+		event, err := flags.checkEvent()
+		if err != nil {
+			return err
+		}
+		level, err := flags.matchLevel()
+		if err != nil {
+			return err
+		}
+		report, err := reconcileMatches(event, level)
+		if err != nil {
+			return err
+		}
+		return printCliJson(report)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCliMatchOptions(t *testing.T) {
+	old_settings := event_settings
+	defer func() { event_settings = old_settings }()
+	playoff_type := 10
+	event_settings = map[string]eventSettings{"2023test": {PlayoffType: &playoff_type, EnabledExtraRps: []bool{true, false}}}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := addCliMatchFlags(fs)
+	fs.Parse([]string{"-event", "2023test", "-level", "playoff"})
+	options, err := flags.parseOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Level != MATCH_LEVEL_PLAYOFF || options.PlayoffType != 10 || !options.EnabledExtraRps[0] {
+		t.Error("event settings not used:", options)
+	}
+
+	fs.Parse([]string{"-event", "2023test", "-level", "2", "-playoff-type", "4", "-extra-rps", "false,true"})
+	options, err = flags.parseOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if options.Level != MATCH_LEVEL_QUAL || options.PlayoffType != 4 || options.EnabledExtraRps[0] || !options.EnabledExtraRps[1] {
+		t.Error("flags not used:", options)
+	}
+
+	fs.Parse([]string{"-event", "2023test", "-level", "finals"})
+	if _, err := flags.parseOptions(); err == nil {
+		t.Error("invalid level accepted")
+	}
+}
+
+func TestCliUploadAlliances(t *testing.T) {
+	_, fake := startWebTestServer(t)
+	filename := filepath.Join(t.TempDir(), "alliances.json")
+	ioutil.WriteFile(filename, []byte(`[["frc1", "frc2", "frc3"]]`), 0644)
+
+	fs := flag.NewFlagSet("upload-alliances", flag.ContinueOnError)
+	run := cliUploadAlliances(fs)
+	fs.Parse([]string{"-event", "2023test", "-auth", "auth", "-secret", "secret", "-file", filename})
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Alliances("2023test")) != 1 {
+		t.Error("alliances not uploaded")
+	}
+	if alliances, err := loadEventAlliances("2023test"); err != nil || len(alliances) != 1 {
+		t.Error("alliances not saved:", alliances, err)
+	}
+
+	fs = flag.NewFlagSet("upload-alliances", flag.ContinueOnError)
+	run = cliUploadAlliances(fs)
+	fs.Parse([]string{"-event", "2023test", "-file", filename})
+	if err := run(); err == nil {
+		t.Error("upload without credentials accepted")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/fakefms"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] FOLDER\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	host := flag.String("host", "127.0.0.1", "host to listen on")
+	port := flag.Int("port", 5555, "port to listen on")
+	record_url := flag.String("record", "", "record traffic to this FMS URL into FOLDER instead of serving FOLDER")
+	interval := flag.Duration("interval", 0, "release one recorded match every interval (e.g. 30s), instead of serving all matches at once")
+	timeline_file := flag.String("timeline", "", "JSON timeline options (levels, injected edits and replays); implies a timeline")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	folder := flag.Arg(0)
+
+	var server http.Handler
+	if *record_url != "" {
+		os.MkdirAll(folder, os.ModePerm)
+		server = fakefms.NewRecorder(*record_url, folder)
+		log.Printf("recording %s into %s", *record_url, folder)
+	} else {
+		fms, err := fakefms.NewServer(folder)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server = fms
+		log.Printf("serving %s", folder)
+
+		if *interval > 0 || *timeline_file != "" {
+			var options fakefms.TimelineOptions
+			if *timeline_file != "" {
+				options, err = fakefms.LoadTimelineOptions(*timeline_file)
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			options.Interval = *interval
+			timeline, err := fms.StartTimeline(options)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if *interval > 0 {
+				log.Printf("timeline: %d steps, one every %s", timeline.Status().Steps, *interval)
+			} else {
+				log.Printf("timeline: %d steps, advance with POST /api/timeline/advance", timeline.Status().Steps)
+			}
+		}
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		server.ServeHTTP(w, r)
+		log.Printf("%s %s %s (%s)", r.RemoteAddr, r.Method, r.URL.RequestURI(), time.Since(start))
+	})
+
+	addr := fmt.Sprintf("%s:%d", *host, *port)
+	log.Printf("listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, handler))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba/faketba"
+)
+
+func main() {
+	port := flag.Int("port", 8809, "port to listen on")
+	credentials := flag.String("credentials", "", "comma-separated list of event:auth_id:secret to accept")
+	read_key := flag.String("read-key", "", "require this read API key (default: accept any)")
+	fault_status := flag.Int("fail-status", 0, "respond to the first -fail-count requests with this status")
+	fault_delay := flag.Duration("fail-delay", 0, "delay the first -fail-count requests by this long")
+	fault_count := flag.Int("fail-count", 1, "number of requests to apply -fail-status/-fail-delay to")
+	flag.Parse()
+
+	server := faketba.NewServer()
+	server.ReadKey = *read_key
+	for _, entry := range strings.Split(*credentials, ",") {
+		if entry == "" {
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 {
+			log.Fatalf("invalid credentials (expected event:auth_id:secret): %s", entry)
+		}
+		server.AddCredentials(parts[0], parts[1], parts[2])
+	}
+	if *fault_status != 0 || *fault_delay != 0 {
+		for i := 0; i < *fault_count; i++ {
+			server.InjectFault(faketba.Fault{Status: *fault_status, Delay: *fault_delay})
+		}
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		server.ServeHTTP(w, r)
+		log.Printf("%s %s %s (%s)", r.RemoteAddr, r.Method, r.URL.Path, time.Since(start))
+	})
+
+	addr := fmt.Sprintf(":%d", *port)
+	log.Printf("listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, handler))
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+const configFilename = "config.json"
+
+var eventKeyPattern = regexp.MustCompile(`^\d{4}[a-z0-9]+$`)
+
+// per-event settings that would otherwise only be stored in the browser
+type eventSettings struct {
+	PlayoffType     *int   `json:"playoff_type,omitempty"`
+	EnabledExtraRps []bool `json:"enabled_extra_rps,omitempty"`
+	DefaultLevel    *int   `json:"default_level,omitempty"`
+}
+
+// contents of config.json in the data folder. The data folder itself is
+// only set by flags.
+type serverConfig struct {
+	FmsUrl string `json:"fms_url,omitempty"`
+	TbaUrl string `json:"tba_url,omitempty"`
+	// only kept until it can be moved to the key store (see migrateLegacyReadKey)
+	TbaReadKey string                   `json:"tba_read_key,omitempty"`
+	Events     map[string]eventSettings `json:"events,omitempty"`
+	Auth       authConfig               `json:"auth"`
+	// hosts that /api/proxy may forward to, in addition to the TBA host. Not
+	// omitted when empty, since an empty list is not the same as the default.
+	ProxyAllowedHosts []string `json:"proxy_allowed_hosts"`
+	DryRun            bool     `json:"dry_run,omitempty"`
+}
+
+var config_mutex sync.Mutex
+var event_settings = make(map[string]eventSettings)
+
+// read API key from a config.json written by an older version; guarded by config_mutex
+var legacy_tba_read_key string
+
+// fields (by JSON name) overridden by command-line flags, and the values that
+// config.json had when it was loaded. These are saved instead of the flag
+// values, so that flags only apply to one run. Guarded by config_mutex.
+var config_flag_fields = make(map[string]bool)
+var config_file_values serverConfig
+
+func getConfigPath() string {
+	return filepath.Join(FMSConfig.DataFolder, configFilename)
+}
+
+func validateConfigUrl(name string, value string) error {
+	if value == "" {
+		return nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("%s: %s", name, err)
+	} else if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%s: expected an http or https URL, got %q", name, value)
+	}
+	return nil
+}
+
+func (settings eventSettings) validate() error {
+	if settings.PlayoffType != nil && *settings.PlayoffType != BRACKET_TYPE_CUSTOM && tba.GetBracket(*settings.PlayoffType) == nil {
+		return fmt.Errorf("unsupported playoff_type: %d", *settings.PlayoffType)
+	}
+	if settings.DefaultLevel != nil {
+		switch *settings.DefaultLevel {
+		case MATCH_LEVEL_TEST, MATCH_LEVEL_PRACTICE, MATCH_LEVEL_QUAL, MATCH_LEVEL_PLAYOFF, MATCH_LEVEL_MANUAL:
+		default:
+			return fmt.Errorf("invalid default_level: %d", *settings.DefaultLevel)
+		}
+	}
+	return nil
+}
+
+func (config serverConfig) validate() error {
+	if err := validateConfigUrl("fms_url", config.FmsUrl); err != nil {
+		return err
+	}
+	if err := validateConfigUrl("tba_url", config.TbaUrl); err != nil {
+		return err
+	}
+	for _, host := range config.ProxyAllowedHosts {
+		if err := validateProxyHost(host); err != nil {
+			return fmt.Errorf("proxy_allowed_hosts: %s", err)
+		}
+	}
+	if err := config.Auth.validate(); err != nil {
+		return fmt.Errorf("auth: %s", err)
+	}
+	for event, settings := range config.Events {
+		if !eventKeyPattern.MatchString(event) {
+			return fmt.Errorf("invalid event key: %q", event)
+		}
+		if err := settings.validate(); err != nil {
+			return fmt.Errorf("%s: %s", event, err)
+		}
+	}
+	return nil
+}
+
+// load config.json from the data folder. Fields in skip (by JSON name) are
+// not applied, so that command-line flags take precedence. A missing file is
+// not an error.
+func loadServerConfig(skip map[string]bool) error {
+	config_mutex.Lock()
+	config_flag_fields = make(map[string]bool)
+	for field, skipped := range skip {
+		config_flag_fields[field] = skipped
+	}
+	config_file_values = serverConfig{}
+	config_mutex.Unlock()
+
+	contents, err := ioutil.ReadFile(getConfigPath())
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var config serverConfig
+	err = json.Unmarshal(contents, &config)
+	if err != nil {
+		return fmt.Errorf("%s: %s", configFilename, err)
+	}
+	err = config.validate()
+	if err != nil {
+		return fmt.Errorf("%s: %s", configFilename, err)
+	}
+
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	config_file_values = config
+	if config.FmsUrl != "" && !skip["fms_url"] {
+		FMSConfig.FmsUrl = config.FmsUrl
+	}
+	if config.TbaUrl != "" && !skip["tba_url"] {
+		FMSConfig.TbaUrl = config.TbaUrl
+	}
+	legacy_tba_read_key = config.TbaReadKey
+	if !skip["dry_run"] {
+		FMSConfig.DryRun = config.DryRun
+	}
+	auth_config = config.Auth
+	proxy_allowed_hosts = config.ProxyAllowedHosts
+	event_settings = config.Events
+	if event_settings == nil {
+		event_settings = make(map[string]eventSettings)
+	}
+	return nil
+}
+
+// write contents to filename via a temporary file, so that readers never see a partial file
+func writeFileAtomic(filename string, contents []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(contents)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// must be called with config_mutex held
+func saveServerConfigLocked() error {
+	config := serverConfig{
+		FmsUrl:            FMSConfig.FmsUrl,
+		TbaUrl:            FMSConfig.TbaUrl,
+		TbaReadKey:        legacy_tba_read_key,
+		Events:            event_settings,
+		Auth:              auth_config,
+		ProxyAllowedHosts: proxy_allowed_hosts,
+		DryRun:            FMSConfig.DryRun,
+	}
+	if config_flag_fields["fms_url"] {
+		config.FmsUrl = config_file_values.FmsUrl
+	}
+	if config_flag_fields["tba_url"] {
+		config.TbaUrl = config_file_values.TbaUrl
+	}
+	if config_flag_fields["dry_run"] {
+		config.DryRun = config_file_values.DryRun
+	}
+	out, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(FMSConfig.DataFolder, os.ModePerm)
+	return writeFileAtomic(getConfigPath(), out)
+}
+
+// move a read API key from an older config.json to the key store, so that it
+// is no longer stored in plaintext
+func migrateLegacyReadKey() error {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	if legacy_tba_read_key == "" || key_store == nil {
+		return nil
+	}
+	if key_store.ReadApiKey() == "" {
+		if err := key_store.SetReadApiKey(legacy_tba_read_key); err != nil {
+			return err
+		}
+	}
+	legacy_tba_read_key = ""
+	return saveServerConfigLocked()
+}
+
+func getLegacyReadKey() string {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	return legacy_tba_read_key
+}
+
+func saveServerConfig() error {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	return saveServerConfigLocked()
+}
+
+func getEventSettings(event string) eventSettings {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	return event_settings[event]
+}
+
+func setEventSettings(event string, settings eventSettings) error {
+	if !eventKeyPattern.MatchString(event) {
+		return fmt.Errorf("invalid event key: %q", event)
+	}
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	event_settings[event] = settings
+	return saveServerConfigLocked()
+}
+
+func apiGetEventSettings(w http.ResponseWriter, r *http.Request) {
+	sendJson(w, getEventSettings(checkRequestQueryParam(r, "event")))
+}
+
+func apiSetEventSettings(w http.ResponseWriter, r *http.Request) {
+	event := checkRequestQueryParam(r, "event")
+	var settings eventSettings
+	body, _ := ioutil.ReadAll(r.Body)
+	err := json.Unmarshal(body, &settings)
+	if err != nil {
+		apiPanicBadRequest("invalid settings: %s", err)
+	}
+	err = setEventSettings(event, settings)
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	sendJson(w, settings)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestServerConfig(t *testing.T) {
+	old_config := FMSConfig
+	defer func() {
+		FMSConfig = old_config
+		config_flag_fields = make(map[string]bool)
+		os.Remove(getConfigPath())
+	}()
+
+	FMSConfig.FmsUrl = "http://10.0.100.5"
+	FMSConfig.TbaUrl = "http://localhost:8809"
+	playoff_type := BRACKET_TYPE_DOUBLE_ELIM_8_TEAM
+	if err := setEventSettings("2023test", eventSettings{PlayoffType: &playoff_type}); err != nil {
+		t.Fatal(err)
+	}
+	invalid_type := 1000
+	if err := setEventSettings("2023test", eventSettings{PlayoffType: &invalid_type}); err == nil {
+		t.Error("invalid playoff type accepted")
+	}
+	if err := setEventSettings("bad key", eventSettings{}); err == nil {
+		t.Error("invalid event key accepted")
+	}
+
+	FMSConfig.FmsUrl = ""
+	FMSConfig.TbaUrl = "http://flag"
+	event_settings = make(map[string]eventSettings)
+	if err := loadServerConfig(map[string]bool{"tba_url": true}); err != nil {
+		t.Fatal(err)
+	}
+	if FMSConfig.FmsUrl != "http://10.0.100.5" || FMSConfig.TbaUrl != "http://flag" {
+		t.Error("unexpected config after load:", FMSConfig)
+	}
+	if settings := getEventSettings("2023test"); settings.PlayoffType == nil || *settings.PlayoffType != playoff_type {
+		t.Error("event settings not loaded:", settings)
+	}
+
+	// values from flags are not saved
+	FMSConfig.DryRun = true
+	if err := loadServerConfig(map[string]bool{"tba_url": true, "dry_run": true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := setEventSettings("2023test", eventSettings{}); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := ioutil.ReadFile(getConfigPath())
+	var saved serverConfig
+	json.Unmarshal(raw, &saved)
+	if saved.TbaUrl != "http://localhost:8809" || saved.DryRun {
+		t.Error("flag values saved to config.json:", string(raw))
+	}
+
+	ioutil.WriteFile(getConfigPath(), []byte(`{"fms_url": "10.0.100.5"}`), os.ModePerm)
+	if err := loadServerConfig(nil); err == nil {
+		t.Error("invalid fms_url accepted")
+	}
+}
+
+func TestReadKeyNotInConfig(t *testing.T) {
+	old_config := FMSConfig
+	defer func() {
+		FMSConfig = old_config
+		legacy_tba_read_key = ""
+		os.Remove(getConfigPath())
+	}()
+
+	FMSConfig.TbaReadKey = "flag-read-key"
+	if out, _ := json.Marshal(FMSConfig); strings.Contains(string(out), "flag-read-key") {
+		t.Error("read key included in config response:", string(out))
+	}
+	saveServerConfig()
+	if raw, _ := ioutil.ReadFile(getConfigPath()); strings.Contains(string(raw), "flag-read-key") {
+		t.Error("read key saved to config.json:", string(raw))
+	}
+
+	// a key from an older config.json is moved to the key store
+	ioutil.WriteFile(getConfigPath(), []byte(`{"tba_read_key": "old-read-key"}`), os.ModePerm)
+	if err := loadServerConfig(nil); err != nil {
+		t.Fatal(err)
+	}
+	FMSConfig.TbaReadKey = ""
+	if key := getTBAReadKey(); key != "old-read-key" {
+		t.Errorf("got read key %q, expected %q", key, "old-read-key")
+	}
+	ks, err := openKeyStore(path.Join(t.TempDir(), keyStoreFilename), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_store = ks
+	defer func() { key_store = nil }()
+	if err := migrateLegacyReadKey(); err != nil {
+		t.Fatal(err)
+	}
+	if ks.ReadApiKey() != "old-read-key" {
+		t.Error("read key not moved to the key store")
+	}
+	if raw, _ := ioutil.ReadFile(getConfigPath()); strings.Contains(string(raw), "old-read-key") {
+		t.Error("read key left in config.json:", string(raw))
+	}
+}
+
+func TestSetFMSConfigDataFolder(t *testing.T) {
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/fms_config/set", ROLE_ADMIN, apiSetFMSConfig)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	data_folder := FMSConfig.DataFolder
+	res, err := http.Post(server.URL+"/api/fms_config/set", "application/json", strings.NewReader(`{"data_folder": "/elsewhere"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest || FMSConfig.DataFolder != data_folder {
+		t.Errorf("data_folder changed: got status %d, data folder %s", res.StatusCode, FMSConfig.DataFolder)
+	}
+}
+
+func TestSetFMSConfigProxyAllowedHosts(t *testing.T) {
+	old_config, old_hosts := FMSConfig, proxy_allowed_hosts
+	defer func() {
+		FMSConfig, proxy_allowed_hosts = old_config, old_hosts
+		config_flag_fields = make(map[string]bool)
+	}()
+	FMSConfig.DataFolder = t.TempDir()
+	proxy_allowed_hosts = nil
+
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/fms_config/set", ROLE_ADMIN, apiSetFMSConfig)
+	server := httptest.NewServer(r)
+	defer server.Close()
+	set := func(body string) int {
+		res, err := http.Post(server.URL+"/api/fms_config/set", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := set(`{"proxy_allowed_hosts": ["example.com/path"]}`); status != http.StatusBadRequest || proxy_allowed_hosts != nil {
+		t.Error("invalid proxy host accepted:", status, proxy_allowed_hosts)
+	}
+	if status := set(`{"proxy_allowed_hosts": []}`); status != http.StatusOK || proxy_allowed_hosts == nil || len(proxy_allowed_hosts) != 0 {
+		t.Error("empty proxy host list not applied:", status, proxy_allowed_hosts)
+	}
+	if err := loadServerConfig(nil); err != nil || proxy_allowed_hosts == nil {
+		t.Error("empty proxy host list not saved:", err, proxy_allowed_hosts)
+	}
+	if status := set(`{"proxy_allowed_hosts": ["example.com:8080"]}`); status != http.StatusOK || !reflect.DeepEqual(getApiFMSConfig().ProxyAllowedHosts, []string{"example.com:8080"}) {
+		t.Error("proxy host list not applied:", status, proxy_allowed_hosts)
+	}
+	// settings sent without the list keep it
+	if status := set(`{"dry_run": false}`); status != http.StatusOK || len(proxy_allowed_hosts) != 1 {
+		t.Error("proxy host list changed:", status, proxy_allowed_hosts)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// whether dry-run mode is enabled for the server, in which case nothing is sent
+// to TBA and the upload queue is held
+func isDryRun() bool {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	return FMSConfig.DryRun
+}
+
+// whether a request to TBA should be validated and shown instead of sent:
+// dry-run mode is enabled for the server, or the request has dry_run=true (or
+// an X-Dry-Run: true header)
+func isRequestDryRun(r *http.Request) bool {
+	if isDryRun() {
+		return true
+	}
+	value := r.URL.Query().Get("dry_run")
+	if value == "" {
+		value = r.Header.Get("X-Dry-Run")
+	}
+	if value == "" {
+		return false
+	}
+	dry_run, err := strconv.ParseBool(value)
+	if err != nil {
+		apiPanicBadRequest("invalid dry_run: %q", value)
+	}
+	return dry_run
+}
+
+// validate and sign a payload like queueTBAPayload, and record it in the
+// audit log, but do not send it. Returns the request that would have been sent.
+func previewTBAPayload(payload tba.Payload, params *tba.EventParams, operator string) (tba.RequestPreview, error) {
+	request, err := tba.NewClient(FMSConfig.TbaUrl, *params).NewRequest(payload)
+	if err != nil {
+		return tba.RequestPreview{}, err
+	}
+	logger.Printf("dry run: %s for %s not sent\n", request.Path, request.Event)
+	writeAuditRecord(newAuditRecord(&queueEntry{
+		Request:  request,
+		Hash:     requestHash(request),
+		Operator: operator,
+	}, AUDIT_RESULT_DRY_RUN))
+	return request.Preview(), nil
+}
+
+func sendTBADryRun(w http.ResponseWriter, payload tba.Payload, params *tba.EventParams, operator string) {
+	preview, err := previewTBAPayload(payload, params, operator)
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	writeApiResponse(w, http.StatusOK, apiResponse{Status: API_STATUS_DRY_RUN, Data: preview})
+}
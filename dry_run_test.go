@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+func TestDryRun(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	os.MkdirAll(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), os.ModePerm)
+
+	status, body := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids=1-1&dry_run=true", "secret", webTestMatches)
+	if status != http.StatusOK {
+		t.Fatalf("dry run failed: %d %s", status, body)
+	}
+	var res struct {
+		Status string
+		Data   tba.RequestPreview
+	}
+	if err := json.Unmarshal([]byte(body), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != API_STATUS_DRY_RUN {
+		t.Errorf("got status %q, expected %q", res.Status, API_STATUS_DRY_RUN)
+	}
+	if res.Data.Url != FMSConfig.TbaUrl+"/api/trusted/v1/event/2023test/matches/update" || res.Data.Headers["X-TBA-Auth-Sig"] == "" {
+		t.Error("unexpected request:", res.Data)
+	}
+	if len(fake.Matches("2023test")) != 0 {
+		t.Error("dry run sent matches to TBA")
+	}
+	if len(upload_queue.List()) != 0 {
+		t.Error("dry run queued a request")
+	}
+	if isFile(path.Join(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), "1-1.receipt")) {
+		t.Error("dry run marked a match as uploaded")
+	}
+	if records, _ := readAuditLog(auditFilter{Event: "2023test"}); len(records) != 1 || records[0].Result != AUDIT_RESULT_DRY_RUN {
+		t.Error("dry run not recorded in the audit log:", records)
+	}
+
+	// invalid payloads are rejected, as they would be when sent
+	if status, _ := postWebTest(t, server.URL+"/api/alliances/upload?dry_run=1", "secret", `[["254"]]`); status != http.StatusBadRequest {
+		t.Errorf("invalid payload: got status %d, expected %d", status, http.StatusBadRequest)
+	}
+
+	if status, _ := postWebTest(t, server.URL+"/api/alliances/upload?dry_run=1", "secret", `[["frc1", "frc2", "frc3"]]`); status != http.StatusOK {
+		t.Errorf("alliances dry run: got status %d, expected %d", status, http.StatusOK)
+	}
+	if alliances, _ := loadEventAlliances("2023test"); len(alliances) != 0 {
+		t.Error("dry run saved alliances:", alliances)
+	}
+
+	setDryRun := func(dry_run bool) {
+		config_mutex.Lock()
+		FMSConfig.DryRun = dry_run
+		config_mutex.Unlock()
+	}
+	setDryRun(true)
+	defer setDryRun(false)
+	if status, body := postWebTest(t, server.URL+"/api/matches/upload", "secret", webTestMatches); status != http.StatusOK || len(fake.Matches("2023test")) != 0 {
+		t.Errorf("global dry run sent matches to TBA: %d %s", status, body)
+	}
+
+	// requests queued before dry-run mode was enabled are held
+	request, _ := tba.NewClient(FMSConfig.TbaUrl, tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}).NewRequest(tba.MatchList{})
+	id := upload_queue.Submit(request, nil, "test")
+	if result := upload_queue.Wait(id, 200*time.Millisecond); result.State != QUEUE_STATE_PENDING {
+		t.Error("queue delivered a request in dry-run mode:", result)
+	}
+}
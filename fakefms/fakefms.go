@@ -0,0 +1,359 @@
+// Package fakefms serves a recorded event folder in place of a live FMS, and
+// records live FMS traffic into that format.
+//
+// Event folder layout:
+//
+//	level{N}/match_list.html       FieldMonitor/MatchesPartialByLevel?levelParam=N
+//	level{N}/matches/{match}.html  FieldMonitor/Matches/Score?matchId=..., named by match list button text
+//	rankings.json                  Pit/GetData
+//	rankings/{N}.json              Pit/GetData after N qualification matches (optional, for timelines)
+//	reports/json/{type}.{page}.json  Reports/PostReportAction (GetPageModel)
+package fakefms
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	MAX_LEVEL  = 3
+	QUAL_LEVEL = 2
+)
+
+// A match from a FieldMonitor match list
+type Match struct {
+	// FMS match ID (a UUID)
+	Id string
+	// match and play number from the button text, e.g. "12-1"
+	Name string
+}
+
+func matchListFilename(level int) string {
+	return fmt.Sprintf("level%d/match_list.html", level)
+}
+
+func matchFilename(level int, name string) string {
+	return fmt.Sprintf("level%d/matches/%s.html", level, name)
+}
+
+func rankingSnapshotFilename(matches int) string {
+	return fmt.Sprintf("rankings/%d.json", matches)
+}
+
+func reportFilename(report_type string, page int) string {
+	return fmt.Sprintf("reports/json/%s.%d.json", report_type, page)
+}
+
+// parse a MatchesPartialByLevel page, the same way TBA-uploader does
+func ParseMatchList(list_html []byte) ([]Match, error) {
+	dom, err := goquery.NewDocumentFromReader(bytes.NewReader(list_html))
+	if err != nil {
+		return nil, err
+	}
+	matches := make([]Match, 0)
+	dom.Find("tbody tr").Each(func(i int, row *goquery.Selection) {
+		href, ok := row.Find("a").First().Attr("href")
+		if !ok {
+			return
+		}
+		parts := strings.SplitN(href, "matchId=", 2)
+		if len(parts) != 2 {
+			return
+		}
+		name := strings.Replace(row.Find("button").First().Text(), " ", "", -1)
+		name = strings.Replace(name, "/", "-", -1)
+		matches = append(matches, Match{Id: parts[1], Name: name})
+	})
+	return matches, nil
+}
+
+type Server struct {
+	Folder string
+
+	mux           *http.ServeMux
+	mutex         sync.Mutex
+	report_tokens map[string]bool
+	timeline      *Timeline
+}
+
+// A match and the page served for it
+type servedMatch struct {
+	Match
+	File string
+}
+
+func NewServer(folder string) (*Server, error) {
+	info, err := os.Stat(folder)
+	if err != nil {
+		return nil, err
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("not a folder: %s", folder)
+	}
+	s := &Server{
+		Folder:        folder,
+		mux:           http.NewServeMux(),
+		report_tokens: make(map[string]bool),
+	}
+	s.mux.HandleFunc("/FieldMonitor/MatchesPartialByLevel", s.serveMatchList)
+	s.mux.HandleFunc("/FieldMonitor/Matches/Score", s.serveMatch)
+	s.mux.HandleFunc("/Pit/GetData", s.serveRankings)
+	s.mux.HandleFunc("/Reports/PostReportAction", s.serveReport)
+	s.mux.HandleFunc("/api/index/", s.serveIndex)
+	s.mux.HandleFunc("/api/timeline", s.serveTimeline)
+	s.mux.HandleFunc("/api/timeline/advance", s.serveTimelineAdvance)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, filename string) {
+	contents, err := ioutil.ReadFile(path.Join(s.Folder, filename))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("not found: %s", filename), http.StatusNotFound)
+		return
+	}
+	if strings.HasSuffix(filename, ".json") {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	w.Write(contents)
+}
+
+// matches listed for a level; missing levels have no matches
+func (s *Server) levelMatches(level int) []Match {
+	contents, err := ioutil.ReadFile(path.Join(s.Folder, matchListFilename(level)))
+	if err != nil {
+		return nil
+	}
+	matches, err := ParseMatchList(contents)
+	if err != nil {
+		log.Printf("failed to parse level %d match list: %s", level, err)
+	}
+	return matches
+}
+
+func (s *Server) getTimeline() *Timeline {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.timeline
+}
+
+// matches currently listed for a level
+func (s *Server) servedMatches(level int) []servedMatch {
+	if timeline := s.getTimeline(); timeline != nil {
+		return timeline.servedMatches(level)
+	}
+	matches := s.levelMatches(level)
+	if matches == nil {
+		return nil
+	}
+	out := make([]servedMatch, len(matches))
+	for i, match := range matches {
+		out[i] = servedMatch{Match: match, File: matchFilename(level, match.Name)}
+	}
+	return out
+}
+
+func (s *Server) serveMatchList(w http.ResponseWriter, r *http.Request) {
+	level, err := strconv.Atoi(r.URL.Query().Get("levelParam"))
+	if err != nil {
+		http.Error(w, "invalid levelParam", http.StatusBadRequest)
+		return
+	}
+	timeline := s.getTimeline()
+	if timeline == nil {
+		s.serveFile(w, matchListFilename(level))
+		return
+	}
+	list_html, err := timeline.matchList(level)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("not found: %s", matchListFilename(level)), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(list_html)
+}
+
+func (s *Server) serveMatch(w http.ResponseWriter, r *http.Request) {
+	match_id := r.URL.Query().Get("matchId")
+	for level := 0; level <= MAX_LEVEL; level++ {
+		for _, match := range s.servedMatches(level) {
+			if match.Id == match_id {
+				s.serveFile(w, match.File)
+				return
+			}
+		}
+	}
+	http.Error(w, "invalid match ID", http.StatusNotFound)
+}
+
+func (s *Server) serveRankings(w http.ResponseWriter, r *http.Request) {
+	// FMS only returns qualification rankings when requested from the pit display
+	if !strings.HasSuffix(r.Header.Get("Referer"), "/Pit/Qual") {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("null"))
+		return
+	}
+	filename := "rankings.json"
+	if timeline := s.getTimeline(); timeline != nil {
+		filename = timeline.rankingsFile()
+		if filename == "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"qualRanks":[]}`))
+			return
+		}
+	}
+	s.serveFile(w, filename)
+}
+
+// returns an error describing the first field of actual that does not match expected
+func validateFields(desc string, actual func(string) (interface{}, bool), expected map[string]interface{}) error {
+	for field, expected_value := range expected {
+		value, ok := actual(field)
+		if !ok {
+			return fmt.Errorf("%s: missing key: %s", desc, field)
+		} else if fmt.Sprint(value) != fmt.Sprint(expected_value) {
+			return fmt.Errorf("%s: invalid %s: expected %v, got %v", desc, field, expected_value, value)
+		}
+	}
+	return nil
+}
+
+func parseReportType(body map[string]interface{}) (string, error) {
+	custom_data, _ := body["CustomData"].(string)
+	var parsed []map[string]string
+	err := json.Unmarshal([]byte(custom_data), &parsed)
+	if err != nil || len(parsed) == 0 || parsed[0]["reportType"] == "" {
+		return "", fmt.Errorf("invalid CustomData: %q", custom_data)
+	}
+	return parsed[0]["reportType"], nil
+}
+
+func newReportToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func (s *Server) serveReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	body := make(map[string]interface{})
+	raw_body, _ := ioutil.ReadAll(r.Body)
+	if json.Unmarshal(raw_body, &body) != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	report_type, err := parseReportType(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	body_field := func(field string) (interface{}, bool) {
+		value, ok := body[field]
+		return value, ok
+	}
+
+	err = validateFields("headers", func(field string) (interface{}, bool) {
+		return r.Header.Get(field), r.Header.Get(field) != ""
+	}, map[string]interface{}{
+		"Content-Type": "application/json; charset=UTF-8",
+		"Origin":       "http://10.0.100.5",
+		"Referer":      "http://10.0.100.5/Reports/" + report_type,
+	})
+	if err == nil {
+		err = validateFields("body", body_field, map[string]interface{}{
+			"controlId":       "sfreportviewer",
+			"reportPath":      "",
+			"reportServerUrl": "",
+			"processingMode":  "local",
+			"locale":          "en-US",
+		})
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	switch body["reportAction"] {
+	case "ReportLoad":
+		token := newReportToken()
+		s.mutex.Lock()
+		s.report_tokens[token] = true
+		s.mutex.Unlock()
+		out, _ := json.Marshal(map[string]string{
+			"reportViewerID":    token,
+			"reportViewerToken": token,
+		})
+		w.Write(out)
+	case "GetPageModel":
+		err = validateFields("body", body_field, map[string]interface{}{
+			"dataRefresh": true,
+			"dataSources": nil,
+			"isPrint":     true,
+			"pageInit":    true,
+			"parameters":  nil,
+			"refresh":     false,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page, _ := body["pageindex"].(float64)
+		if page < 1 {
+			http.Error(w, fmt.Sprintf("invalid page: %v", body["pageindex"]), http.StatusBadRequest)
+			return
+		}
+		token, _ := body["reportViewerToken"].(string)
+		if body["reportViewerClientId"] != token {
+			http.Error(w, "tokens do not match", http.StatusBadRequest)
+			return
+		}
+		s.mutex.Lock()
+		valid_token := s.report_tokens[token]
+		s.mutex.Unlock()
+		if !valid_token {
+			http.Error(w, "invalid token", http.StatusBadRequest)
+			return
+		}
+		s.serveFile(w, reportFilename(report_type, int(page)))
+	default:
+		http.Error(w, fmt.Sprintf("invalid reportAction: %v", body["reportAction"]), http.StatusBadRequest)
+	}
+}
+
+// level -> {match ID: name}
+func (s *Server) serveIndex(w http.ResponseWriter, r *http.Request) {
+	index := make(map[int]map[string]string)
+	for level := 0; level <= MAX_LEVEL; level++ {
+		matches := s.servedMatches(level)
+		if matches == nil {
+			continue
+		}
+		index[level] = make(map[string]string)
+		for _, match := range matches {
+			index[level][match.Id] = match.Name
+		}
+	}
+	out, _ := json.Marshal(index)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
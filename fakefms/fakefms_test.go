@@ -0,0 +1,215 @@
+package fakefms
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFolder = "../tests/data/fakefms/2023milak"
+
+func get(t *testing.T, url string, referer string) (int, []byte) {
+	request, _ := http.NewRequest("GET", url, nil)
+	if referer != "" {
+		request.Header.Set("Referer", referer)
+	}
+	res, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, body
+}
+
+func postReport(t *testing.T, url string, fields map[string]interface{}) (int, map[string]interface{}) {
+	body := map[string]interface{}{
+		"controlId":       "sfreportviewer",
+		"reportPath":      "",
+		"reportServerUrl": "",
+		"processingMode":  "local",
+		"locale":          "en-US",
+		"CustomData":      `[{"reportType": "ScheduleReportQualification"}]`,
+	}
+	for field, value := range fields {
+		body[field] = value
+	}
+	raw, _ := json.Marshal(body)
+	request, _ := http.NewRequest("POST", url+"/Reports/PostReportAction", bytes.NewReader(raw))
+	request.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	request.Header.Set("Origin", "http://10.0.100.5")
+	request.Header.Set("Referer", "http://10.0.100.5/Reports/ScheduleReportQualification")
+	res, err := http.DefaultClient.Do(request)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+	out := make(map[string]interface{})
+	res_body, _ := ioutil.ReadAll(res.Body)
+	json.Unmarshal(res_body, &out)
+	return res.StatusCode, out
+}
+
+func fetchReportPage(t *testing.T, url string, page int) (int, map[string]interface{}) {
+	_, load := postReport(t, url, map[string]interface{}{"reportAction": "ReportLoad"})
+	token := load["reportViewerID"]
+	return postReport(t, url, map[string]interface{}{
+		"reportAction":         "GetPageModel",
+		"dataRefresh":          true,
+		"dataSources":          nil,
+		"isPrint":              true,
+		"pageindex":            page,
+		"pageInit":             true,
+		"parameters":           nil,
+		"refresh":              false,
+		"reportViewerClientId": token,
+		"reportViewerToken":    token,
+	})
+}
+
+func startTestServer(t *testing.T, folder string) string {
+	fms, err := NewServer(folder)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	server := httptest.NewServer(fms)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestServer(t *testing.T) {
+	url := startTestServer(t, testFolder)
+
+	status, list_html := get(t, url+"/FieldMonitor/MatchesPartialByLevel?levelParam=2", "")
+	assert.Equal(t, http.StatusOK, status)
+	matches, err := ParseMatchList(list_html)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2-1", "5-1", "20-1"}, []string{matches[0].Name, matches[1].Name, matches[2].Name})
+
+	status, _ = get(t, url+"/FieldMonitor/Matches/Score?matchId="+matches[0].Id, "")
+	assert.Equal(t, http.StatusOK, status)
+	status, _ = get(t, url+"/FieldMonitor/Matches/Score?matchId=invalid", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	_, rankings := get(t, url+"/Pit/GetData", "")
+	assert.Equal(t, "null", string(rankings))
+	_, rankings = get(t, url+"/Pit/GetData", url+"/Pit/Qual")
+	assert.Contains(t, string(rankings), "qualRanks")
+
+	status, page := fetchReportPage(t, url, 2)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, 2.0, page["reportPageModel"].(map[string]interface{})["TotalPages"])
+
+	status, _ = postReport(t, url, map[string]interface{}{
+		"reportAction":         "GetPageModel",
+		"pageindex":            1,
+		"reportViewerClientId": "bad",
+		"reportViewerToken":    "bad",
+	})
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestRecorder(t *testing.T) {
+	fms_url := startTestServer(t, testFolder)
+	folder := t.TempDir()
+	recorder := httptest.NewServer(NewRecorder(fms_url, folder))
+	defer recorder.Close()
+
+	_, list_html := get(t, recorder.URL+"/FieldMonitor/MatchesPartialByLevel?levelParam=2", "")
+	matches, _ := ParseMatchList(list_html)
+	for _, match := range matches {
+		get(t, recorder.URL+"/FieldMonitor/Matches/Score?matchId="+match.Id, "")
+	}
+	get(t, recorder.URL+"/Pit/GetData", recorder.URL+"/Pit/Qual")
+	fetchReportPage(t, recorder.URL, 1)
+	fetchReportPage(t, recorder.URL, 2)
+
+	for _, filename := range []string{
+		matchListFilename(2),
+		matchFilename(2, "2-1"),
+		matchFilename(2, "5-1"),
+		matchFilename(2, "20-1"),
+		"rankings.json",
+		reportFilename("ScheduleReportQualification", 1),
+		reportFilename("ScheduleReportQualification", 2),
+	} {
+		expected, _ := ioutil.ReadFile(path.Join(testFolder, filename))
+		actual, err := ioutil.ReadFile(path.Join(folder, filename))
+		if assert.NoError(t, err, filename) {
+			assert.Equal(t, string(expected), string(actual), filename)
+		}
+	}
+
+	// the recording can be replayed
+	url := startTestServer(t, folder)
+	status, _ := get(t, url+"/FieldMonitor/Matches/Score?matchId="+matches[2].Id, "")
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func listedNames(t *testing.T, url string) []string {
+	_, list_html := get(t, url+"/FieldMonitor/MatchesPartialByLevel?levelParam=2", "")
+	matches, err := ParseMatchList(list_html)
+	assert.NoError(t, err)
+	names := make([]string, len(matches))
+	for i, match := range matches {
+		names[i] = match.Name
+	}
+	return names
+}
+
+func TestTimeline(t *testing.T) {
+	fms, _ := NewServer(testFolder)
+	server := httptest.NewServer(fms)
+	defer server.Close()
+
+	_, err := fms.StartTimeline(TimelineOptions{Injections: []TimelineInjection{{Kind: INJECT_EDIT, Level: 2, Match: "2-1"}}})
+	assert.Error(t, err, "edit without file accepted")
+	_, err = fms.StartTimeline(TimelineOptions{Injections: []TimelineInjection{{Kind: INJECT_REPLAY, Level: 2, Match: "99-1"}}})
+	assert.Error(t, err, "replay of unknown match accepted")
+
+	timeline, err := fms.StartTimeline(TimelineOptions{
+		Injections: []TimelineInjection{
+			{Kind: INJECT_EDIT, Level: 2, Match: "2-1", After: 2, File: "level2/matches/20-1.html"},
+			{Kind: INJECT_REPLAY, Level: 2, Match: "5-1"},
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 5, timeline.Status().Steps)
+
+	assert.Equal(t, []string{}, listedNames(t, server.URL))
+	_, rankings := get(t, server.URL+"/Pit/GetData", server.URL+"/Pit/Qual")
+	assert.Equal(t, `{"qualRanks":[]}`, string(rankings))
+
+	assert.True(t, timeline.Advance())
+	assert.Equal(t, []string{"2-1"}, listedNames(t, server.URL))
+	match_2_id := "00002023-0000-4000-8000-000000000002"
+	original, _ := ioutil.ReadFile(path.Join(testFolder, "level2/matches/2-1.html"))
+	_, page := get(t, server.URL+"/FieldMonitor/Matches/Score?matchId="+match_2_id, "")
+	assert.Equal(t, string(original), string(page))
+
+	timeline.Advance()
+	timeline.Advance()
+	timeline.Advance()
+	assert.Equal(t, []string{"2-1", "5-1", "5-2"}, listedNames(t, server.URL))
+	edited, _ := ioutil.ReadFile(path.Join(testFolder, "level2/matches/20-1.html"))
+	_, page = get(t, server.URL+"/FieldMonitor/Matches/Score?matchId="+match_2_id, "")
+	assert.Equal(t, string(edited), string(page))
+	_, list_html := get(t, server.URL+"/FieldMonitor/MatchesPartialByLevel?levelParam=2", "")
+	matches, _ := ParseMatchList(list_html)
+	status, _ := get(t, server.URL+"/FieldMonitor/Matches/Score?matchId="+matches[2].Id, "")
+	assert.Equal(t, http.StatusOK, status)
+
+	assert.True(t, timeline.Advance())
+	assert.False(t, timeline.Advance())
+	assert.Equal(t, []string{"2-1", "5-1", "5-2", "20-1"}, listedNames(t, server.URL))
+	_, rankings = get(t, server.URL+"/Pit/GetData", server.URL+"/Pit/Qual")
+	assert.Contains(t, string(rankings), `"team": 7491`)
+}
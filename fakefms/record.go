@@ -0,0 +1,162 @@
+package fakefms
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type recordedMatch struct {
+	level int
+	name  string
+}
+
+// Proxies requests to a live FMS and saves responses to an event folder that
+// Server can replay. Point TBA-uploader at the recorder instead of the FMS
+// and use it as normal.
+type Recorder struct {
+	FmsUrl string
+	Folder string
+
+	client  http.Client
+	mutex   sync.Mutex
+	matches map[string]recordedMatch
+}
+
+func NewRecorder(fms_url string, folder string) *Recorder {
+	r := &Recorder{
+		FmsUrl:  strings.TrimSuffix(fms_url, "/"),
+		Folder:  folder,
+		client:  http.Client{Timeout: 10 * time.Second},
+		matches: make(map[string]recordedMatch),
+	}
+	// pick up match IDs from a previous recording
+	for level := 0; level <= MAX_LEVEL; level++ {
+		contents, err := ioutil.ReadFile(path.Join(folder, matchListFilename(level)))
+		if err == nil {
+			r.indexMatchList(level, contents)
+		}
+	}
+	return r
+}
+
+func (r *Recorder) indexMatchList(level int, list_html []byte) {
+	matches, err := ParseMatchList(list_html)
+	if err != nil {
+		log.Printf("failed to parse level %d match list: %s", level, err)
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, match := range matches {
+		r.matches[match.Id] = recordedMatch{level: level, name: match.Name}
+	}
+}
+
+// number of distinct qualification matches seen so far
+func (r *Recorder) qualMatchCount() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	numbers := make(map[int]bool)
+	for _, match := range r.matches {
+		if match.level == QUAL_LEVEL {
+			number, _ := parseMatchName(match.name)
+			numbers[number] = true
+		}
+	}
+	return len(numbers)
+}
+
+func (r *Recorder) save(filename string, contents []byte) {
+	full_path := path.Join(r.Folder, filename)
+	err := os.MkdirAll(path.Dir(full_path), os.ModePerm)
+	if err == nil {
+		err = ioutil.WriteFile(full_path, contents, os.ModePerm)
+	}
+	if err != nil {
+		log.Printf("failed to record %s: %s", filename, err)
+	} else {
+		log.Printf("recorded %s", filename)
+	}
+}
+
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := ioutil.ReadAll(req.Body)
+	proxy_req, err := http.NewRequest(req.Method, r.FmsUrl+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	proxy_req.Header = req.Header.Clone()
+	proxy_req.Header.Del("Accept-Encoding")
+	// rankings are only returned with a pit display referer, which points at the recorder
+	if referer := proxy_req.Header.Get("Referer"); strings.HasPrefix(referer, "http://"+req.Host+"/") {
+		proxy_req.Header.Set("Referer", r.FmsUrl+strings.TrimPrefix(referer, "http://"+req.Host))
+	}
+
+	res, err := r.client.Do(proxy_req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("FMS request failed: %s", err), http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+	res_body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("FMS request failed: %s", err), http.StatusBadGateway)
+		return
+	}
+	if content_type := res.Header.Get("Content-Type"); content_type != "" {
+		w.Header().Set("Content-Type", content_type)
+	}
+	w.WriteHeader(res.StatusCode)
+	w.Write(res_body)
+
+	if res.StatusCode == http.StatusOK {
+		r.record(req, body, res_body)
+	}
+}
+
+func (r *Recorder) record(req *http.Request, req_body []byte, res_body []byte) {
+	switch req.URL.Path {
+	case "/FieldMonitor/MatchesPartialByLevel":
+		level, err := strconv.Atoi(req.URL.Query().Get("levelParam"))
+		if err != nil {
+			return
+		}
+		r.save(matchListFilename(level), res_body)
+		r.indexMatchList(level, res_body)
+	case "/FieldMonitor/Matches/Score":
+		r.mutex.Lock()
+		match, ok := r.matches[req.URL.Query().Get("matchId")]
+		r.mutex.Unlock()
+		if !ok {
+			log.Printf("not recording unknown match: %s", req.URL.Query().Get("matchId"))
+			return
+		}
+		r.save(matchFilename(match.level, match.name), res_body)
+	case "/Pit/GetData":
+		if strings.HasSuffix(req.Header.Get("Referer"), "/Pit/Qual") {
+			r.save("rankings.json", res_body)
+			r.save(rankingSnapshotFilename(r.qualMatchCount()), res_body)
+		}
+	case "/Reports/PostReportAction":
+		body := make(map[string]interface{})
+		if json.Unmarshal(req_body, &body) != nil || body["reportAction"] != "GetPageModel" {
+			return
+		}
+		report_type, err := parseReportType(body)
+		page, _ := body["pageindex"].(float64)
+		if err == nil && page >= 1 {
+			r.save(reportFilename(report_type, int(page)), res_body)
+		}
+	}
+}
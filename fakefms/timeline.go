@@ -0,0 +1,409 @@
+package fakefms
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const (
+	INJECT_EDIT   = "edit"
+	INJECT_REPLAY = "replay"
+)
+
+// A change to a released match, to simulate events at the FMS
+type TimelineInjection struct {
+	// INJECT_EDIT serves File in place of the match page, as if its score
+	// was edited. INJECT_REPLAY adds a new play of the match, served from File
+	// (default: the original page).
+	Kind  string `json:"kind"`
+	Level int    `json:"level"`
+	// match name from the recorded match list, e.g. "12-1"
+	Match string `json:"match"`
+	// number of steps after the match is released (default 1)
+	After int `json:"after"`
+	// page to serve, relative to the event folder
+	File string `json:"file"`
+}
+
+type TimelineOptions struct {
+	// time between steps; 0 only advances when requested
+	Interval time.Duration `json:"-"`
+	// levels to release, in order (default: all recorded levels)
+	Levels     []int               `json:"levels"`
+	Injections []TimelineInjection `json:"injections"`
+}
+
+func LoadTimelineOptions(filename string) (TimelineOptions, error) {
+	var options TimelineOptions
+	contents, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return options, err
+	}
+	err = json.Unmarshal(contents, &options)
+	if err != nil {
+		return options, fmt.Errorf("%s: %s", filename, err)
+	}
+	return options, nil
+}
+
+type timelineStep struct {
+	level int
+	// released match, if injection is nil
+	match     Match
+	injection *TimelineInjection
+}
+
+type timelineReplay struct {
+	original string
+	match    Match
+}
+
+// Releases recorded matches one at a time, so that pollers see an event
+// progress as it would live.
+type Timeline struct {
+	server   *Server
+	mutex    sync.Mutex
+	steps    []timelineStep
+	position int
+	// level -> recorded match IDs released so far
+	released map[int]map[string]bool
+	// match ID -> page to serve instead of the recorded one
+	files   map[string]string
+	replays map[int][]timelineReplay
+	stop    chan bool
+}
+
+type TimelineStatus struct {
+	Position int `json:"position"`
+	Steps    int `json:"steps"`
+	// level -> match names currently listed
+	Matches map[int][]string `json:"matches"`
+}
+
+func parseMatchName(name string) (match int, play int) {
+	parts := strings.Split(name, "-")
+	match, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		play, _ = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+// replace the server's static replay with a timeline. The timeline starts with
+// no matches released.
+func (s *Server) StartTimeline(options TimelineOptions) (*Timeline, error) {
+	t := &Timeline{
+		server:   s,
+		steps:    make([]timelineStep, 0),
+		released: make(map[int]map[string]bool),
+		files:    make(map[string]string),
+		replays:  make(map[int][]timelineReplay),
+		stop:     make(chan bool),
+	}
+
+	levels := options.Levels
+	if len(levels) == 0 {
+		for level := 0; level <= MAX_LEVEL; level++ {
+			if s.levelMatches(level) != nil {
+				levels = append(levels, level)
+			}
+		}
+	}
+
+	// position of each release in steps, keyed by level and match name
+	release_positions := make(map[string]int)
+	releases := make([]timelineStep, 0)
+	for _, level := range levels {
+		t.released[level] = make(map[string]bool)
+		for _, match := range s.levelMatches(level) {
+			release_positions[fmt.Sprintf("%d/%s", level, match.Name)] = len(releases)
+			releases = append(releases, timelineStep{level: level, match: match})
+		}
+	}
+
+	// injections due after each release
+	injections := make(map[int][]timelineStep)
+	for i := range options.Injections {
+		injection := options.Injections[i]
+		if injection.Kind != INJECT_EDIT && injection.Kind != INJECT_REPLAY {
+			return nil, fmt.Errorf("invalid injection kind: %q", injection.Kind)
+		}
+		position, ok := release_positions[fmt.Sprintf("%d/%s", injection.Level, injection.Match)]
+		if !ok {
+			return nil, fmt.Errorf("%s: match not in timeline: level %d match %s", injection.Kind, injection.Level, injection.Match)
+		}
+		if injection.Kind == INJECT_EDIT && injection.File == "" {
+			return nil, fmt.Errorf("edit of %s: no file given", injection.Match)
+		}
+		if injection.File != "" {
+			if _, err := os.Stat(path.Join(s.Folder, injection.File)); err != nil {
+				return nil, fmt.Errorf("%s of %s: %s", injection.Kind, injection.Match, err)
+			}
+		}
+		if injection.After < 1 {
+			injection.After = 1
+		}
+		due := position + injection.After - 1
+		if due >= len(releases) {
+			due = len(releases) - 1
+		}
+		injections[due] = append(injections[due], timelineStep{level: injection.Level, injection: &injection})
+	}
+	for i, release := range releases {
+		t.steps = append(t.steps, release)
+		t.steps = append(t.steps, injections[i]...)
+	}
+
+	s.mutex.Lock()
+	if s.timeline != nil {
+		s.timeline.Stop()
+	}
+	s.timeline = t
+	s.mutex.Unlock()
+
+	if options.Interval > 0 {
+		go t.run(options.Interval)
+	}
+	return t, nil
+}
+
+func (t *Timeline) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if !t.Advance() {
+				return
+			}
+		}
+	}
+}
+
+func (t *Timeline) Stop() {
+	select {
+	case <-t.stop:
+	default:
+		close(t.stop)
+	}
+}
+
+// apply the next step; returns false if the timeline has finished
+func (t *Timeline) Advance() bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.position >= len(t.steps) {
+		return false
+	}
+	step := t.steps[t.position]
+	t.position++
+
+	if step.injection == nil {
+		t.released[step.level][step.match.Id] = true
+		return true
+	}
+
+	original := t.findMatch(step.level, step.injection.Match)
+	switch step.injection.Kind {
+	case INJECT_EDIT:
+		t.files[original.Id] = step.injection.File
+	case INJECT_REPLAY:
+		match_number, max_play := parseMatchName(original.Name)
+		for _, match := range t.listedMatches(step.level) {
+			if number, play := parseMatchName(match.Name); number == match_number && play > max_play {
+				max_play = play
+			}
+		}
+		replay := Match{
+			Id:   fmt.Sprintf("%s-replay%d", original.Id, max_play+1),
+			Name: fmt.Sprintf("%d-%d", match_number, max_play+1),
+		}
+		t.replays[step.level] = append(t.replays[step.level], timelineReplay{original: original.Id, match: replay})
+		file := step.injection.File
+		if file == "" {
+			file = t.matchFile(step.level, original)
+		}
+		t.files[replay.Id] = file
+	}
+	return true
+}
+
+func (t *Timeline) Status() TimelineStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	status := TimelineStatus{
+		Position: t.position,
+		Steps:    len(t.steps),
+		Matches:  make(map[int][]string),
+	}
+	for level := range t.released {
+		status.Matches[level] = make([]string, 0)
+		for _, match := range t.listedMatches(level) {
+			status.Matches[level] = append(status.Matches[level], match.Name)
+		}
+	}
+	return status
+}
+
+// must be called with the mutex held
+func (t *Timeline) findMatch(level int, name string) Match {
+	for _, match := range t.server.levelMatches(level) {
+		if match.Name == name {
+			return match
+		}
+	}
+	return Match{}
+}
+
+// must be called with the mutex held
+func (t *Timeline) matchFile(level int, match Match) string {
+	if file, ok := t.files[match.Id]; ok {
+		return file
+	}
+	return matchFilename(level, match.Name)
+}
+
+// released matches and replays, in match list order. Must be called with the mutex held.
+func (t *Timeline) listedMatches(level int) []Match {
+	matches := make([]Match, 0)
+	for _, match := range t.server.levelMatches(level) {
+		if !t.released[level][match.Id] {
+			continue
+		}
+		matches = append(matches, match)
+		for _, replay := range t.replays[level] {
+			if replay.original == match.Id {
+				matches = append(matches, replay.match)
+			}
+		}
+	}
+	return matches
+}
+
+func (t *Timeline) servedMatches(level int) []servedMatch {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	out := make([]servedMatch, 0)
+	for _, match := range t.listedMatches(level) {
+		out = append(out, servedMatch{Match: match, File: t.matchFile(level, match)})
+	}
+	return out
+}
+
+// the recorded match list with unreleased matches removed and replays added
+func (t *Timeline) matchList(level int) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path.Join(t.server.Folder, matchListFilename(level)))
+	if err != nil {
+		return nil, err
+	}
+	dom, err := goquery.NewDocumentFromReader(strings.NewReader(string(contents)))
+	if err != nil {
+		return nil, err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	dom.Find("tbody tr").Each(func(i int, row *goquery.Selection) {
+		href, _ := row.Find("a").First().Attr("href")
+		parts := strings.SplitN(href, "matchId=", 2)
+		if len(parts) != 2 {
+			return
+		}
+		if !t.released[level][parts[1]] {
+			row.Remove()
+			return
+		}
+		after := row
+		for _, replay := range t.replays[level] {
+			if replay.original != parts[1] {
+				continue
+			}
+			replay_row := row.Clone()
+			replay_row.Find("a").First().SetAttr("href", parts[0]+"matchId="+replay.match.Id)
+			button := replay_row.Find("button").First()
+			label := strings.Replace(replay.match.Name, "-", " / ", 1)
+			if bold := button.Find("b"); bold.Length() > 0 {
+				bold.SetText(label)
+			} else {
+				button.SetText(label)
+			}
+			after.AfterSelection(replay_row)
+			after = replay_row
+		}
+	})
+	out, err := dom.Html()
+	return []byte(out), err
+}
+
+// rankings as of the released qualification matches: the latest
+// rankings/{N}.json snapshot recorded after N matches, or rankings.json once
+// all qualification matches are released. Returns "" if no rankings are available yet.
+func (t *Timeline) rankingsFile() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	recorded := t.server.levelMatches(QUAL_LEVEL)
+	released_numbers := make(map[int]bool)
+	all_numbers := make(map[int]bool)
+	for _, match := range recorded {
+		number, _ := parseMatchName(match.Name)
+		all_numbers[number] = true
+		if t.released[QUAL_LEVEL][match.Id] {
+			released_numbers[number] = true
+		}
+	}
+
+	best := -1
+	files, _ := ioutil.ReadDir(path.Join(t.server.Folder, "rankings"))
+	for _, file := range files {
+		n, err := strconv.Atoi(strings.TrimSuffix(file.Name(), ".json"))
+		if err == nil && n <= len(released_numbers) && n > best {
+			best = n
+		}
+	}
+	if best >= 0 {
+		return rankingSnapshotFilename(best)
+	} else if len(released_numbers) > 0 && len(released_numbers) == len(all_numbers) {
+		return "rankings.json"
+	}
+	return ""
+}
+
+func (s *Server) serveTimeline(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	timeline := s.timeline
+	s.mutex.Unlock()
+	if timeline == nil {
+		http.Error(w, "no timeline running", http.StatusNotFound)
+		return
+	}
+	out, _ := json.Marshal(timeline.Status())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+func (s *Server) serveTimelineAdvance(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	timeline := s.timeline
+	s.mutex.Unlock()
+	if timeline == nil {
+		http.Error(w, "no timeline running", http.StatusNotFound)
+		return
+	} else if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed: "+r.Method, http.StatusMethodNotAllowed)
+		return
+	}
+	timeline.Advance()
+	s.serveTimeline(w, r)
+}
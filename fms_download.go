@@ -17,12 +17,18 @@ import (
 	"github.com/PuerkitoBio/goquery"
 )
 
-var FMSConfig struct {
+type fmsConfig struct {
 	FmsUrl     string `json:"fms_url"`
 	DataFolder string `json:"data_folder"`
 	TbaUrl     string `json:"tba_url"`
+	// from -tba-read-key only; never sent to browsers or saved to config.json
+	TbaReadKey string `json:"-"`
+	// validate and show TBA requests instead of sending them
+	DryRun bool `json:"dry_run"`
 }
 
+var FMSConfig fmsConfig
+
 func checkFMSConnection() {
 	// make sure the FMS server is running
 	logger.Printf("Looking for FMS at %s...\n", FMSConfig.FmsUrl)
@@ -3,27 +3,32 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/lethosor/TBA-uploader/fakefms"
 	"github.com/lethosor/TBA-uploader/fms_parser"
 )
 
 func TestMain(m *testing.M) {
-	FMSConfig.FmsUrl = "http://localhost:5555"
-	FMSConfig.DataFolder = "fms_data_test"
-	os.Exit(m.Run())
-}
-
-func skipCI(t *testing.T) {
-	if os.Getenv("CI") != "" {
-		t.Skip("Test server not implemented in CI")
+	logger = log.New(os.Stdout, "", log.Flags())
+	fms, err := fakefms.NewServer("tests/data/fakefms/2023milak")
+	if err != nil {
+		logger.Fatal(err)
 	}
+	fms_server := httptest.NewServer(fms)
+	FMSConfig.FmsUrl = fms_server.URL
+	FMSConfig.DataFolder, _ = ioutil.TempDir("", "fms_data_test")
+	code := m.Run()
+	fms_server.Close()
+	os.RemoveAll(FMSConfig.DataFolder)
+	os.Exit(code)
 }
 
 func TestDownloadMatches(t *testing.T) {
-	skipCI(t)
-
 	fmt.Println(getMatchDownloadPath(MATCH_LEVEL_QUAL, "all"))
 	files, err := downloadNewMatches(MATCH_LEVEL_QUAL, "all")
 	if err != nil {
@@ -47,7 +52,7 @@ func TestDownloadMatches(t *testing.T) {
 		t.Fatal("no matches downloaded")
 	}
 
-	match_json, err := fms_parser.ParseHTMLtoJSON(2019, files[0], fms_parser.FMSParseConfig{Playoff: false})
+	match_json, err := fms_parser.ParseHTMLtoJSON(2023, files[0], fms_parser.FMSParseConfig{Playoff: false})
 	if err != nil {
 		t.Error("ParseHTMLtoJSON: ", err)
 	}
@@ -56,8 +61,6 @@ func TestDownloadMatches(t *testing.T) {
 }
 
 func TestDownloadReports(t *testing.T) {
-	skipCI(t)
-
 	pages, err := downloadReport("ScheduleReportQualification")
 	if err != nil {
 		t.Error("downloadReport failed:", err)
@@ -67,3 +70,18 @@ func TestDownloadReports(t *testing.T) {
 		t.Error(fmt.Sprintf("wrong page count: got %d, expected %d", len(pages), 2))
 	}
 }
+
+func TestDownloadRankings(t *testing.T) {
+	rankings, err := downloadRankings(MATCH_LEVEL_QUAL, "all")
+	if err != nil {
+		t.Fatal("downloadRankings failed:", err)
+	}
+
+	converted, err := convertFMSRankings(2023, rankings)
+	if err != nil {
+		t.Fatal("convertFMSRankings failed:", err)
+	}
+	if len(converted.Rankings) != 18 {
+		t.Error(fmt.Sprintf("wrong ranking count: got %d, expected %d", len(converted.Rankings), 18))
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const keyStoreFilename = "keys.enc"
+
+// scrypt parameters recommended for interactive logins
+const (
+	keyStoreScryptN = 32768
+	keyStoreScryptR = 8
+	keyStoreScryptP = 1
+)
+
+var errKeyStoreLocked = errors.New("key store is locked; restart with a passphrase to use stored keys")
+
+type eventKey struct {
+	Auth   string `json:"auth"`
+	Secret string `json:"secret"`
+}
+
+type keyStoreContents struct {
+	Events     map[string]eventKey `json:"events"`
+	ReadApiKey string              `json:"read_api_key,omitempty"`
+}
+
+// encrypted file format: scrypt parameters and salt, and AES-GCM nonce and ciphertext of keyStoreContents
+type keyStoreFile struct {
+	Version int    `json:"version"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// TBA event keys, stored encrypted with a passphrase in the data folder
+type keyStore struct {
+	path     string
+	mutex    sync.Mutex
+	file     keyStoreFile
+	aead     cipher.AEAD
+	contents keyStoreContents
+}
+
+// nil if no passphrase was given at startup
+var key_store *keyStore
+
+func getKeyStorePath() string {
+	return filepath.Join(FMSConfig.DataFolder, keyStoreFilename)
+}
+
+func newKeyStoreCipher(passphrase string, file keyStoreFile) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), file.Salt, file.N, file.R, file.P, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// open the key store at filename, creating an empty one if it does not exist
+func openKeyStore(filename string, passphrase string) (*keyStore, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	ks := &keyStore{
+		path:     filename,
+		contents: keyStoreContents{Events: make(map[string]eventKey)},
+	}
+	raw, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		ks.file = keyStoreFile{Version: 1, N: keyStoreScryptN, R: keyStoreScryptR, P: keyStoreScryptP, Salt: make([]byte, 16)}
+		if _, err := rand.Read(ks.file.Salt); err != nil {
+			return nil, err
+		}
+		ks.aead, err = newKeyStoreCipher(passphrase, ks.file)
+		if err != nil {
+			return nil, err
+		}
+		return ks, ks.save()
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(raw, &ks.file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Base(filename), err)
+	} else if ks.file.Version != 1 {
+		return nil, fmt.Errorf("%s: unsupported version: %d", filepath.Base(filename), ks.file.Version)
+	}
+	ks.aead, err = newKeyStoreCipher(passphrase, ks.file)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := ks.aead.Open(nil, ks.file.Nonce, ks.file.Data, nil)
+	if err != nil {
+		return nil, errors.New("wrong passphrase or corrupted key store")
+	}
+	err = json.Unmarshal(plaintext, &ks.contents)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Base(filename), err)
+	}
+	if ks.contents.Events == nil {
+		ks.contents.Events = make(map[string]eventKey)
+	}
+	return ks, nil
+}
+
+// must be called with the mutex held (or before the store is shared)
+func (ks *keyStore) save() error {
+	plaintext, err := json.Marshal(ks.contents)
+	if err != nil {
+		return err
+	}
+	file := ks.file
+	file.Nonce = make([]byte, ks.aead.NonceSize())
+	if _, err := rand.Read(file.Nonce); err != nil {
+		return err
+	}
+	file.Data = ks.aead.Seal(nil, file.Nonce, plaintext, nil)
+	out, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(ks.path), os.ModePerm)
+	err = writeFileAtomic(ks.path, out)
+	if err == nil {
+		os.Chmod(ks.path, 0600)
+		ks.file = file
+	}
+	return err
+}
+
+func (ks *keyStore) Get(event string) (eventKey, bool) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	key, ok := ks.contents.Events[event]
+	return key, ok
+}
+
+func (ks *keyStore) Set(event string, key eventKey) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.contents.Events[event] = key
+	return ks.save()
+}
+
+func (ks *keyStore) Delete(event string) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	delete(ks.contents.Events, event)
+	return ks.save()
+}
+
+func (ks *keyStore) ReadApiKey() string {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	return ks.contents.ReadApiKey
+}
+
+func (ks *keyStore) SetReadApiKey(key string) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	ks.contents.ReadApiKey = key
+	return ks.save()
+}
+
+// event -> auth ID, without secrets
+func (ks *keyStore) List() map[string]string {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	out := make(map[string]string)
+	for event, key := range ks.contents.Events {
+		out[event] = key.Auth
+	}
+	return out
+}
+
+// reads a password from the terminal without echoing it; replaced in tests
+var promptPassword = func(prompt string) (string, error) {
+	fmt.Print(prompt)
+	password, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	return string(password), err
+}
+
+// passphrase from a file, or prompted for if running in a terminal. Returns
+// "" if no passphrase is available. If the key store is about to be created,
+// the prompted passphrase is asked for twice, so that a typo does not lock
+// away the keys stored with it.
+func readKeyStorePassphrase(passphrase_file string, create bool) (string, error) {
+	if passphrase_file != "" {
+		contents, err := ioutil.ReadFile(passphrase_file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", nil
+	}
+	return promptKeyStorePassphrase(create)
+}
+
+func promptKeyStorePassphrase(create bool) (string, error) {
+	if !create {
+		return promptPassword("Key store passphrase (leave empty to skip): ")
+	}
+	passphrase, err := promptPassword("New key store passphrase (leave empty to skip): ")
+	if err != nil || passphrase == "" {
+		return passphrase, err
+	}
+	confirmation, err := promptPassword("Repeat the new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirmation != passphrase {
+		return "", errors.New("passphrases do not match")
+	}
+	return passphrase, nil
+}
+
+func initKeyStore(passphrase_file string) {
+	passphrase, err := readKeyStorePassphrase(passphrase_file, !fileExists(getKeyStorePath()))
+	if err != nil {
+		logger.Fatalf("Could not read key store passphrase: %s\n", err)
+	}
+	if passphrase == "" {
+		logger.Printf("Key store locked: event keys will not be stored on disk\n")
+		return
+	}
+	key_store, err = openKeyStore(getKeyStorePath(), passphrase)
+	if err != nil {
+		logger.Fatalf("Could not open key store: %s\n", err)
+	}
+	logger.Printf("Key store unlocked: %d events\n", len(key_store.List()))
+	if err := migrateLegacyReadKey(); err != nil {
+		logger.Printf("Could not move the TBA read API key to the key store: %s\n", err)
+	}
+}
+
+func checkKeyStore() *keyStore {
+	if key_store == nil {
+		apiPanicErrorCode(http.StatusBadRequest, API_ERROR_KEY_STORE_LOCKED, "%s", errKeyStoreLocked)
+	}
+	return key_store
+}
+
+type keyStoreEventInfo struct {
+	Auth string `json:"auth"`
+}
+
+type keyStoreInfo struct {
+	Unlocked   bool                         `json:"unlocked"`
+	Events     map[string]keyStoreEventInfo `json:"events"`
+	ReadApiKey bool                         `json:"read_api_key"`
+}
+
+// stored events and auth IDs. Secrets are never returned.
+func apiKeyStoreList(w http.ResponseWriter, r *http.Request) {
+	info := keyStoreInfo{Events: make(map[string]keyStoreEventInfo)}
+	if key_store != nil {
+		info.Unlocked = true
+		info.ReadApiKey = key_store.ReadApiKey() != ""
+		for event, auth := range key_store.List() {
+			info.Events[event] = keyStoreEventInfo{Auth: auth}
+		}
+	}
+	sendJson(w, info)
+}
+
+func apiKeyStoreSet(w http.ResponseWriter, r *http.Request) {
+	ks := checkKeyStore()
+	event := checkRequestQueryParam(r, "event")
+	if !eventKeyPattern.MatchString(event) {
+		apiPanicBadRequest("invalid event key: %q", event)
+	}
+	var key eventKey
+	body, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(body, &key); err != nil {
+		apiPanicBadRequest("invalid key: %s", err)
+	} else if key.Auth == "" || key.Secret == "" {
+		apiPanicBadRequest("auth and secret are required")
+	}
+	if err := ks.Set(event, key); err != nil {
+		apiPanicInternal("failed to save key store: %s", err)
+	}
+	sendJson(w, nil)
+}
+
+func apiKeyStoreDelete(w http.ResponseWriter, r *http.Request) {
+	ks := checkKeyStore()
+	if err := ks.Delete(checkRequestQueryParam(r, "event")); err != nil {
+		apiPanicInternal("failed to save key store: %s", err)
+	}
+	sendJson(w, nil)
+}
+
+// store keys synced from the web UI (see apiKeysUpdate), if the key store is unlocked.
+// Keys without a secret are skipped, since they were fetched from the store.
+func importKeysJson(keys map[string]json.RawMessage) error {
+	if key_store == nil {
+		return nil
+	}
+	events := make([]string, 0)
+	for event := range keys {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		if event == "_read_api_key" {
+			var read_key string
+			if json.Unmarshal(keys[event], &read_key) == nil && read_key != "" && read_key != key_store.ReadApiKey() {
+				if err := key_store.SetReadApiKey(read_key); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		var key eventKey
+		if !eventKeyPattern.MatchString(event) || json.Unmarshal(keys[event], &key) != nil || key.Auth == "" || key.Secret == "" {
+			continue
+		}
+		if existing, ok := key_store.Get(event); !ok || existing != key {
+			if err := key_store.Set(event, key); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestKeyStore(t *testing.T) {
+	filename := path.Join(t.TempDir(), keyStoreFilename)
+	ks, err := openKeyStore(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Set("2023test", eventKey{Auth: "auth-id", Secret: "event-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := ioutil.ReadFile(filename)
+	if strings.Contains(string(raw), "event-secret") || strings.Contains(string(raw), "auth-id") {
+		t.Error("key store contents are not encrypted")
+	}
+
+	if _, err := openKeyStore(filename, "wrong"); err == nil {
+		t.Error("opened key store with the wrong passphrase")
+	}
+	ks, err = openKeyStore(filename, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, ok := ks.Get("2023test"); !ok || key.Secret != "event-secret" {
+		t.Error("stored key not found:", key)
+	}
+	if list := ks.List(); len(list) != 1 || list["2023test"] != "auth-id" {
+		t.Error("unexpected event list:", list)
+	}
+}
+
+func TestRequestEventParamsFromKeyStore(t *testing.T) {
+	ks, err := openKeyStore(path.Join(t.TempDir(), keyStoreFilename), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.Set("2023test", eventKey{Auth: "stored-auth", Secret: "stored-secret"})
+	key_store = ks
+	defer func() { key_store = nil }()
+
+	r := httptest.NewRequest("POST", "/api/matches/upload", nil)
+	r.Header.Set("X-Event", "2023test")
+	params, ok := getRequestEventParams(r)
+	if !ok || params.Auth != "stored-auth" || params.Secret != "stored-secret" {
+		t.Error("credentials not resolved from key store:", params)
+	}
+
+	r.Header.Set("X-Event", "2023other")
+	if _, ok := getRequestEventParams(r); ok {
+		t.Error("credentials resolved for unknown event")
+	}
+	r.Header.Set("X-Auth", "auth")
+	r.Header.Set("X-Secret", "secret")
+	if params, ok := getRequestEventParams(r); !ok || params.Secret != "secret" {
+		t.Error("header credentials not used:", params)
+	}
+}
+
+func TestSyncKeys(t *testing.T) {
+	ks, err := openKeyStore(path.Join(t.TempDir(), keyStoreFilename), "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_store = ks
+	defer func() { key_store = nil }()
+
+	w := httptest.NewRecorder()
+	apiKeysUpdate(w, httptest.NewRequest("POST", "/api/keys/update", strings.NewReader(`{"2023test": {"auth": "auth-id", "secret": "event-secret"}}`)))
+	if key, ok := ks.Get("2023test"); !ok || key.Secret != "event-secret" {
+		t.Error("synced key not imported:", key)
+	}
+
+	w = httptest.NewRecorder()
+	apiKeysFetch(w, httptest.NewRequest("POST", "/api/keys/fetch", nil))
+	if body := w.Body.String(); !strings.Contains(body, "auth-id") || strings.Contains(body, "event-secret") {
+		t.Error("unexpected synced keys:", body)
+	}
+}
+
+func TestPromptKeyStorePassphrase(t *testing.T) {
+	old_prompt := promptPassword
+	defer func() { promptPassword = old_prompt }()
+	answers := []string{}
+	prompts := 0
+	promptPassword = func(prompt string) (string, error) {
+		answer := answers[prompts]
+		prompts++
+		return answer, nil
+	}
+
+	answers, prompts = []string{"passphrase"}, 0
+	if passphrase, err := promptKeyStorePassphrase(false); err != nil || passphrase != "passphrase" || prompts != 1 {
+		t.Error("existing key store:", passphrase, err, prompts)
+	}
+	answers, prompts = []string{"passphrase", "passphrase"}, 0
+	if passphrase, err := promptKeyStorePassphrase(true); err != nil || passphrase != "passphrase" || prompts != 2 {
+		t.Error("new key store:", passphrase, err, prompts)
+	}
+	answers, prompts = []string{"passphrase", "typo"}, 0
+	if _, err := promptKeyStorePassphrase(true); err == nil {
+		t.Error("mismatched passphrases accepted")
+	}
+	answers, prompts = []string{""}, 0
+	if passphrase, err := promptKeyStorePassphrase(true); err != nil || passphrase != "" || prompts != 1 {
+		t.Error("skipping the new key store:", passphrase, err, prompts)
+	}
+}
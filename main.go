@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
@@ -11,26 +12,39 @@ import (
 var Version = "dev"
 var logger *log.Logger
 
-func main() {
-	exe, err := os.Executable()
-	if err != nil {
-		log.Fatalf("Could not find executable path: %s\n", err)
-	}
+// flags shared by the web server and the command-line subcommands
+type commonFlags struct {
+	fms_url             *string
+	tba_url             *string
+	tba_read_key        *string
+	data_folder         *string
+	key_passphrase_file *string
+	dry_run             *bool
+}
 
-	port := flag.Int("port", 8808, "web server port")
-	fms_url := flag.String("fms-url", "http://10.0.100.5", "FMS URL (including protocol)")
-	no_fms := flag.Bool("no-fms", false, "disable FMS connectivity")
-	tba_url := flag.String("tba-url", "https://www.thebluealliance.com", "TBA URL (including protocol)")
-	data_folder := flag.String("data-folder", filepath.Join(filepath.Dir(exe), "fms_data"), "FMS data destination folder")
-	web_folder := flag.String("web-folder", "", "folder to serve files from (defaults to bundled files)")
-	flag.Parse()
+func addCommonFlags(fs *flag.FlagSet, exe string) commonFlags {
+	return commonFlags{
+		fms_url:             fs.String("fms-url", "http://10.0.100.5", "FMS URL (including protocol)"),
+		tba_url:             fs.String("tba-url", "https://www.thebluealliance.com", "TBA URL (including protocol)"),
+		tba_read_key:        fs.String("tba-read-key", "", "TBA read API key (defaults to the key in the key store)"),
+		data_folder:         fs.String("data-folder", filepath.Join(filepath.Dir(exe), "fms_data"), "FMS data destination folder"),
+		key_passphrase_file: fs.String("key-passphrase-file", "", "file containing the key store passphrase (prompted for if not given)"),
+		dry_run:             fs.Bool("dry-run", false, "validate and show requests to TBA instead of sending them"),
+	}
+}
 
-	FMSConfig.FmsUrl = *fms_url
-	FMSConfig.TbaUrl = *tba_url
+// set up logging to log_output and the log file, and load config.json and the
+// key store. Flags given on the command line override the config file.
+func initCommon(fs *flag.FlagSet, flags commonFlags, log_output io.Writer) {
+	FMSConfig.FmsUrl = *flags.fms_url
+	FMSConfig.TbaUrl = *flags.tba_url
+	FMSConfig.TbaReadKey = *flags.tba_read_key
+	FMSConfig.DryRun = *flags.dry_run
 
-	FMSConfig.DataFolder, err = filepath.Abs(*data_folder)
+	var err error
+	FMSConfig.DataFolder, err = filepath.Abs(*flags.data_folder)
 	if err != nil {
-		log.Printf("WARNING: path normalization of \"%s\" failed: %s\n", *data_folder, err)
+		log.Printf("WARNING: path normalization of \"%s\" failed: %s\n", *flags.data_folder, err)
 	}
 
 	log_path := filepath.Join(FMSConfig.DataFolder, "tba-uploader.log")
@@ -39,13 +53,55 @@ func main() {
 		log.Printf("WARNING: cannot open log file \"%s\": %s\n", log_path, err)
 	}
 	log_file.Write([]byte("\n"))
-	log_writer := io.MultiWriter(os.Stdout, log_file)
+	log_writer := io.MultiWriter(log_output, log_file)
 	logger = log.New(log_writer, "", log.Flags())
 
 	logger.Printf("Version: %s\n", Version)
 	logger.Printf("FMS data folder: %s\n", FMSConfig.DataFolder)
 	logger.Printf("Logging to %s\n", log_path)
 
+	flag_fields := map[string]string{"fms-url": "fms_url", "tba-url": "tba_url", "dry-run": "dry_run"}
+	set_fields := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set_fields[flag_fields[f.Name]] = true
+	})
+	err = loadServerConfig(set_fields)
+	if err != nil {
+		logger.Fatalf("Could not load config: %s\n", err)
+	}
+	initKeyStore(*flags.key_passphrase_file)
+}
+
+func main() {
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("Could not find executable path: %s\n", err)
+	}
+
+	if len(os.Args) > 1 {
+		if command, ok := cliCommands[os.Args[1]]; ok {
+			os.Exit(runCliCommand(os.Args[1], command, os.Args[2:], exe))
+		}
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [options]\n       %s <command> [options]\n\nCommands:\n",
+			filepath.Base(os.Args[0]), filepath.Base(os.Args[0]))
+		printCliCommands(flag.CommandLine.Output())
+		fmt.Fprintf(flag.CommandLine.Output(), "\nOptions:\n")
+		flag.PrintDefaults()
+	}
+	port := flag.Int("port", 8808, "web server port")
+	no_fms := flag.Bool("no-fms", false, "disable FMS connectivity")
+	common_flags := addCommonFlags(flag.CommandLine, exe)
+	web_folder := flag.String("web-folder", "", "folder to serve files from (defaults to bundled files)")
+	tls_port := flag.Int("tls-port", 0, "HTTPS port; if set, HTTP requests on -port are redirected to it")
+	tls_cert := flag.String("tls-cert", "", "TLS certificate file (defaults to a self-signed certificate in the data folder)")
+	tls_key := flag.String("tls-key", "", "TLS private key file")
+	flag.Parse()
+
+	initCommon(flag.CommandLine, common_flags, os.Stdout)
+
 	web_folder_abs := ""
 	if *web_folder != "" {
 		web_folder_abs, err = filepath.Abs(*web_folder)
@@ -61,8 +117,13 @@ func main() {
 	cwd, _ := os.Getwd()
 	logger.Printf("Running in %s\n", cwd)
 
+	initUploadQueue()
+	if err := loadAuditMatchIndex(); err != nil {
+		logger.Printf("audit log: %s\n", err)
+	}
+
 	if !*no_fms {
 		go checkFMSConnection()
 	}
-	RunWebServer(*port, web_folder_abs)
+	RunWebServer(*port, web_folder_abs, tlsOptions{Port: *tls_port, CertFile: *tls_cert, KeyFile: *tls_key})
 }
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/fms_parser"
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// FMS match IDs are "<match number>-<play number>", e.g. "12-1", and are used
+// in file names
+var fmsMatchIdPattern = regexp.MustCompile(`^\d+-\d+$`)
+
+func validateFmsMatchIds(fms_ids []string) error {
+	for _, fms_id := range fms_ids {
+		if !fmsMatchIdPattern.MatchString(fms_id) {
+			return fmt.Errorf("invalid FMS match ID: %q", fms_id)
+		}
+	}
+	return nil
+}
+
+type matchParseOptions struct {
+	Level           int
+	Event           string
+	PlayoffType     int
+	EnabledExtraRps []bool
+}
+
+// convert downloaded FMS match HTML files to TBA-compatible JSON files in the same folder
+func convertDownloadedMatches(files []string, options matchParseOptions) error {
+	event_year := parseEventYear(options.Event)
+	for _, file := range files {
+		logger.Printf("Downloaded %s\n", file)
+		fname := filepath.Base(file)
+		match_number, err := strconv.Atoi(strings.Split(fname, "-")[0])
+		if err != nil {
+			return fmt.Errorf("%s: failed to parse match ID", fname)
+		}
+		folder := filepath.Dir(file)
+
+		match_extra_path := path.Join(folder, replaceExtension(fname, "extrajson"))
+		var extra_info fms_parser.ExtraMatchInfo
+		if isFile(match_extra_path) {
+			raw, _ := ioutil.ReadFile(match_extra_path)
+			err := json.Unmarshal(raw, &extra_info)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %v", match_extra_path, err)
+			}
+		}
+
+		is_playoff := (options.Level == MATCH_LEVEL_PLAYOFF)
+		if extra_info.MatchCodeOverride != nil {
+			is_playoff = (extra_info.MatchCodeOverride.Level != "qm")
+		}
+
+		match_info, err := fms_parser.ParseHTMLtoJSON(event_year, file, fms_parser.FMSParseConfig{
+			Playoff:         is_playoff,
+			EnabledExtraRps: options.EnabledExtraRps,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %s", fname, err)
+		}
+
+		if options.Level == MATCH_LEVEL_MANUAL {
+			defaults := fms_parser.GetDefaultBreakdowns(event_year)
+			if defaults != nil {
+				for _, alliance := range []string{"red", "blue"} {
+					alliance_breakdown := match_info["score_breakdown"].(map[string]map[string]any)[alliance]
+					for key, default_value := range defaults {
+						if _, ok := alliance_breakdown[key]; !ok {
+							alliance_breakdown[key] = default_value
+						}
+					}
+				}
+			}
+		}
+
+		if extra_info.MatchCodeOverride != nil {
+			match_info["comp_level"] = extra_info.MatchCodeOverride.Level
+			match_info["set_number"] = extra_info.MatchCodeOverride.Set
+			match_info["match_number"] = extra_info.MatchCodeOverride.Match
+		} else if options.Level == MATCH_LEVEL_PLAYOFF {
+			// playoffs
+			bracket, err := getEventBracket(options.Event, options.PlayoffType)
+			if err != nil {
+				return fmt.Errorf("%s: %s", fname, err)
+			}
+			code, err := bracket.PlayoffCode(match_number)
+			if err != nil {
+				return fmt.Errorf("%s: %s", fname, err)
+			}
+			match_info["comp_level"] = code.Level
+			match_info["set_number"] = code.Set
+			match_info["match_number"] = code.Match
+		} else {
+			match_info["comp_level"] = "qm"
+			match_info["set_number"] = 1
+			match_info["match_number"] = match_number
+		}
+
+		match_json, err := jsonMarshalOptionalIndent(match_info, options.Level == MATCH_LEVEL_MANUAL, "  ")
+		if err != nil {
+			return fmt.Errorf("%s: JSON serialization failed %s", fname, err)
+		}
+
+		fname_json := replaceExtension(fname, "json")
+		ioutil.WriteFile(path.Join(folder, fname_json), match_json, os.ModePerm)
+
+		// remove any receipts for newly-downloaded files
+		fname_receipt := replaceExtension(fname, "receipt")
+		os.Remove(path.Join(folder, fname_receipt))
+	}
+	return nil
+}
+
+// load all matches in match_folder that have not been uploaded to TBA yet
+func loadPendingMatches(match_folder string) ([]map[string]interface{}, error) {
+	match_json_list := make([]map[string]interface{}, 0)
+	json_files, err := listFilesWithExtension(match_folder, "json")
+	if err != nil {
+		return nil, fmt.Errorf("download folder %s scan failed: %s", match_folder, err)
+	}
+
+	var queued map[string]bool
+	if upload_queue != nil {
+		queued = upload_queue.QueuedMatchIds(match_folder)
+	}
+
+	for _, json_file := range json_files {
+		json_path := path.Join(match_folder, json_file.Name())
+		fms_id := strings.Split(json_file.Name(), ".")[0]
+		changed := false
+		if fileExists(replaceExtension(json_path, "receipt")) {
+			// receipt exists, match was already uploaded to TBA, but is
+			// offered again if it has changed since
+			changed = matchChangedSinceUpload(match_folder, fms_id)
+			if !changed {
+				continue
+			}
+		}
+		if queued[fms_id] {
+			// waiting in the upload queue
+			continue
+		}
+
+		match_info := make(map[string]interface{})
+		contents, err := ioutil.ReadFile(json_path)
+		err = json.Unmarshal(contents, &match_info)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", json_path, err)
+		}
+
+		match_info["_fms_id"] = fms_id
+		if changed {
+			logger.Printf("match %s changed since it was uploaded\n", fms_id)
+			match_info["_changed_since_upload"] = true
+		}
+		match_json_list = append(match_json_list, match_info)
+	}
+	return match_json_list, nil
+}
+
+// download new (or all) matches from FMS and return the matches that have not been uploaded yet
+func fetchPendingMatches(options matchParseOptions, all bool) ([]map[string]interface{}, error) {
+	var files []string
+	var err error
+	if all {
+		files, err = downloadAllMatches(options.Level, options.Event)
+	} else {
+		files, err = downloadNewMatches(options.Level, options.Event)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("match download failed: %s", err)
+	}
+	err = convertDownloadedMatches(files, options)
+	if err != nil {
+		return nil, err
+	}
+	return loadPendingMatches(getMatchDownloadPath(options.Level, options.Event))
+}
+
+// delete downloaded files and receipts for the given FMS match IDs (or all
+// matches), so that they are downloaded and uploaded again
+func purgeMatches(level int, event string, match_ids []string, all bool) error {
+	match_folder := getMatchDownloadPath(level, event)
+	purge_ids := make(map[string]bool)
+	for _, mid := range match_ids {
+		purge_ids[mid] = true
+	}
+
+	match_files, err := ioutil.ReadDir(match_folder)
+	if err != nil {
+		return fmt.Errorf("download folder %s scan failed: %s", match_folder, err)
+	}
+	for _, file := range match_files {
+		if _, in_match_ids := purge_ids[strings.Split(file.Name(), ".")[0]]; in_match_ids || all {
+			ext := filepath.Ext(file.Name())
+			if (level != MATCH_LEVEL_MANUAL && (ext == ".html" || ext == ".json")) || ext == ".receipt" {
+				err := os.Remove(path.Join(match_folder, file.Name()))
+				if err != nil {
+					logger.Printf("purge: failed to delete %s: %v\n", file.Name(), err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// contents of a .receipt file, written once a match has been uploaded to TBA.
+// Older versions wrote only the FMS match ID.
+type matchReceipt struct {
+	FmsId string `json:"fms_id"`
+	// full TBA match key, e.g. 2024abc_qm1
+	TbaKey string `json:"tba_key,omitempty"`
+	// hash of the match as uploaded (see hashTBAMatch)
+	PayloadHash string    `json:"payload_hash,omitempty"`
+	Uploaded    time.Time `json:"uploaded"`
+	// marked as uploaded by a user, rather than after an upload
+	Manual bool `json:"manual,omitempty"`
+}
+
+func hashTBAMatch(match tba.Match) string {
+	out, _ := json.Marshal(match)
+	return fmt.Sprintf("%x", md5.Sum(out))
+}
+
+// the downloaded match with the given FMS match ID, as it would be uploaded
+func loadLocalTBAMatch(match_folder string, fms_id string) (*tba.Match, error) {
+	contents, err := ioutil.ReadFile(path.Join(match_folder, fms_id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	match := make(map[string]interface{})
+	err = json.Unmarshal(contents, &match)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := matchesToPayload([]map[string]interface{}{match})
+	if err != nil {
+		return nil, err
+	}
+	return &payload[0], nil
+}
+
+func readMatchReceipt(match_folder string, fms_id string) (*matchReceipt, error) {
+	contents, err := ioutil.ReadFile(path.Join(match_folder, fms_id+".receipt"))
+	if err != nil {
+		return nil, err
+	}
+	var receipt matchReceipt
+	if json.Unmarshal(contents, &receipt) != nil {
+		receipt = matchReceipt{FmsId: strings.TrimSpace(string(contents))}
+	}
+	return &receipt, nil
+}
+
+// whether a downloaded match differs from the version recorded in its receipt.
+// Matches without a recorded version are considered unchanged.
+func matchChangedSinceUpload(match_folder string, fms_id string) bool {
+	receipt, err := readMatchReceipt(match_folder, fms_id)
+	if err != nil || receipt.PayloadHash == "" {
+		return false
+	}
+	local, err := loadLocalTBAMatch(match_folder, fms_id)
+	if err != nil {
+		return false
+	}
+	return hashTBAMatch(*local) != receipt.PayloadHash
+}
+
+// write receipts for uploaded matches so that they are not offered again.
+// uploaded is the matches/update payload that was delivered, in the same order
+// as match_ids, or nil if the matches were marked as uploaded by a user, in
+// which case their current local versions are recorded.
+func writeMatchReceipts(match_folder string, event string, match_ids []string, uploaded tba.MatchList) {
+	now := time.Now()
+	for i, fms_id := range match_ids {
+		receipt := matchReceipt{FmsId: fms_id, Uploaded: now, Manual: uploaded == nil}
+		local, err := loadLocalTBAMatch(match_folder, fms_id)
+		var version *tba.Match
+		if uploaded == nil {
+			version = local
+		} else if len(uploaded) == len(match_ids) {
+			version = &uploaded[i]
+		} else if err == nil {
+			for j := range uploaded {
+				if uploaded[j].PartialKey() == local.PartialKey() {
+					version = &uploaded[j]
+				}
+			}
+		}
+		if version != nil {
+			receipt.TbaKey = event + "_" + version.PartialKey()
+			receipt.PayloadHash = hashTBAMatch(*version)
+		}
+		out, _ := json.MarshalIndent(receipt, "", "  ")
+		err = ioutil.WriteFile(path.Join(match_folder, fms_id+".receipt"), out, os.ModePerm)
+		if err != nil {
+			logger.Printf("failed to write receipt for match %s: %s\n", fms_id, err)
+		}
+	}
+}
+
+// find problems that would prevent a match from being uploaded unattended
+// (mirrors checkScorelessMatches and findUnhandledBreakdowns in the web UI)
+func validateMatchForUpload(match map[string]interface{}) error {
+	for _, alliance := range []string{"red", "blue"} {
+		score, err := readFromStringGenericMap[float64](match, "alliances", alliance, "score")
+		if err != nil {
+			return fmt.Errorf("%s score: %s", alliance, err)
+		} else if score < 0 {
+			return fmt.Errorf("%s score missing", alliance)
+		}
+
+		teams, err := readFromStringGenericMap[[]interface{}](match, "alliances", alliance, "teams")
+		if err != nil {
+			return fmt.Errorf("%s teams: %s", alliance, err)
+		} else if len(teams) == 0 || teams[0] == "" {
+			return fmt.Errorf("%s teams missing", alliance)
+		}
+
+		breakdown, err := readFromStringGenericMap[map[string]interface{}](match, "score_breakdown", alliance)
+		if err == nil {
+			for field := range breakdown {
+				if strings.HasPrefix(field, "!") {
+					return fmt.Errorf("%s breakdown has unhandled field: %s", alliance, strings.ReplaceAll(field, "!", ""))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// strip local-only fields from matches before sending them to TBA
+func cleanMatchesForUpload(matches []map[string]interface{}) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(matches))
+	for i, match := range matches {
+		out[i] = make(map[string]interface{})
+		for k, v := range match {
+			if !strings.HasPrefix(k, "_") {
+				out[i][k] = v
+			}
+		}
+	}
+	return out
+}
+
+// convert locally-stored matches to a TBA matches/update payload
+func matchesToPayload(matches []map[string]interface{}) (tba.MatchList, error) {
+	raw, err := json.Marshal(cleanMatchesForUpload(matches))
+	if err != nil {
+		return nil, fmt.Errorf("json encode failed: %s", err)
+	}
+	var payload tba.MatchList
+	err = json.Unmarshal(raw, &payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid match data: %s", err)
+	}
+	return payload, nil
+}
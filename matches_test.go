@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func makeTestMatch(red_score int, blue_teams string) map[string]interface{} {
+	match := make(map[string]interface{})
+	raw := fmt.Sprintf(`{
+		"alliances": {
+			"red": {"teams": ["frc1", "frc2", "frc3"], "score": %d},
+			"blue": {"teams": %s, "score": 10}
+		},
+		"score_breakdown": {"red": {"totalPoints": 10}, "blue": {"totalPoints": 10}}
+	}`, red_score, blue_teams)
+	json.Unmarshal([]byte(raw), &match)
+	return match
+}
+
+func TestValidateMatchForUpload(t *testing.T) {
+	if err := validateMatchForUpload(makeTestMatch(10, `["frc4", "frc5", "frc6"]`)); err != nil {
+		t.Error("valid match rejected:", err)
+	}
+	if err := validateMatchForUpload(makeTestMatch(-1, `["frc4", "frc5", "frc6"]`)); err == nil {
+		t.Error("scoreless match accepted")
+	}
+	if err := validateMatchForUpload(makeTestMatch(10, `["", "", ""]`)); err == nil {
+		t.Error("match without teams accepted")
+	}
+
+	match := makeTestMatch(10, `["frc4", "frc5", "frc6"]`)
+	match["score_breakdown"].(map[string]interface{})["red"].(map[string]interface{})["!foo"] = 1
+	if err := validateMatchForUpload(match); err == nil {
+		t.Error("match with unhandled breakdown accepted")
+	}
+}
+
+func TestCleanMatchesForUpload(t *testing.T) {
+	matches := cleanMatchesForUpload([]map[string]interface{}{
+		{"_fms_id": "1-1", "comp_level": "qm"},
+	})
+	if _, ok := matches[0]["_fms_id"]; ok {
+		t.Error("_fms_id not removed")
+	}
+	if matches[0]["comp_level"] != "qm" {
+		t.Error("comp_level removed")
+	}
+}
+
+func TestMatchReceipts(t *testing.T) {
+	folder := t.TempDir()
+	match := makeTestMatch(10, `["frc4", "frc5", "frc6"]`)
+	match["comp_level"], match["set_number"], match["match_number"] = "qm", 1, 3
+	writeMatch := func() {
+		out, _ := json.Marshal(match)
+		ioutil.WriteFile(path.Join(folder, "3-1.json"), out, os.ModePerm)
+	}
+	writeMatch()
+
+	uploaded, err := matchesToPayload([]map[string]interface{}{match})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeMatchReceipts(folder, "2023test", []string{"3-1"}, uploaded)
+	receipt, err := readMatchReceipt(folder, "3-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.FmsId != "3-1" || receipt.TbaKey != "2023test_qm3" || receipt.PayloadHash != hashTBAMatch(uploaded[0]) || receipt.Manual {
+		t.Error("unexpected receipt:", receipt)
+	}
+	if pending, _ := loadPendingMatches(folder); len(pending) != 0 {
+		t.Error("uploaded match offered again:", pending)
+	}
+
+	// local changes after the upload are detected
+	match["alliances"].(map[string]interface{})["red"].(map[string]interface{})["score"] = 20
+	writeMatch()
+	pending, err := loadPendingMatches(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0]["_changed_since_upload"] != true {
+		t.Error("changed match not offered again:", pending)
+	}
+
+	// marking as uploaded records the current version
+	writeMatchReceipts(folder, "2023test", []string{"3-1"}, nil)
+	if pending, _ := loadPendingMatches(folder); len(pending) != 0 {
+		t.Error("match marked as uploaded offered again:", pending)
+	}
+
+	// receipts from older versions contain only the match ID
+	ioutil.WriteFile(path.Join(folder, "3-1.receipt"), []byte("3-1"), os.ModePerm)
+	if receipt, err := readMatchReceipt(folder, "3-1"); err != nil || receipt.FmsId != "3-1" {
+		t.Error("old receipt not read:", receipt, err)
+	}
+	if pending, _ := loadPendingMatches(folder); len(pending) != 0 {
+		t.Error("match with old receipt offered again:", pending)
+	}
+}
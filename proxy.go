@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const proxyMaxRequestSize = 1 << 20
+const proxyMaxResponseSize = 10 << 20
+const proxyTimeout = 5 * time.Second
+
+// hosts that /api/proxy may forward to when proxy_allowed_hosts is not set in
+// config.json: the autoAV vMix and helper APIs. The TBA host is always allowed.
+var defaultProxyAllowedHosts = []string{"localhost:8088", "localhost:8807", "127.0.0.1:8088", "127.0.0.1:8807"}
+
+// guarded by config_mutex; nil to use defaultProxyAllowedHosts
+var proxy_allowed_hosts []string
+
+var proxyAllowedMethods = map[string]bool{"GET": true, "HEAD": true, "POST": true}
+
+// headers forwarded to the target; everything else (cookies, X-Auth/X-Secret, etc.) is dropped
+var proxyRequestHeaders = []string{"Accept", "Content-Type", "If-Modified-Since", "If-None-Match", "X-TBA-Auth-Key"}
+
+// headers returned to the browser
+var proxyResponseHeaders = []string{"Cache-Control", "Content-Type", "ETag", "Expires", "Last-Modified"}
+
+// an allowlist entry is either "host:port" or "host", which matches any port
+func validateProxyHost(entry string) error {
+	if entry == "" || strings.ContainsAny(entry, "/?#@ ") {
+		return fmt.Errorf("invalid proxy host: %q (expected host or host:port)", entry)
+	}
+	return nil
+}
+
+func getProxyAllowedHosts() []string {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	hosts := proxy_allowed_hosts
+	if hosts == nil {
+		hosts = defaultProxyAllowedHosts
+	}
+	if tba_url, err := url.Parse(FMSConfig.TbaUrl); err == nil && tba_url.Host != "" {
+		hosts = append([]string{tba_url.Host}, hosts...)
+	}
+	return hosts
+}
+
+func checkProxyTarget(target *url.URL) error {
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme: %q", target.Scheme)
+	}
+	host := strings.ToLower(target.Host)
+	hostname := strings.ToLower(target.Hostname())
+	if target.Port() == "" {
+		// compare against explicit default ports too
+		if target.Scheme == "https" {
+			host = net.JoinHostPort(hostname, "443")
+		} else {
+			host = net.JoinHostPort(hostname, "80")
+		}
+	}
+	for _, entry := range getProxyAllowedHosts() {
+		entry = strings.ToLower(entry)
+		if entry == host || entry == hostname || entry == strings.ToLower(target.Host) {
+			return nil
+		}
+	}
+	return fmt.Errorf("host not allowed: %s", target.Host)
+}
+
+func apiProxy(w http.ResponseWriter, r *http.Request) {
+	raw_url := checkRequestQueryParam(r, "url")
+	target, err := url.Parse(raw_url)
+	if err != nil {
+		apiPanicBadRequest("invalid url: %s", err)
+	}
+	if !proxyAllowedMethods[r.Method] {
+		logger.Printf("Proxy: rejected %s %s: method not allowed\n", r.Method, raw_url)
+		apiPanicErrorCode(http.StatusForbidden, API_ERROR_PROXY_DENIED, "proxy: method not allowed: %s", r.Method)
+	}
+	if err := checkProxyTarget(target); err != nil {
+		logger.Printf("Proxy: rejected %s %s: %s\n", r.Method, raw_url, err)
+		apiPanicErrorCode(http.StatusForbidden, API_ERROR_PROXY_DENIED, "proxy: %s (add it to proxy_allowed_hosts in %s)", err, configFilename)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, proxyMaxRequestSize))
+	if err != nil {
+		apiPanicCode(http.StatusRequestEntityTooLarge, "proxy: request body too large (limit %d bytes)", proxyMaxRequestSize)
+	}
+	request, err := http.NewRequest(r.Method, target.String(), bytes.NewReader(body))
+	if err != nil {
+		apiPanicInternal("unable to open proxy request: %v", err)
+	}
+	for _, header_name := range proxyRequestHeaders {
+		if value := r.Header.Get(header_name); value != "" {
+			request.Header.Set(header_name, value)
+		}
+	}
+
+	client := http.Client{
+		Timeout: proxyTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			return checkProxyTarget(req.URL)
+		},
+	}
+	start := time.Now()
+	response, err := client.Do(request)
+	if err != nil {
+		apiPanicCode(http.StatusBadGateway, "proxy request failed: %v", err)
+	}
+	defer response.Body.Close()
+	response_body, err := ioutil.ReadAll(io.LimitReader(response.Body, proxyMaxResponseSize+1))
+	if err != nil {
+		apiPanicCode(http.StatusBadGateway, "proxy request failed: %v", err)
+	} else if len(response_body) > proxyMaxResponseSize {
+		apiPanicCode(http.StatusBadGateway, "proxy: response too large (limit %d bytes)", proxyMaxResponseSize)
+	}
+	logger.Printf("Proxy: %s %s -> %d (%d bytes, %s)\n", r.Method, raw_url, response.StatusCode,
+		len(response_body), time.Since(start).Round(time.Millisecond))
+
+	for _, header_name := range proxyResponseHeaders {
+		if value := response.Header.Get(header_name); value != "" {
+			w.Header().Set(header_name, value)
+		}
+	}
+	w.WriteHeader(response.StatusCode)
+	w.Write(response_body)
+}
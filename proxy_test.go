@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Cookie") != "" || r.Header.Get("X-Secret") != "" {
+			t.Error("private request headers forwarded:", r.Header)
+		}
+		if r.Header.Get("X-TBA-Auth-Key") != "key" {
+			t.Error("X-TBA-Auth-Key not forwarded")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "a=b")
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer target.Close()
+	target_url, _ := url.Parse(target.URL)
+
+	old_tba_url, old_hosts := FMSConfig.TbaUrl, proxy_allowed_hosts
+	defer func() {
+		FMSConfig.TbaUrl, proxy_allowed_hosts = old_tba_url, old_hosts
+	}()
+	FMSConfig.TbaUrl = "https://www.thebluealliance.com"
+	proxy_allowed_hosts = []string{}
+
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/proxy", ROLE_OPERATOR, apiProxy)
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	proxy := func(method string, target string, body string) (*http.Response, string) {
+		request, _ := http.NewRequest(method, server.URL+"/api/proxy?"+url.Values{"url": {target}}.Encode(), strings.NewReader(body))
+		request.Header.Set("Cookie", "tba_uploader_session=abc")
+		request.Header.Set("X-Secret", "secret")
+		request.Header.Set("X-TBA-Auth-Key", "key")
+		res, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		res_body, _ := ioutil.ReadAll(res.Body)
+		return res, string(res_body)
+	}
+
+	if res, body := proxy("GET", target.URL+"/api", ""); res.StatusCode != http.StatusForbidden {
+		t.Error("request to host not in allowlist:", res.StatusCode, body)
+	}
+
+	proxy_allowed_hosts = []string{target_url.Host}
+	res, body := proxy("GET", target.URL+"/api", "")
+	if res.StatusCode != http.StatusOK || body != `{"ok": true}` {
+		t.Error("allowed request failed:", res.StatusCode, body)
+	}
+	if res.Header.Get("Set-Cookie") != "" || res.Header.Get("Access-Control-Allow-Origin") != "" {
+		t.Error("unexpected response headers:", res.Header)
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Error("Content-Type not returned")
+	}
+
+	if res, _ := proxy("DELETE", target.URL+"/api", ""); res.StatusCode != http.StatusForbidden {
+		t.Error("DELETE allowed:", res.StatusCode)
+	}
+	if res, _ := proxy("GET", "file:///etc/passwd", ""); res.StatusCode != http.StatusForbidden {
+		t.Error("file URL allowed:", res.StatusCode)
+	}
+	if res, _ := proxy("POST", target.URL+"/api", strings.Repeat("x", proxyMaxRequestSize+1)); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Error("oversized request allowed:", res.StatusCode)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// keys should match https://github.com/the-blue-alliance/the-blue-alliance/blob/py3/src/backend/common/consts/ranking_sort_orders.py
+// (served to the web UI as RANKING_NAMES by /js/rankings.js)
+var rankingNames = map[int][]string{
+	2018: {"Ranking Score", "End Game", "Auto", "Ownership", "Vault"},
+	2019: {"Ranking Score", "Cargo", "Hatch Panel", "HAB Climb", "Sandstorm Bonus"},
+	2022: {"Ranking Score", "Avg Match", "Avg Hangar", "Avg Taxi + Auto Cargo"},
+	2023: {"Ranking Score", "Avg Match", "Avg Charge Station", "Avg Auto"},
+}
+
+type fmsRankingsResponse struct {
+	QualRanks []map[string]interface{} `json:"qualRanks"`
+}
+
+// FMS sometimes reports numbers as strings
+func rankingInt(value interface{}) int {
+	switch n := value.(type) {
+	case float64:
+		return int(n)
+	case string:
+		f, _ := strconv.ParseFloat(n, 64)
+		return int(f)
+	}
+	return 0
+}
+
+// convert rankings from the FMS pit display (/Pit/GetData) to the TBA rankings/update format
+// (mirrors convertToTBARankings in web/src/tba.js)
+func convertFMSRankings(year int, fms_rankings []byte) (tba.Rankings, error) {
+	names, ok := rankingNames[year]
+	if !ok {
+		return tba.Rankings{}, fmt.Errorf("unsupported year for rankings: %d", year)
+	}
+
+	var data fmsRankingsResponse
+	err := json.Unmarshal(fms_rankings, &data)
+	if err != nil {
+		return tba.Rankings{}, fmt.Errorf("failed to parse FMS rankings: %s", err)
+	}
+	if len(data.QualRanks) == 0 {
+		return tba.Rankings{}, fmt.Errorf("no rankings available from FMS")
+	}
+
+	rankings := make([]tba.Ranking, len(data.QualRanks))
+	for i, r := range data.QualRanks {
+		rankings[i] = tba.Ranking{
+			TeamKey:    fmt.Sprintf("frc%v", r["team"]),
+			Rank:       rankingInt(r["rank"]),
+			Played:     rankingInt(r["played"]),
+			Dqs:        rankingInt(r["dq"]),
+			Wins:       rankingInt(r["wins"]),
+			Losses:     rankingInt(r["losses"]),
+			Ties:       rankingInt(r["ties"]),
+			Breakdowns: make(map[string]interface{}),
+		}
+		for j, name := range names {
+			rankings[i].Breakdowns[name] = r[fmt.Sprintf("sort%d", j+1)]
+		}
+	}
+
+	return tba.Rankings{
+		Breakdowns: names,
+		Rankings:   rankings,
+	}, nil
+}
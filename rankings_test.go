@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConvertFMSRankings(t *testing.T) {
+	fms_rankings := []byte(`{"qualRanks": [
+		{"rank": 1, "team": 254, "played": "10", "dq": 0, "wins": 9, "losses": 1, "ties": 0,
+			"sort1": 3.5, "sort2": 120, "sort3": 20, "sort4": 15}
+	]}`)
+	res, err := convertFMSRankings(2023, fms_rankings)
+	if err != nil {
+		t.Fatal("convertFMSRankings:", err)
+	}
+	rankings := res.Rankings
+	if len(rankings) != 1 {
+		t.Fatal("wrong ranking count:", len(rankings))
+	}
+	if rankings[0].TeamKey != "frc254" {
+		t.Error("wrong team key:", rankings[0].TeamKey)
+	}
+	if rankings[0].Played != 10 {
+		t.Errorf("string number not converted: %#v", rankings[0].Played)
+	}
+	if rankings[0].Breakdowns["Avg Auto"] != 15.0 {
+		t.Error("wrong breakdown value:", rankings[0].Breakdowns["Avg Auto"])
+	}
+	if err := res.Validate(); err != nil {
+		t.Error("converted rankings are invalid:", err)
+	}
+
+	_, err = convertFMSRankings(2023, []byte(`{"qualRanks": []}`))
+	if err == nil {
+		t.Error("expected error for empty rankings")
+	}
+
+	_, err = convertFMSRankings(2000, fms_rankings)
+	if err == nil {
+		t.Error("expected error for unsupported year")
+	}
+}
+
+func TestJsRankingNames(t *testing.T) {
+	w := httptest.NewRecorder()
+	jsRankingNames(w, httptest.NewRequest("GET", "/js/rankings.js", nil))
+	body := w.Body.String()
+	if !strings.HasPrefix(body, "window.RANKING_NAMES=") || !strings.Contains(body, `"2023":["Ranking Score","Avg Match","Avg Charge Station","Avg Auto"]`) {
+		t.Error("unexpected rankings.js:", body)
+	}
+}
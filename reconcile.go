@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+type localMatch struct {
+	FmsId    string
+	Uploaded bool
+	Match    tba.Match
+}
+
+type matchFieldDiff struct {
+	Field string      `json:"field"`
+	Local interface{} `json:"local"`
+	Tba   interface{} `json:"tba"`
+}
+
+type reconcileMatch struct {
+	Key string `json:"key"`
+	// latest local play of this match, if any
+	FmsId string `json:"fms_id,omitempty"`
+	// whether a receipt exists locally
+	Uploaded bool             `json:"uploaded"`
+	Diffs    []matchFieldDiff `json:"diffs,omitempty"`
+}
+
+type reconcileReport struct {
+	Event string `json:"event"`
+	Level int    `json:"level"`
+	// played matches on TBA that are not stored locally
+	MissingLocal []reconcileMatch `json:"missing_local"`
+	// local matches that TBA does not have
+	MissingTba []reconcileMatch `json:"missing_tba"`
+	Different  []reconcileMatch `json:"different"`
+	Matching   int              `json:"matching"`
+}
+
+// parse "match-play" FMS IDs, e.g. "12-2"
+func parseFmsMatchId(fms_id string) (match int, play int) {
+	parts := strings.Split(fms_id, "-")
+	match, _ = strconv.Atoi(parts[0])
+	if len(parts) > 1 {
+		play, _ = strconv.Atoi(parts[1])
+	}
+	return
+}
+
+// load all converted matches in match_folder, keyed by partial TBA match key.
+// If a match has multiple plays, only the latest is returned.
+func loadLocalMatches(match_folder string) (map[string]localMatch, error) {
+	json_files, err := listFilesWithExtension(match_folder, "json")
+	if err != nil {
+		return nil, fmt.Errorf("download folder %s scan failed: %s", match_folder, err)
+	}
+	fms_ids := make([]string, len(json_files))
+	for i, file := range json_files {
+		fms_ids[i] = strings.Split(file.Name(), ".")[0]
+	}
+	sort.Slice(fms_ids, func(i, j int) bool {
+		match_i, play_i := parseFmsMatchId(fms_ids[i])
+		match_j, play_j := parseFmsMatchId(fms_ids[j])
+		if match_i != match_j {
+			return match_i < match_j
+		}
+		return play_i < play_j
+	})
+
+	out := make(map[string]localMatch)
+	for _, fms_id := range fms_ids {
+		json_path := path.Join(match_folder, fms_id+".json")
+		contents, err := ioutil.ReadFile(json_path)
+		if err != nil {
+			return nil, err
+		}
+		var match tba.Match
+		err = json.Unmarshal(contents, &match)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", json_path, err)
+		}
+		out[match.PartialKey()] = localMatch{
+			FmsId:    fms_id,
+			Uploaded: fileExists(replaceExtension(json_path, "receipt")),
+			Match:    match,
+		}
+	}
+	return out, nil
+}
+
+// compare values after a JSON round trip, so that e.g. int and float64 scores compare equal
+func jsonEqual(a, b interface{}) bool {
+	normalize := func(v interface{}) interface{} {
+		raw, _ := json.Marshal(v)
+		var out interface{}
+		json.Unmarshal(raw, &out)
+		return out
+	}
+	return reflect.DeepEqual(normalize(a), normalize(b))
+}
+
+func nonNilTeams(teams []string) []string {
+	if teams == nil {
+		return []string{}
+	}
+	return teams
+}
+
+func diffMatches(local tba.Match, remote tba.ReadMatch) []matchFieldDiff {
+	diffs := make([]matchFieldDiff, 0)
+	add := func(field string, local_value, tba_value interface{}) {
+		if !jsonEqual(local_value, tba_value) {
+			diffs = append(diffs, matchFieldDiff{Field: field, Local: local_value, Tba: tba_value})
+		}
+	}
+
+	alliances := map[string][2]interface{}{
+		"red":  {local.Alliances.Red, remote.Alliances.Red},
+		"blue": {local.Alliances.Blue, remote.Alliances.Blue},
+	}
+	for _, color := range []string{"red", "blue"} {
+		local_alliance := alliances[color][0].(tba.MatchAlliance)
+		tba_alliance := alliances[color][1].(tba.ReadMatchAlliance)
+		prefix := "alliances." + color + "."
+		add(prefix+"score", local_alliance.Score, tba_alliance.Score)
+		add(prefix+"teams", nonNilTeams(local_alliance.Teams), nonNilTeams(tba_alliance.TeamKeys))
+		add(prefix+"surrogates", nonNilTeams(local_alliance.Surrogates), nonNilTeams(tba_alliance.SurrogateTeamKeys))
+		add(prefix+"dqs", nonNilTeams(local_alliance.Dqs), nonNilTeams(tba_alliance.DqTeamKeys))
+
+		// only fields that we upload are compared; TBA may add its own
+		local_breakdown := local.ScoreBreakdown[color]
+		tba_breakdown := remote.ScoreBreakdown[color]
+		fields := make([]string, 0, len(local_breakdown))
+		for field := range local_breakdown {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			add("score_breakdown."+color+"."+field, local_breakdown[field], tba_breakdown[field])
+		}
+	}
+	return diffs
+}
+
+// whether matches of the given comp level are stored in the given level folder
+func compLevelInMatchLevel(comp_level string, level int) bool {
+	switch level {
+	case MATCH_LEVEL_QUAL:
+		return comp_level == "qm"
+	case MATCH_LEVEL_PLAYOFF:
+		return comp_level != "qm"
+	case MATCH_LEVEL_MANUAL:
+		return true
+	}
+	return false
+}
+
+func buildReconcileReport(event string, level int, local map[string]localMatch, remote []tba.ReadMatch) reconcileReport {
+	report := reconcileReport{
+		Event:        event,
+		Level:        level,
+		MissingLocal: make([]reconcileMatch, 0),
+		MissingTba:   make([]reconcileMatch, 0),
+		Different:    make([]reconcileMatch, 0),
+	}
+
+	remote_by_key := make(map[string]tba.ReadMatch)
+	for _, match := range remote {
+		key := match.PartialKey()
+		remote_by_key[key] = match
+		if _, ok := local[key]; !ok && compLevelInMatchLevel(match.CompLevel, level) {
+			// unplayed matches from the schedule are expected to be missing locally
+			if match.Alliances.Red.Score >= 0 || match.Alliances.Blue.Score >= 0 {
+				report.MissingLocal = append(report.MissingLocal, reconcileMatch{Key: key})
+			}
+		}
+	}
+
+	for key, match := range local {
+		result := reconcileMatch{
+			Key:      key,
+			FmsId:    match.FmsId,
+			Uploaded: match.Uploaded,
+		}
+		remote_match, ok := remote_by_key[key]
+		if !ok {
+			report.MissingTba = append(report.MissingTba, result)
+			continue
+		}
+		result.Diffs = diffMatches(match.Match, remote_match)
+		if len(result.Diffs) > 0 {
+			report.Different = append(report.Different, result)
+		} else {
+			report.Matching++
+		}
+	}
+
+	for _, list := range [][]reconcileMatch{report.MissingLocal, report.MissingTba, report.Different} {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Key < list[j].Key
+		})
+	}
+	return report
+}
+
+func reconcileMatches(event string, level int) (reconcileReport, error) {
+	client, err := getTBAReadClient()
+	if err != nil {
+		return reconcileReport{}, err
+	}
+	remote, err := client.EventMatches(event)
+	if err != nil {
+		return reconcileReport{}, err
+	}
+	writeAuditMatchRead(event, remote)
+	local, err := loadLocalMatches(getMatchDownloadPath(level, event))
+	if err != nil {
+		return reconcileReport{}, err
+	}
+	return buildReconcileReport(event, level, local, remote), nil
+}
+
+func apiReconcile(w http.ResponseWriter, r *http.Request) {
+	event := checkRequestQueryParam(r, "event")
+	level := checkRequestLevel(r)
+	report, err := reconcileMatches(event, level)
+	if err != nil {
+		apiPanicInternal("reconcile failed: %s", err)
+	}
+	sendJson(w, report)
+}
+
+// re-upload local matches that differ from or are missing on TBA. The body
+// can optionally list the FMS IDs to re-upload; by default, all mismatched
+// matches are re-uploaded.
+func apiReconcileRequeue(w http.ResponseWriter, r *http.Request) {
+	params := checkRequestEventParams(r)
+	level := checkRequestLevel(r)
+	match_folder := getMatchDownloadPath(level, params.Event)
+
+	fms_ids := make([]string, 0)
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) > 0 {
+		err := json.Unmarshal(body, &fms_ids)
+		if err != nil {
+			apiPanicBadRequest("failed to parse match ID list: %s", err)
+		}
+		if err := validateFmsMatchIds(fms_ids); err != nil {
+			apiPanicBadRequest("%s", err)
+		}
+	}
+	if len(fms_ids) == 0 {
+		report, err := reconcileMatches(params.Event, level)
+		if err != nil {
+			apiPanicInternal("reconcile failed: %s", err)
+		}
+		for _, list := range [][]reconcileMatch{report.MissingTba, report.Different} {
+			for _, match := range list {
+				fms_ids = append(fms_ids, match.FmsId)
+			}
+		}
+	}
+	if len(fms_ids) == 0 {
+		sendJson(w, map[string]interface{}{
+			"queued": fms_ids,
+		})
+		return
+	}
+
+	matches := make([]map[string]interface{}, 0, len(fms_ids))
+	for _, fms_id := range fms_ids {
+		json_path := path.Join(match_folder, fms_id+".json")
+		contents, err := ioutil.ReadFile(json_path)
+		if err != nil {
+			apiPanicBadRequest("match %s not found: %s", fms_id, err)
+		}
+		match := make(map[string]interface{})
+		err = json.Unmarshal(contents, &match)
+		if err != nil {
+			apiPanicInternal("failed to parse %s: %s", json_path, err)
+		}
+		matches = append(matches, match)
+	}
+	payload, err := matchesToPayload(matches)
+	if err != nil {
+		apiPanicInternal("%s", err)
+	}
+
+	if isRequestDryRun(r) {
+		sendTBADryRun(w, payload, params, getRequestOperator(r))
+		return
+	}
+	// receipts are rewritten once the queue has delivered the matches, and the
+	// matches are not offered for upload while they are queued
+	id, err := queueTBAPayload(payload, params, &queueReceipts{
+		Folder:   match_folder,
+		MatchIds: fms_ids,
+	}, getRequestOperator(r))
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	logger.Printf("reconcile: queued %d matches for re-upload: %v\n", len(fms_ids), fms_ids)
+
+	sendJson(w, map[string]interface{}{
+		"queued":     fms_ids,
+		"request_id": id,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+const reconcileTestLocalMatch = `{
+	"comp_level": "qm", "set_number": 1, "match_number": %d,
+	"alliances": {
+		"red": {"teams": ["frc1", "frc2", "frc3"], "score": 10},
+		"blue": {"teams": ["frc4", "frc5", "frc6"], "score": 20, "dqs": ["frc4"]}
+	},
+	"score_breakdown": {"red": {"totalPoints": 10}, "blue": {"totalPoints": 20}}
+}`
+
+const reconcileTestRemoteMatch = `{
+	"key": "2023test_qm%d", "comp_level": "qm", "set_number": 1, "match_number": %d,
+	"alliances": {
+		"red": {"team_keys": ["frc1", "frc2", "frc3"], "score": 10, "surrogate_team_keys": [], "dq_team_keys": []},
+		"blue": {"team_keys": ["frc4", "frc5", "frc6"], "score": %d, "surrogate_team_keys": [], "dq_team_keys": ["frc4"]}
+	},
+	"score_breakdown": {"red": {"totalPoints": 10, "rp": 1}, "blue": {"totalPoints": %d, "rp": 2}}
+}`
+
+func parseReconcileTestMatches(t *testing.T) (tba.Match, tba.ReadMatch) {
+	var local tba.Match
+	var remote tba.ReadMatch
+	if err := json.Unmarshal([]byte(fmt.Sprintf(reconcileTestLocalMatch, 1)), &local); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(fmt.Sprintf(reconcileTestRemoteMatch, 1, 1, 20, 20)), &remote); err != nil {
+		t.Fatal(err)
+	}
+	return local, remote
+}
+
+func TestDiffMatches(t *testing.T) {
+	local, remote := parseReconcileTestMatches(t)
+	if diffs := diffMatches(local, remote); len(diffs) != 0 {
+		t.Error("identical matches differ:", diffs)
+	}
+
+	remote.Alliances.Blue.Score = 25
+	remote.ScoreBreakdown["blue"]["totalPoints"] = 25.0
+	remote.Alliances.Red.SurrogateTeamKeys = []string{"frc2"}
+	diffs := diffMatches(local, remote)
+	fields := make(map[string]bool)
+	for _, diff := range diffs {
+		fields[diff.Field] = true
+	}
+	for _, field := range []string{"alliances.blue.score", "score_breakdown.blue.totalPoints", "alliances.red.surrogates"} {
+		if !fields[field] {
+			t.Error("missing diff for", field)
+		}
+	}
+	if len(diffs) != 3 {
+		t.Error("unexpected diffs:", diffs)
+	}
+}
+
+func TestLoadLocalMatchesLatestPlay(t *testing.T) {
+	folder, err := ioutil.TempDir("", "reconcile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	ioutil.WriteFile(path.Join(folder, "2-1.json"), []byte(fmt.Sprintf(reconcileTestLocalMatch, 2)), os.ModePerm)
+	ioutil.WriteFile(path.Join(folder, "2-10.json"), []byte(fmt.Sprintf(reconcileTestLocalMatch, 2)), os.ModePerm)
+	ioutil.WriteFile(path.Join(folder, "2-2.json"), []byte(fmt.Sprintf(reconcileTestLocalMatch, 2)), os.ModePerm)
+	ioutil.WriteFile(path.Join(folder, "2-10.receipt"), []byte("2-10"), os.ModePerm)
+
+	local, err := loadLocalMatches(folder)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(local) != 1 || local["qm2"].FmsId != "2-10" || !local["qm2"].Uploaded {
+		t.Error("expected latest play 2-10 (uploaded), got", local)
+	}
+}
+
+func TestBuildReconcileReport(t *testing.T) {
+	local := make(map[string]localMatch)
+	remote := make([]tba.ReadMatch, 0)
+	add_local := func(number int, fms_id string) {
+		var match tba.Match
+		json.Unmarshal([]byte(fmt.Sprintf(reconcileTestLocalMatch, number)), &match)
+		local[match.PartialKey()] = localMatch{FmsId: fms_id, Uploaded: true, Match: match}
+	}
+	add_remote := func(number int, blue_score int) {
+		var match tba.ReadMatch
+		json.Unmarshal([]byte(fmt.Sprintf(reconcileTestRemoteMatch, number, number, blue_score, blue_score)), &match)
+		remote = append(remote, match)
+	}
+	add_local(1, "1-1") // matching
+	add_remote(1, 20)
+	add_local(2, "2-1") // differs
+	add_remote(2, 30)
+	add_local(3, "3-1") // missing on TBA
+	add_remote(4, 20)   // missing locally
+	add_remote(5, -1)   // unplayed
+	remote[len(remote)-1].Alliances.Red.Score = -1
+
+	report := buildReconcileReport("2023test", MATCH_LEVEL_QUAL, local, remote)
+	if report.Matching != 1 {
+		t.Error("expected 1 matching match, got", report.Matching)
+	}
+	if len(report.Different) != 1 || report.Different[0].FmsId != "2-1" {
+		t.Error("unexpected different matches:", report.Different)
+	}
+	if len(report.MissingTba) != 1 || report.MissingTba[0].Key != "qm3" {
+		t.Error("unexpected matches missing on TBA:", report.MissingTba)
+	}
+	if len(report.MissingLocal) != 1 || report.MissingLocal[0].Key != "qm4" {
+		t.Error("unexpected matches missing locally:", report.MissingLocal)
+	}
+
+	report = buildReconcileReport("2023test", MATCH_LEVEL_PLAYOFF, map[string]localMatch{}, remote)
+	if len(report.MissingLocal) != 0 {
+		t.Error("qualification matches reported missing from playoffs:", report.MissingLocal)
+	}
+}
@@ -0,0 +1,82 @@
+package tba
+
+// Where an alliance in a set comes from: an alliance seed, or the winner or
+// loser of an earlier set (by set key, e.g. "sf1")
+type SetSource struct {
+	Seed   int    `json:"seed,omitempty"`
+	Winner string `json:"winner,omitempty"`
+	Loser  string `json:"loser,omitempty"`
+}
+
+func seed(n int) SetSource        { return SetSource{Seed: n} }
+func winner(set string) SetSource { return SetSource{Winner: set} }
+func loser(set string) SetSource  { return SetSource{Loser: set} }
+
+// red and blue sources of each set, by bracket type. Types not listed here
+// (and custom brackets) only learn set alliances from played matches.
+var bracketSources = map[int]map[string][2]SetSource{
+	BRACKET_TYPE_BRACKET_16_TEAM: {
+		"ef1": {seed(1), seed(16)},
+		"ef2": {seed(8), seed(9)},
+		"ef3": {seed(4), seed(13)},
+		"ef4": {seed(5), seed(12)},
+		"ef5": {seed(2), seed(15)},
+		"ef6": {seed(7), seed(10)},
+		"ef7": {seed(3), seed(14)},
+		"ef8": {seed(6), seed(11)},
+		"qf1": {winner("ef1"), winner("ef2")},
+		"qf2": {winner("ef3"), winner("ef4")},
+		"qf3": {winner("ef5"), winner("ef6")},
+		"qf4": {winner("ef7"), winner("ef8")},
+		"sf1": {winner("qf1"), winner("qf2")},
+		"sf2": {winner("qf3"), winner("qf4")},
+		"f1":  {winner("sf1"), winner("sf2")},
+	},
+	BRACKET_TYPE_BRACKET_8_TEAM: {
+		"qf1": {seed(1), seed(8)},
+		"qf2": {seed(4), seed(5)},
+		"qf3": {seed(2), seed(7)},
+		"qf4": {seed(3), seed(6)},
+		"sf1": {winner("qf1"), winner("qf2")},
+		"sf2": {winner("qf3"), winner("qf4")},
+		"f1":  {winner("sf1"), winner("sf2")},
+	},
+	BRACKET_TYPE_BRACKET_4_TEAM: {
+		"sf1": {seed(1), seed(4)},
+		"sf2": {seed(2), seed(3)},
+		"f1":  {winner("sf1"), winner("sf2")},
+	},
+	BRACKET_TYPE_BRACKET_2_TEAM: {
+		"f1": {seed(1), seed(2)},
+	},
+	BRACKET_TYPE_BO3_FINALS: {
+		"f1": {seed(1), seed(2)},
+	},
+	BRACKET_TYPE_BO5_FINALS: {
+		"f1": {seed(1), seed(2)},
+	},
+	BRACKET_TYPE_DOUBLE_ELIM_8_TEAM: {
+		"sf1":  {seed(1), seed(8)},
+		"sf2":  {seed(4), seed(5)},
+		"sf3":  {seed(2), seed(7)},
+		"sf4":  {seed(3), seed(6)},
+		"sf5":  {loser("sf1"), loser("sf2")},
+		"sf6":  {loser("sf3"), loser("sf4")},
+		"sf7":  {winner("sf1"), winner("sf2")},
+		"sf8":  {winner("sf3"), winner("sf4")},
+		"sf9":  {loser("sf7"), winner("sf6")},
+		"sf10": {loser("sf8"), winner("sf5")},
+		"sf11": {winner("sf7"), winner("sf8")},
+		"sf12": {winner("sf10"), winner("sf9")},
+		"sf13": {loser("sf11"), winner("sf12")},
+		"f1":   {winner("sf11"), winner("sf13")},
+	},
+	BRACKET_TYPE_DOUBLE_ELIM_4_TEAM: {
+		"sf1": {seed(1), seed(4)},
+		"sf2": {seed(2), seed(3)},
+		"sf3": {loser("sf1"), loser("sf2")},
+		"sf4": {winner("sf1"), winner("sf2")},
+		"sf5": {loser("sf4"), winner("sf3")},
+		"f1":  {winner("sf4"), winner("sf5")},
+	},
+}
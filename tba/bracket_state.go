@@ -0,0 +1,324 @@
+package tba
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	ALLIANCE_STATUS_PLAYING    = "playing"
+	ALLIANCE_STATUS_ELIMINATED = "eliminated"
+	ALLIANCE_STATUS_WON        = "won"
+)
+
+var playoffLevelOrder = map[string]int{"ef": 1, "qf": 2, "sf": 3, "f": 4}
+
+// placement of alliances eliminated in each double-elimination match (by sf
+// set number). Finals losers place 2nd.
+var doubleElimPlacements = map[int]map[int]int{
+	BRACKET_TYPE_DOUBLE_ELIM_8_TEAM: {5: 7, 6: 7, 9: 5, 10: 5, 12: 4, 13: 3},
+	BRACKET_TYPE_DOUBLE_ELIM_4_TEAM: {3: 4, 5: 3},
+}
+
+// Result of one set (a series of matches between the same two alliances, or
+// a single double-elimination match)
+type SetState struct {
+	Level string `json:"comp_level"`
+	Set   int    `json:"set_number"`
+	// alliance numbers (1-based) playing as red and blue, from the first
+	// played match or the bracket's set sources; 0 if not known yet
+	Alliances [2]int `json:"alliances"`
+	Wins      [2]int `json:"wins"`
+	Ties      int    `json:"ties"`
+	// matches in the set that are not tiebreakers
+	BestOf int `json:"best_of"`
+	// matches in the set, including tiebreakers
+	Matches int `json:"matches"`
+	// alliance number, or 0 if the set is undecided
+	Winner int `json:"winner"`
+	// all regular matches were played without a winner
+	NeedsTiebreaker bool `json:"needs_tiebreaker"`
+}
+
+// key relative to the event, e.g. "sf2"
+func (s SetState) Key() string {
+	return fmt.Sprintf("%s%d", s.Level, s.Set)
+}
+
+func (s SetState) loser() int {
+	if s.Winner == 0 {
+		return 0
+	} else if s.Winner == s.Alliances[0] {
+		return s.Alliances[1]
+	}
+	return s.Alliances[0]
+}
+
+type AllianceState struct {
+	// in the format of TBA's alliance status
+	Status ReadAllianceStatus `json:"status"`
+	// final placement (1 = winner), or 0 if still playing
+	Placement int `json:"placement"`
+}
+
+type BracketState struct {
+	Sets []SetState `json:"sets"`
+	// indexed by alliance number - 1
+	Alliances []AllianceState `json:"alliances"`
+}
+
+// how many matches of a set with matches_per_set matches are not tiebreakers
+func setBestOf(matches_per_set int) int {
+	if matches_per_set >= 8 {
+		return 5
+	} else if matches_per_set >= 3 {
+		return 3
+	}
+	return 1
+}
+
+func isDoubleElim(bracket_type int) bool {
+	_, ok := doubleElimPlacements[bracket_type]
+	return ok
+}
+
+// alliance number (1-based) that a match alliance belongs to, or 0. Backup
+// robots are not listed in alliance selections, so any listed team counts.
+func findAlliance(alliances [][]string, teams []string) int {
+	for _, team := range teams {
+		for i, alliance := range alliances {
+			for _, alliance_team := range alliance {
+				if alliance_team == team {
+					return i + 1
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// Determine set winners, alliance statuses and placements from played
+// playoff matches. bracket must be the bracket for bracket_type (custom
+// brackets are treated as series brackets). Unplayed matches (with negative
+// scores) are ignored.
+func ComputeBracketState(bracket_type int, bracket Bracket, alliances [][]string, matches []Match) (*BracketState, error) {
+	if bracket_type == BRACKET_TYPE_AVG_SCORE_8_TEAM || bracket_type == BRACKET_TYPE_ROUND_ROBIN_6_TEAM ||
+		bracket_type == BRACKET_TYPE_LEGACY_DOUBLE_ELIM_8_TEAM {
+		return nil, fmt.Errorf("advancement tracking is not supported for bracket type %d", bracket_type)
+	}
+	if len(bracket) == 0 {
+		return nil, fmt.Errorf("empty bracket")
+	}
+	double_elim := isDoubleElim(bracket_type)
+
+	// sets in FMS match order
+	match_ids := make([]int, 0, len(bracket))
+	for match_id := range bracket {
+		match_ids = append(match_ids, match_id)
+	}
+	sort.Ints(match_ids)
+	sets := make([]*SetState, 0)
+	set_index := make(map[MatchCode]*SetState)
+	matches_per_set := make(map[MatchCode]int)
+	fms_order := make(map[MatchCode]int)
+	for _, match_id := range match_ids {
+		code := bracket[match_id]
+		fms_order[code] = match_id
+		set_code := MatchCode{Level: code.Level, Set: code.Set}
+		if _, ok := set_index[set_code]; !ok {
+			set := &SetState{Level: code.Level, Set: code.Set}
+			set_index[set_code] = set
+			sets = append(sets, set)
+		}
+		if code.Match > matches_per_set[set_code] {
+			matches_per_set[set_code] = code.Match
+		}
+	}
+	for set_code, set := range set_index {
+		set.Matches = matches_per_set[set_code]
+		set.BestOf = setBestOf(set.Matches)
+	}
+
+	state := &BracketState{
+		Sets:      make([]SetState, 0, len(sets)),
+		Alliances: make([]AllianceState, len(alliances)),
+	}
+	// highest level each alliance has played at, and records overall and at that level
+	alliance_levels := make([]string, len(alliances))
+	records := make([]ReadRankingRecord, len(alliances))
+	current_records := make([]ReadRankingRecord, len(alliances))
+	sorted_matches := append([]Match{}, matches...)
+	sort.SliceStable(sorted_matches, func(i, j int) bool {
+		code_i := MatchCode{Level: sorted_matches[i].CompLevel, Set: sorted_matches[i].SetNumber, Match: sorted_matches[i].MatchNumber}
+		code_j := MatchCode{Level: sorted_matches[j].CompLevel, Set: sorted_matches[j].SetNumber, Match: sorted_matches[j].MatchNumber}
+		return fms_order[code_i] < fms_order[code_j]
+	})
+	for _, match := range sorted_matches {
+		set, ok := set_index[MatchCode{Level: match.CompLevel, Set: match.SetNumber}]
+		if !ok {
+			return nil, fmt.Errorf("%s is not in the bracket", match.PartialKey())
+		}
+		if match.Alliances.Red.Score < 0 || match.Alliances.Blue.Score < 0 {
+			continue
+		}
+		red := findAlliance(alliances, match.Alliances.Red.Teams)
+		blue := findAlliance(alliances, match.Alliances.Blue.Teams)
+		if red == 0 || blue == 0 {
+			return nil, fmt.Errorf("%s: teams are not in any alliance", match.PartialKey())
+		}
+		if set.Alliances[0] == 0 {
+			set.Alliances = [2]int{red, blue}
+		}
+		red_side := 0
+		if set.Alliances[0] == blue && set.Alliances[1] == red {
+			red_side = 1
+		} else if set.Alliances[0] != red || set.Alliances[1] != blue {
+			return nil, fmt.Errorf("%s: alliances %d and %d do not match earlier matches in the set", match.PartialKey(), red, blue)
+		}
+		if set.Winner != 0 {
+			continue
+		}
+
+		for _, number := range []int{red, blue} {
+			if playoffLevelOrder[match.CompLevel] > playoffLevelOrder[alliance_levels[number-1]] {
+				alliance_levels[number-1] = match.CompLevel
+				current_records[number-1] = ReadRankingRecord{}
+			}
+		}
+		record := func(number int, win, loss, tie int) {
+			for _, r := range []*ReadRankingRecord{&records[number-1], &current_records[number-1]} {
+				r.Wins += win
+				r.Losses += loss
+				r.Ties += tie
+			}
+		}
+		switch {
+		case match.Alliances.Red.Score > match.Alliances.Blue.Score:
+			set.Wins[red_side]++
+			record(red, 1, 0, 0)
+			record(blue, 0, 1, 0)
+		case match.Alliances.Blue.Score > match.Alliances.Red.Score:
+			set.Wins[1-red_side]++
+			record(red, 0, 1, 0)
+			record(blue, 1, 0, 0)
+		default:
+			set.Ties++
+			record(red, 0, 0, 1)
+			record(blue, 0, 0, 1)
+		}
+
+		wins_needed := set.BestOf/2 + 1
+		for side := 0; side < 2; side++ {
+			if set.Wins[side] >= wins_needed {
+				set.Winner = set.Alliances[side]
+			}
+		}
+		set.NeedsTiebreaker = set.Winner == 0 && set.Played() >= set.BestOf
+	}
+
+	// losers at a level place just below the winners of all sets at that level
+	level_sets := make(map[string]int)
+	for _, set := range sets {
+		level_sets[set.Level]++
+	}
+	final_level := sets[len(sets)-1].Level
+	losses := make([]int, len(alliances))
+	for i := range state.Alliances {
+		state.Alliances[i].Status = ReadAllianceStatus{
+			Level:              alliance_levels[i],
+			Record:             &records[i],
+			CurrentLevelRecord: &current_records[i],
+		}
+		if alliance_levels[i] != "" {
+			state.Alliances[i].Status.Status = ALLIANCE_STATUS_PLAYING
+		}
+	}
+	sources := bracketSources[bracket_type]
+	for _, set := range sets {
+		if set.Alliances[0] == 0 {
+			if set_sources, ok := sources[set.Key()]; ok {
+				for side, source := range set_sources {
+					set.Alliances[side] = resolveSetSource(source, set_index, len(alliances))
+				}
+			}
+		}
+		state.Sets = append(state.Sets, *set)
+		loser := set.loser()
+		if loser == 0 {
+			continue
+		}
+		losses[loser-1]++
+		eliminated := !double_elim || set.Level == final_level || losses[loser-1] >= 2
+		if eliminated {
+			alliance := &state.Alliances[loser-1]
+			alliance.Status.Status = ALLIANCE_STATUS_ELIMINATED
+			if double_elim && set.Level != final_level {
+				alliance.Placement = doubleElimPlacements[bracket_type][set.Set]
+			} else {
+				alliance.Placement = level_sets[set.Level] + 1
+			}
+		}
+		if set.Level == final_level {
+			winner := &state.Alliances[set.Winner-1]
+			winner.Status.Status = ALLIANCE_STATUS_WON
+			winner.Placement = 1
+		}
+	}
+	return state, nil
+}
+
+// alliance number for a set source, or 0 if it is not decided yet
+func resolveSetSource(source SetSource, set_index map[MatchCode]*SetState, alliance_count int) int {
+	if source.Seed != 0 {
+		if source.Seed <= alliance_count {
+			return source.Seed
+		}
+		return 0
+	}
+	for _, set := range set_index {
+		if set.Key() == source.Winner {
+			return set.Winner
+		} else if set.Key() == source.Loser {
+			return set.loser()
+		}
+	}
+	return 0
+}
+
+// number of matches played in a set
+func (s SetState) Played() int {
+	return s.Wins[0] + s.Wins[1] + s.Ties
+}
+
+// sets with both alliances known and no winner yet
+func (state *BracketState) UpcomingSets() []SetState {
+	out := make([]SetState, 0)
+	for _, set := range state.Sets {
+		if set.Winner == 0 && set.Alliances[0] != 0 && set.Alliances[1] != 0 {
+			out = append(out, set)
+		}
+	}
+	return out
+}
+
+// alliance numbers (1-based) that are still playing
+func (state *BracketState) Advancing() []int {
+	out := make([]int, 0)
+	for i, alliance := range state.Alliances {
+		if alliance.Status.Status == ALLIANCE_STATUS_PLAYING {
+			out = append(out, i+1)
+		}
+	}
+	return out
+}
+
+// sets that have started but need a tiebreaker match
+func (state *BracketState) PendingTiebreakers() []SetState {
+	out := make([]SetState, 0)
+	for _, set := range state.Sets {
+		if set.NeedsTiebreaker {
+			out = append(out, set)
+		}
+	}
+	return out
+}
@@ -0,0 +1,107 @@
+package tba
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testAlliances = [][]string{
+	{"frc1", "frc2", "frc3"},
+	{"frc4", "frc5", "frc6"},
+	{"frc7", "frc8", "frc9"},
+	{"frc10", "frc11", "frc12"},
+}
+
+// a played match between two alliances (1-based)
+func playoffMatch(key string, red int, blue int, red_score int, blue_score int) Match {
+	var code MatchCode
+	for _, c := range GetBracket(BRACKET_TYPE_BRACKET_4_TEAM) {
+		if c.PartialKey() == key {
+			code = c
+		}
+	}
+	for _, c := range GetBracket(BRACKET_TYPE_DOUBLE_ELIM_4_TEAM) {
+		if c.PartialKey() == key {
+			code = c
+		}
+	}
+	return Match{
+		CompLevel:   code.Level,
+		SetNumber:   code.Set,
+		MatchNumber: code.Match,
+		Alliances: MatchAlliances{
+			Red:  MatchAlliance{Teams: testAlliances[red-1], Score: red_score},
+			Blue: MatchAlliance{Teams: testAlliances[blue-1], Score: blue_score},
+		},
+	}
+}
+
+func TestBracketStateSeries(t *testing.T) {
+	matches := []Match{
+		playoffMatch("sf1m1", 1, 4, 10, 5),
+		playoffMatch("sf2m1", 2, 3, 10, 15),
+		playoffMatch("sf1m2", 1, 4, 10, 5),
+		playoffMatch("sf2m2", 2, 3, 20, 15),
+		playoffMatch("sf2m3", 2, 3, 20, 15),
+		playoffMatch("f1m1", 1, 2, 5, 5),
+		playoffMatch("f1m2", 1, 2, 10, 5),
+		playoffMatch("f1m3", 1, 2, 5, 10),
+	}
+	state, err := ComputeBracketState(BRACKET_TYPE_BRACKET_4_TEAM, GetBracket(BRACKET_TYPE_BRACKET_4_TEAM), testAlliances, matches)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 1, state.Sets[0].Winner)
+	assert.Equal(t, 2, state.Sets[1].Winner)
+	assert.Equal(t, [2]int{1, 1}, state.Sets[2].Wins)
+	assert.True(t, state.Sets[2].NeedsTiebreaker)
+	assert.Equal(t, []int{1, 2}, state.Advancing())
+	assert.Equal(t, []int{0, 0, 3, 3}, []int{
+		state.Alliances[0].Placement, state.Alliances[1].Placement,
+		state.Alliances[2].Placement, state.Alliances[3].Placement,
+	})
+	assert.Equal(t, ReadRankingRecord{Wins: 3, Losses: 1, Ties: 1}, *state.Alliances[0].Status.Record)
+	assert.Equal(t, ReadRankingRecord{Wins: 1, Losses: 1, Ties: 1}, *state.Alliances[0].Status.CurrentLevelRecord)
+
+	state, _ = ComputeBracketState(BRACKET_TYPE_BRACKET_4_TEAM, GetBracket(BRACKET_TYPE_BRACKET_4_TEAM), testAlliances,
+		append(matches, playoffMatch("f1m4", 1, 2, 0, 10)))
+	assert.Equal(t, 2, state.Sets[2].Winner)
+	assert.Equal(t, ALLIANCE_STATUS_WON, state.Alliances[1].Status.Status)
+	assert.Equal(t, 1, state.Alliances[1].Placement)
+	assert.Equal(t, ALLIANCE_STATUS_ELIMINATED, state.Alliances[0].Status.Status)
+	assert.Equal(t, 2, state.Alliances[0].Placement)
+	assert.Empty(t, state.PendingTiebreakers())
+}
+
+func TestBracketStateDoubleElim(t *testing.T) {
+	bracket := GetBracket(BRACKET_TYPE_DOUBLE_ELIM_4_TEAM)
+	state, err := ComputeBracketState(BRACKET_TYPE_DOUBLE_ELIM_4_TEAM, bracket, testAlliances, []Match{
+		playoffMatch("sf1m1", 1, 4, 10, 5),
+		playoffMatch("sf2m1", 2, 3, 10, 15),
+		playoffMatch("sf3m1", 4, 2, 10, 5),
+		playoffMatch("sf4m1", 1, 3, 10, 5),
+		playoffMatch("sf5m1", 3, 4, 10, 5),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []int{1, 3}, state.Advancing())
+	assert.Equal(t, 4, state.Alliances[1].Placement)
+	assert.Equal(t, 3, state.Alliances[3].Placement)
+	assert.Equal(t, "sf", state.Alliances[0].Status.Level)
+	upcoming := state.UpcomingSets()
+	if assert.Len(t, upcoming, 1) {
+		assert.Equal(t, "f1", upcoming[0].Key())
+		assert.Equal(t, [2]int{1, 3}, upcoming[0].Alliances)
+	}
+
+	_, err = ComputeBracketState(BRACKET_TYPE_DOUBLE_ELIM_4_TEAM, bracket, testAlliances, []Match{
+		playoffMatch("sf1m1", 1, 4, 10, 5),
+		playoffMatch("sf1m1", 2, 4, 10, 5),
+	})
+	assert.Error(t, err, "different alliances in the same set accepted")
+
+	_, err = ComputeBracketState(BRACKET_TYPE_ROUND_ROBIN_6_TEAM, GetBracket(BRACKET_TYPE_ROUND_ROBIN_6_TEAM), testAlliances, nil)
+	assert.Error(t, err)
+}
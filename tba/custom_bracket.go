@@ -0,0 +1,112 @@
+package tba
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A round of a custom bracket. FMS match numbers are assigned in the same
+// order as built-in brackets: match 1 of every set, then match 2, etc.
+type CustomBracketRound struct {
+	Level         string `json:"comp_level" yaml:"comp_level"`
+	Sets          int    `json:"sets" yaml:"sets"`
+	MatchesPerSet int    `json:"matches_per_set" yaml:"matches_per_set"`
+	// first set number in this round (default 1)
+	FirstSet int `json:"first_set,omitempty" yaml:"first_set,omitempty"`
+}
+
+// Definition of a BRACKET_TYPE_CUSTOM bracket, as rounds or an explicit
+// table of FMS match number -> match code. Entries in Matches override
+// numbers generated from Rounds.
+type CustomBracket struct {
+	Rounds  []CustomBracketRound `json:"rounds,omitempty" yaml:"rounds,omitempty"`
+	Matches map[int]MatchCode    `json:"matches,omitempty" yaml:"matches,omitempty"`
+}
+
+func validateMatchCode(code MatchCode) error {
+	if !compLevels[code.Level] || code.Level == "qm" {
+		return fmt.Errorf("invalid playoff comp_level: %q", code.Level)
+	}
+	if code.Set < 1 || code.Match < 1 {
+		return fmt.Errorf("set_number and match_number must be positive")
+	}
+	return nil
+}
+
+func (custom CustomBracket) Bracket() (Bracket, error) {
+	rounds := make([]playoffRoundInfo, len(custom.Rounds))
+	for i, round := range custom.Rounds {
+		if round.Sets < 1 || round.MatchesPerSet < 1 || round.FirstSet < 0 {
+			return nil, fmt.Errorf("round %d: sets and matches_per_set must be positive", i+1)
+		}
+		if err := validateMatchCode(MatchCode{Level: round.Level, Set: 1, Match: 1}); err != nil {
+			return nil, fmt.Errorf("round %d: %s", i+1, err)
+		}
+		rounds[i] = playoffRoundInfo{
+			level:           round.Level,
+			sets:            round.Sets,
+			matches_per_set: round.MatchesPerSet,
+			first_set:       round.FirstSet,
+		}
+	}
+	bracket := bracketFromRounds(rounds)
+
+	for match_id, code := range custom.Matches {
+		if match_id < 1 {
+			return nil, fmt.Errorf("invalid FMS match number: %d", match_id)
+		}
+		if err := validateMatchCode(code); err != nil {
+			return nil, fmt.Errorf("match %d: %s", match_id, err)
+		}
+		bracket[match_id] = code
+	}
+
+	if len(bracket) == 0 {
+		return nil, fmt.Errorf("bracket has no matches")
+	}
+	seen := make(map[MatchCode]int)
+	for match_id, code := range bracket {
+		if other, ok := seen[code]; ok {
+			if other > match_id {
+				match_id, other = other, match_id
+			}
+			return nil, fmt.Errorf("matches %d and %d both map to %s", other, match_id, code.PartialKey())
+		}
+		seen[code] = match_id
+	}
+	return bracket, nil
+}
+
+// parse a bracket definition; YAML is accepted if is_yaml is set, otherwise JSON
+func ParseCustomBracket(data []byte, is_yaml bool) (Bracket, error) {
+	var custom CustomBracket
+	var err error
+	if is_yaml {
+		err = yaml.Unmarshal(data, &custom)
+	} else {
+		err = json.Unmarshal(data, &custom)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return custom.Bracket()
+}
+
+// load a bracket definition from a .json, .yaml or .yml file
+func LoadCustomBracket(filename string) (Bracket, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	bracket, err := ParseCustomBracket(data, ext == ".yaml" || ext == ".yml")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Base(filename), err)
+	}
+	return bracket, nil
+}
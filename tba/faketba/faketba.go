@@ -0,0 +1,437 @@
+// Package faketba implements an in-memory stand-in for the TBA trusted
+// (v1) and read (v3) APIs, for testing uploads without touching
+// thebluealliance.com.
+package faketba
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// A failure to inject into the next request
+type Fault struct {
+	// status code to respond with; 0 responds normally after Delay
+	Status int
+	// time to wait before responding, e.g. to trigger client timeouts
+	Delay time.Duration
+}
+
+// A request received by the server
+type Request struct {
+	Method string
+	Path   string
+	Body   []byte
+	Status int
+}
+
+type eventData struct {
+	matches   map[string]tba.Match
+	rankings  *tba.Rankings
+	alliances tba.AllianceSelections
+	awards    tba.AwardList
+	teams     tba.TeamList
+}
+
+type Server struct {
+	// if set, read API requests must include this X-TBA-Auth-Key
+	ReadKey string
+
+	mutex sync.Mutex
+	// event -> auth ID -> secret
+	credentials map[string]map[string]string
+	events      map[string]*eventData
+	faults      []Fault
+	requests    []Request
+}
+
+func NewServer() *Server {
+	return &Server{
+		credentials: make(map[string]map[string]string),
+		events:      make(map[string]*eventData),
+	}
+}
+
+// accept trusted API requests for event signed with the given auth ID and secret
+func (s *Server) AddCredentials(event, auth_id, secret string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.credentials[event] == nil {
+		s.credentials[event] = make(map[string]string)
+	}
+	s.credentials[event][auth_id] = secret
+}
+
+// queue a fault to apply to the next request received
+func (s *Server) InjectFault(fault Fault) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.faults = append(s.faults, fault)
+}
+
+// all requests received so far
+func (s *Server) Requests() []Request {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// matches stored for an event, keyed by partial match key (e.g. "qm1")
+func (s *Server) Matches(event string) map[string]tba.Match {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	out := make(map[string]tba.Match)
+	for key, match := range s.event(event).matches {
+		out[key] = match
+	}
+	return out
+}
+
+func (s *Server) Rankings(event string) *tba.Rankings {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.event(event).rankings
+}
+
+func (s *Server) Alliances(event string) tba.AllianceSelections {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.event(event).alliances
+}
+
+// must be called with the mutex held
+func (s *Server) event(event string) *eventData {
+	if s.events[event] == nil {
+		s.events[event] = &eventData{
+			matches: make(map[string]tba.Match),
+		}
+	}
+	return s.events[event]
+}
+
+func writeJson(w http.ResponseWriter, status int, value interface{}) {
+	body, _ := json.Marshal(value)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJson(w, status, map[string]string{"Error": message})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		s.mutex.Lock()
+		s.requests = append(s.requests, Request{
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Body:   body,
+			Status: recorder.status,
+		})
+		s.mutex.Unlock()
+	}()
+
+	s.mutex.Lock()
+	var fault *Fault
+	if len(s.faults) > 0 {
+		fault = &s.faults[0]
+		s.faults = s.faults[1:]
+	}
+	s.mutex.Unlock()
+	if fault != nil {
+		if fault.Delay > 0 {
+			select {
+			case <-time.After(fault.Delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		if fault.Status != 0 {
+			writeError(recorder, fault.Status, fmt.Sprintf("injected fault: %d", fault.Status))
+			return
+		}
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/trusted/v1/event/") {
+		s.serveTrusted(recorder, r, body)
+	} else if strings.HasPrefix(r.URL.Path, "/api/v3/event/") {
+		s.serveRead(recorder, r)
+	} else {
+		writeError(recorder, http.StatusNotFound, "not found: "+r.URL.Path)
+	}
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// /api/trusted/v1/event/{event}/{path}
+func (s *Server) serveTrusted(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/trusted/v1/event/"), "/", 2)
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+		return
+	}
+	event, path := parts[0], parts[1]
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// same signature as tba.SignRequest
+	secret, ok := s.credentials[event][r.Header.Get("X-TBA-Auth-Id")]
+	if !ok {
+		writeError(w, http.StatusUnauthorized, fmt.Sprintf("Must provide a request header parameter 'X-TBA-Auth-Id' valid for %s", event))
+		return
+	}
+	expected_sig := fmt.Sprintf("%x", md5.Sum(append([]byte(secret+r.URL.Path), body...)))
+	if r.Header.Get("X-TBA-Auth-Sig") != expected_sig {
+		writeError(w, http.StatusUnauthorized, "Request signature verification failed")
+		return
+	}
+
+	data := s.event(event)
+	var payload tba.Payload
+	switch path {
+	case "matches/update":
+		payload = &tba.MatchList{}
+	case "matches/delete":
+		payload = &tba.MatchDeleteList{}
+	case "rankings/update":
+		payload = &tba.Rankings{}
+	case "alliance_selections/update":
+		payload = &tba.AllianceSelections{}
+	case "awards/update":
+		payload = &tba.AwardList{}
+	case "team_list/update":
+		payload = &tba.TeamList{}
+	case "info/update":
+		payload = &tba.EventInfo{}
+	case "match_videos/add":
+		payload = &tba.MatchVideos{}
+	case "media/add":
+		payload = &tba.MediaList{}
+	default:
+		writeError(w, http.StatusNotFound, "unknown trusted API path: "+path)
+		return
+	}
+	err := json.Unmarshal(body, payload)
+	if err == nil {
+		err = payload.Validate()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	switch p := payload.(type) {
+	case *tba.MatchList:
+		for _, match := range *p {
+			data.matches[match.PartialKey()] = match
+		}
+	case *tba.MatchDeleteList:
+		for _, key := range *p {
+			delete(data.matches, key)
+		}
+	case *tba.Rankings:
+		data.rankings = p
+	case *tba.AllianceSelections:
+		data.alliances = *p
+	case *tba.AwardList:
+		data.awards = *p
+	case *tba.TeamList:
+		data.teams = *p
+	}
+	writeJson(w, http.StatusOK, map[string]string{"Success": path + " successful"})
+}
+
+// /api/v3/event/{event}/{resource}
+func (s *Server) serveRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed: "+r.Method)
+		return
+	}
+	if s.ReadKey != "" && r.Header.Get("X-TBA-Auth-Key") != s.ReadKey {
+		writeError(w, http.StatusUnauthorized, "X-TBA-Auth-Key is invalid. Please get an access key at http://www.thebluealliance.com/account.")
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/api/v3/event/"), "/", 2)
+	if len(parts) != 2 {
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+		return
+	}
+	event, resource := parts[0], parts[1]
+
+	s.mutex.Lock()
+	data := s.event(event)
+	var out interface{}
+	switch resource {
+	case "matches":
+		out = readMatches(event, data)
+	case "rankings":
+		out = readRankings(data)
+	case "alliances":
+		out = readAlliances(data)
+	case "awards":
+		out = readAwards(event, data)
+	case "teams/keys":
+		out = append(tba.TeamList{}, data.teams...)
+	}
+	s.mutex.Unlock()
+	if out == nil && resource != "rankings" {
+		writeError(w, http.StatusNotFound, "unknown read API path: "+resource)
+		return
+	}
+
+	body, _ := json.Marshal(out)
+	etag := fmt.Sprintf("\"%x\"", md5.Sum(body))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func readMatchAlliance(alliance tba.MatchAlliance) tba.ReadMatchAlliance {
+	nonNil := func(teams []string) []string {
+		if teams == nil {
+			return []string{}
+		}
+		return teams
+	}
+	return tba.ReadMatchAlliance{
+		Score:             alliance.Score,
+		TeamKeys:          nonNil(alliance.Teams),
+		SurrogateTeamKeys: nonNil(alliance.Surrogates),
+		DqTeamKeys:        nonNil(alliance.Dqs),
+	}
+}
+
+func readMatches(event string, data *eventData) []tba.ReadMatch {
+	out := make([]tba.ReadMatch, 0, len(data.matches))
+	for key, match := range data.matches {
+		read_match := tba.ReadMatch{
+			Key:            event + "_" + key,
+			EventKey:       event,
+			CompLevel:      match.CompLevel,
+			SetNumber:      match.SetNumber,
+			MatchNumber:    match.MatchNumber,
+			ScoreBreakdown: match.ScoreBreakdown,
+			Videos:         []tba.ReadMatchVideo{},
+		}
+		read_match.Alliances.Red = readMatchAlliance(match.Alliances.Red)
+		read_match.Alliances.Blue = readMatchAlliance(match.Alliances.Blue)
+		if match.Alliances.Red.Score > match.Alliances.Blue.Score {
+			read_match.WinningAlliance = "red"
+		} else if match.Alliances.Blue.Score > match.Alliances.Red.Score {
+			read_match.WinningAlliance = "blue"
+		}
+		out = append(out, read_match)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// breakdown values may be uploaded as numbers or numeric strings
+func sortOrderValue(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func readRankings(data *eventData) *tba.ReadRankings {
+	if data.rankings == nil {
+		return nil
+	}
+	out := &tba.ReadRankings{
+		Rankings:      make([]tba.ReadRanking, len(data.rankings.Rankings)),
+		SortOrderInfo: make([]tba.ReadSortOrderInfo, len(data.rankings.Breakdowns)),
+	}
+	for i, name := range data.rankings.Breakdowns {
+		out.SortOrderInfo[i] = tba.ReadSortOrderInfo{Name: name, Precision: 2}
+	}
+	for i, ranking := range data.rankings.Rankings {
+		sort_orders := make([]float64, len(data.rankings.Breakdowns))
+		for j, name := range data.rankings.Breakdowns {
+			sort_orders[j] = sortOrderValue(ranking.Breakdowns[name])
+		}
+		out.Rankings[i] = tba.ReadRanking{
+			TeamKey:       ranking.TeamKey,
+			Rank:          ranking.Rank,
+			MatchesPlayed: ranking.Played,
+			Dq:            ranking.Dqs,
+			Record: &tba.ReadRankingRecord{
+				Wins:   ranking.Wins,
+				Losses: ranking.Losses,
+				Ties:   ranking.Ties,
+			},
+			SortOrders: sort_orders,
+			ExtraStats: []float64{},
+		}
+	}
+	return out
+}
+
+func readAlliances(data *eventData) []tba.ReadAlliance {
+	out := make([]tba.ReadAlliance, len(data.alliances))
+	for i, teams := range data.alliances {
+		out[i] = tba.ReadAlliance{
+			Name:     fmt.Sprintf("Alliance %d", i+1),
+			Picks:    append([]string{}, teams...),
+			Declines: []string{},
+		}
+	}
+	return out
+}
+
+func readAwards(event string, data *eventData) []tba.ReadAward {
+	out := make([]tba.ReadAward, 0)
+	by_name := make(map[string]int)
+	for _, award := range data.awards {
+		i, ok := by_name[award.NameStr]
+		if !ok {
+			i = len(out)
+			by_name[award.NameStr] = i
+			out = append(out, tba.ReadAward{
+				Name:          award.NameStr,
+				EventKey:      event,
+				RecipientList: []tba.ReadAwardRecipient{},
+			})
+		}
+		out[i].RecipientList = append(out[i].RecipientList, tba.ReadAwardRecipient{
+			TeamKey: award.TeamKey,
+			Awardee: award.Awardee,
+		})
+	}
+	return out
+}
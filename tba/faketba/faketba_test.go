@@ -0,0 +1,123 @@
+package faketba
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestMatch(number int, red_score int) tba.Match {
+	return tba.Match{
+		CompLevel:   "qm",
+		SetNumber:   1,
+		MatchNumber: number,
+		Alliances: tba.MatchAlliances{
+			Red:  tba.MatchAlliance{Teams: []string{"frc1", "frc2", "frc3"}, Score: red_score},
+			Blue: tba.MatchAlliance{Teams: []string{"frc4", "frc5", "frc6"}, Score: 20},
+		},
+	}
+}
+
+func startTestServer(t *testing.T) (*Server, *tba.Client) {
+	fake := NewServer()
+	fake.AddCredentials("2023test", "auth", "secret")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, tba.NewClient(server.URL, tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"})
+}
+
+func TestSignatures(t *testing.T) {
+	fake, client := startTestServer(t)
+	assert.NoError(t, client.UpdateMatches([]tba.Match{makeTestMatch(1, 10)}))
+
+	bad_client := tba.NewClient(client.TbaUrl, tba.EventParams{Event: "2023test", Auth: "auth", Secret: "wrong"})
+	err := bad_client.UpdateMatches([]tba.Match{makeTestMatch(2, 10)})
+	if assert.IsType(t, &tba.Error{}, err) {
+		assert.Equal(t, http.StatusUnauthorized, err.(*tba.Error).StatusCode)
+		assert.Equal(t, []string{"Request signature verification failed"}, err.(*tba.Error).Messages)
+	}
+
+	other_event := tba.NewClient(client.TbaUrl, tba.EventParams{Event: "2023other", Auth: "auth", Secret: "secret"})
+	assert.Error(t, other_event.UpdateMatches([]tba.Match{makeTestMatch(2, 10)}))
+
+	assert.Len(t, fake.Matches("2023test"), 1)
+	assert.Len(t, fake.Requests(), 3)
+}
+
+func TestStoreAndRead(t *testing.T) {
+	fake, client := startTestServer(t)
+	read_client := tba.NewReadClient(client.TbaUrl, "key")
+
+	assert.NoError(t, client.UpdateMatches([]tba.Match{makeTestMatch(1, 10), makeTestMatch(2, 30)}))
+	assert.NoError(t, client.DeleteMatches([]string{"qm1"}))
+	matches, err := read_client.EventMatches("2023test")
+	assert.NoError(t, err)
+	if assert.Len(t, matches, 1) {
+		assert.Equal(t, "2023test_qm2", matches[0].Key)
+		assert.Equal(t, "red", matches[0].WinningAlliance)
+		assert.Equal(t, []string{"frc4", "frc5", "frc6"}, matches[0].Alliances.Blue.TeamKeys)
+	}
+
+	rankings, err := read_client.EventRankings("2023test")
+	assert.NoError(t, err)
+	assert.Nil(t, rankings)
+	assert.NoError(t, client.UpdateRankings(tba.Rankings{
+		Breakdowns: []string{"Ranking Score"},
+		Rankings: []tba.Ranking{
+			{TeamKey: "frc1", Rank: 1, Played: 2, Wins: 2, Breakdowns: map[string]interface{}{"Ranking Score": "2.5"}},
+		},
+	}))
+	rankings, err = read_client.EventRankings("2023test")
+	assert.NoError(t, err)
+	if assert.NotNil(t, rankings) && assert.Len(t, rankings.Rankings, 1) {
+		assert.Equal(t, []float64{2.5}, rankings.Rankings[0].SortOrders)
+		assert.Equal(t, 2, rankings.Rankings[0].Record.Wins)
+	}
+
+	assert.NoError(t, client.UpdateAlliances([][]string{{"frc1", "frc2", "frc3"}}))
+	alliances, err := read_client.EventAlliances("2023test")
+	assert.NoError(t, err)
+	assert.Equal(t, fake.Alliances("2023test")[0], alliances[0].Picks)
+
+	// unchanged data is revalidated with ETags
+	_, err = read_client.EventAlliances("2023test")
+	assert.NoError(t, err)
+	requests := fake.Requests()
+	assert.Equal(t, http.StatusNotModified, requests[len(requests)-1].Status)
+}
+
+func TestReadKey(t *testing.T) {
+	fake, client := startTestServer(t)
+	fake.ReadKey = "key"
+	_, err := tba.NewReadClient(client.TbaUrl, "wrong").EventMatches("2023test")
+	assert.Error(t, err)
+	_, err = tba.NewReadClient(client.TbaUrl, "key").EventMatches("2023test")
+	assert.NoError(t, err)
+}
+
+func TestFaults(t *testing.T) {
+	fake, client := startTestServer(t)
+
+	fake.InjectFault(Fault{Status: http.StatusInternalServerError})
+	err := client.UpdateMatches([]tba.Match{makeTestMatch(1, 10)})
+	if assert.IsType(t, &tba.Error{}, err) {
+		assert.Equal(t, http.StatusInternalServerError, err.(*tba.Error).StatusCode)
+	}
+	assert.Len(t, fake.Matches("2023test"), 0)
+
+	fake.InjectFault(Fault{Status: http.StatusUnauthorized})
+	_, err = tba.NewReadClient(client.TbaUrl, "key").EventMatches("2023test")
+	assert.Error(t, err)
+
+	// faults only apply once
+	assert.NoError(t, client.UpdateMatches([]tba.Match{makeTestMatch(1, 10)}))
+
+	fake.InjectFault(Fault{Delay: time.Second})
+	http_client := http.Client{Timeout: 50 * time.Millisecond}
+	_, err = http_client.Get(client.TbaUrl + "/api/v3/event/2023test/matches")
+	assert.Error(t, err)
+}
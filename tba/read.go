@@ -0,0 +1,220 @@
+package tba
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Types and client for the TBA read API (v3). See
+// https://www.thebluealliance.com/apidocs/v3
+
+type ReadMatchAlliance struct {
+	Score             int      `json:"score"`
+	TeamKeys          []string `json:"team_keys"`
+	SurrogateTeamKeys []string `json:"surrogate_team_keys"`
+	DqTeamKeys        []string `json:"dq_team_keys"`
+}
+
+type ReadMatchVideo struct {
+	Type string `json:"type"`
+	Key  string `json:"key"`
+}
+
+type ReadMatch struct {
+	Key             string `json:"key"`
+	EventKey        string `json:"event_key"`
+	CompLevel       string `json:"comp_level"`
+	SetNumber       int    `json:"set_number"`
+	MatchNumber     int    `json:"match_number"`
+	WinningAlliance string `json:"winning_alliance"`
+	Alliances       struct {
+		Red  ReadMatchAlliance `json:"red"`
+		Blue ReadMatchAlliance `json:"blue"`
+	} `json:"alliances"`
+	ScoreBreakdown map[string]map[string]interface{} `json:"score_breakdown"`
+	Time           *int64                            `json:"time"`
+	ActualTime     *int64                            `json:"actual_time"`
+	PostResultTime *int64                            `json:"post_result_time"`
+	Videos         []ReadMatchVideo                  `json:"videos"`
+}
+
+// key relative to the event, e.g. "qm1" or "sf2m1"
+func (m ReadMatch) PartialKey() string {
+	return MatchCode{Level: m.CompLevel, Set: m.SetNumber, Match: m.MatchNumber}.PartialKey()
+}
+
+type ReadRankingRecord struct {
+	Wins   int `json:"wins"`
+	Losses int `json:"losses"`
+	Ties   int `json:"ties"`
+}
+
+type ReadRanking struct {
+	TeamKey       string             `json:"team_key"`
+	Rank          int                `json:"rank"`
+	MatchesPlayed int                `json:"matches_played"`
+	Dq            int                `json:"dq"`
+	Record        *ReadRankingRecord `json:"record"`
+	SortOrders    []float64          `json:"sort_orders"`
+	ExtraStats    []float64          `json:"extra_stats"`
+}
+
+type ReadSortOrderInfo struct {
+	Name      string `json:"name"`
+	Precision int    `json:"precision"`
+}
+
+type ReadRankings struct {
+	Rankings      []ReadRanking       `json:"rankings"`
+	SortOrderInfo []ReadSortOrderInfo `json:"sort_order_info"`
+}
+
+type ReadAllianceStatus struct {
+	Level              string             `json:"level"`
+	Status             string             `json:"status"`
+	Record             *ReadRankingRecord `json:"record"`
+	CurrentLevelRecord *ReadRankingRecord `json:"current_level_record"`
+	PlayoffAverage     *float64           `json:"playoff_average"`
+}
+
+type ReadAlliance struct {
+	Name     string              `json:"name"`
+	Picks    []string            `json:"picks"`
+	Declines []string            `json:"declines"`
+	Status   *ReadAllianceStatus `json:"status"`
+}
+
+type ReadAwardRecipient struct {
+	TeamKey *string `json:"team_key"`
+	Awardee *string `json:"awardee"`
+}
+
+type ReadAward struct {
+	Name          string               `json:"name"`
+	AwardType     int                  `json:"award_type"`
+	EventKey      string               `json:"event_key"`
+	Year          int                  `json:"year"`
+	RecipientList []ReadAwardRecipient `json:"recipient_list"`
+}
+
+type cachedResponse struct {
+	etag          string
+	last_modified string
+	body          []byte
+}
+
+// Client for the TBA read API. Responses are cached and revalidated with
+// ETag/If-Modified-Since, so repeated requests for unchanged data are cheap.
+type ReadClient struct {
+	TbaUrl string
+	Key    string
+
+	mutex  sync.Mutex
+	cache  map[string]*cachedResponse
+	client http.Client
+}
+
+func NewReadClient(tba_url string, key string) *ReadClient {
+	return &ReadClient{
+		TbaUrl: tba_url,
+		Key:    key,
+		cache:  make(map[string]*cachedResponse),
+		client: http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// fetch a path relative to /api/v3 and decode it into out
+func (c *ReadClient) Get(path string, out interface{}) error {
+	body, err := c.fetch(path)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(body, out)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	return nil
+}
+
+func (c *ReadClient) fetch(path string) ([]byte, error) {
+	url := c.TbaUrl + "/api/v3/" + path
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("X-TBA-Auth-Key", c.Key)
+
+	c.mutex.Lock()
+	cached := c.cache[url]
+	c.mutex.Unlock()
+	if cached != nil {
+		if cached.etag != "" {
+			request.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.last_modified != "" {
+			request.Header.Set("If-Modified-Since", cached.last_modified)
+		}
+	}
+
+	res, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("TBA request failed: %s", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("TBA request failed: %s", err)
+	}
+
+	if res.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.body, nil
+	} else if res.StatusCode != http.StatusOK {
+		return nil, ParseError(res.StatusCode, body)
+	}
+
+	if res.Header.Get("ETag") != "" || res.Header.Get("Last-Modified") != "" {
+		c.mutex.Lock()
+		c.cache[url] = &cachedResponse{
+			etag:          res.Header.Get("ETag"),
+			last_modified: res.Header.Get("Last-Modified"),
+			body:          body,
+		}
+		c.mutex.Unlock()
+	}
+	return body, nil
+}
+
+func (c *ReadClient) EventMatches(event string) ([]ReadMatch, error) {
+	matches := make([]ReadMatch, 0)
+	err := c.Get(fmt.Sprintf("event/%s/matches", event), &matches)
+	return matches, err
+}
+
+// returns nil if TBA has no rankings for the event
+func (c *ReadClient) EventRankings(event string) (*ReadRankings, error) {
+	var rankings *ReadRankings
+	err := c.Get(fmt.Sprintf("event/%s/rankings", event), &rankings)
+	return rankings, err
+}
+
+func (c *ReadClient) EventAlliances(event string) ([]ReadAlliance, error) {
+	alliances := make([]ReadAlliance, 0)
+	err := c.Get(fmt.Sprintf("event/%s/alliances", event), &alliances)
+	return alliances, err
+}
+
+func (c *ReadClient) EventAwards(event string) ([]ReadAward, error) {
+	awards := make([]ReadAward, 0)
+	err := c.Get(fmt.Sprintf("event/%s/awards", event), &awards)
+	return awards, err
+}
+
+func (c *ReadClient) EventTeamKeys(event string) ([]string, error) {
+	teams := make([]string, 0)
+	err := c.Get(fmt.Sprintf("event/%s/teams/keys", event), &teams)
+	return teams, err
+}
@@ -0,0 +1,50 @@
+package tba
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadClientCache(t *testing.T) {
+	requests := 0
+	not_modified := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("X-TBA-Auth-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"Error": "X-TBA-Auth-Key is invalid"}`))
+			return
+		}
+		assert.Equal(t, "/api/v3/event/2023test/matches", r.URL.Path)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			not_modified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`[{"key": "2023test_sf2m1", "comp_level": "sf", "set_number": 2, "match_number": 1,
+			"alliances": {"red": {"score": 10, "team_keys": ["frc1"]}, "blue": {"score": 5, "team_keys": ["frc2"]}}}]`))
+	}))
+	defer server.Close()
+
+	client := NewReadClient(server.URL, "key")
+	for i := 0; i < 2; i++ {
+		matches, err := client.EventMatches("2023test")
+		assert.NoError(t, err)
+		if assert.Len(t, matches, 1) {
+			assert.Equal(t, "sf2m1", matches[0].PartialKey())
+			assert.Equal(t, 10, matches[0].Alliances.Red.Score)
+		}
+	}
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, not_modified)
+
+	client = NewReadClient(server.URL, "wrong")
+	_, err := client.EventMatches("2023test")
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, []string{"X-TBA-Auth-Key is invalid"}, err.(*Error).Messages)
+	}
+}
@@ -15,45 +15,158 @@ type EventParams struct {
 }
 
 type MatchCode struct {
-	Level string `json:"comp_level"`
-	Set   int    `json:"set_number"`
-	Match int    `json:"match_number"`
+	Level string `json:"comp_level" yaml:"comp_level"`
+	Set   int    `json:"set_number" yaml:"set_number"`
+	Match int    `json:"match_number" yaml:"match_number"`
 }
 
-func GetPlayoffCode(bracket_type, match_id int) MatchCode {
-	return GetBracket(bracket_type)[match_id]
+// key relative to the event, e.g. "qm1" or "sf2m1"
+func (code MatchCode) PartialKey() string {
+	if code.Level == "qm" {
+		return fmt.Sprintf("qm%d", code.Match)
+	}
+	return fmt.Sprintf("%s%dm%d", code.Level, code.Set, code.Match)
 }
 
-func SendRequest(tba_url string, url string, body []byte, params *EventParams) (*http.Response, error) {
-	url = fmt.Sprintf("/api/trusted/v1/event/%s/%s", params.Event, url)
-	sig := fmt.Sprintf("%x", md5.Sum(append([]byte(params.Secret+url), body...)))
+func GetPlayoffCode(bracket_type, match_id int) (MatchCode, error) {
+	bracket := GetBracket(bracket_type)
+	if bracket_type == BRACKET_TYPE_CUSTOM {
+		return MatchCode{}, fmt.Errorf("custom brackets must be loaded from a bracket definition file")
+	} else if bracket == nil {
+		return MatchCode{}, fmt.Errorf("unsupported bracket type: %d", bracket_type)
+	}
+	return bracket.PlayoffCode(match_id)
+}
+
+// a trusted API request that has been signed and can be sent (or re-sent) without the event secret
+type SignedRequest struct {
+	TbaUrl string `json:"tba_url"`
+	Event  string `json:"event"`
+	Path   string `json:"path"`
+	Body   []byte `json:"body"`
+	AuthId string `json:"auth_id"`
+	Sig    string `json:"sig"`
+}
+
+func SignRequest(tba_url string, url string, body []byte, params *EventParams) SignedRequest {
+	full_path := fmt.Sprintf("/api/trusted/v1/event/%s/%s", params.Event, url)
+	return SignedRequest{
+		TbaUrl: tba_url,
+		Event:  params.Event,
+		Path:   url,
+		Body:   body,
+		AuthId: params.Auth,
+		Sig:    fmt.Sprintf("%x", md5.Sum(append([]byte(params.Secret+full_path), body...))),
+	}
+}
+
+func (req SignedRequest) Url() string {
+	return fmt.Sprintf("%s/api/trusted/v1/event/%s/%s", req.TbaUrl, req.Event, req.Path)
+}
+
+func (req SignedRequest) headers() map[string]string {
+	return map[string]string{
+		"X-TBA-Auth-Id":  req.AuthId,
+		"X-TBA-Auth-Sig": req.Sig,
+	}
+}
+
+// the exact HTTP request that Send would make
+type RequestPreview struct {
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+func (req SignedRequest) Preview() RequestPreview {
+	return RequestPreview{
+		Method:  "POST",
+		Url:     req.Url(),
+		Headers: req.headers(),
+		Body:    string(req.Body),
+	}
+}
 
-	url = tba_url + url
-	request, err := http.NewRequest("POST", url, bytes.NewReader(body))
+func (req SignedRequest) Send() (*http.Response, error) {
+	request, err := http.NewRequest("POST", req.Url(), bytes.NewReader(req.Body))
 	if err != nil {
 		return nil, err
 	}
-	request.Header.Add("X-TBA-Auth-Id", params.Auth)
-	request.Header.Add("X-TBA-Auth-Sig", sig)
+	for name, value := range req.headers() {
+		request.Header.Add(name, value)
+	}
 	client := http.Client{Timeout: 5 * time.Second}
 	return client.Do(request)
 }
 
+func SendRequest(tba_url string, url string, body []byte, params *EventParams) (*http.Response, error) {
+	return SignRequest(tba_url, url, body, params).Send()
+}
+
 type Bracket map[int]MatchCode
 
+func (bracket Bracket) PlayoffCode(match_id int) (MatchCode, error) {
+	code, ok := bracket[match_id]
+	if !ok {
+		return MatchCode{}, fmt.Errorf("playoff match %d is out of range for this bracket (%d matches)", match_id, len(bracket))
+	}
+	return code, nil
+}
+
 type playoffRoundInfo struct {
 	level           string
 	sets            int
 	matches_per_set int
+	// first set number in this round, if not 1
+	first_set int
 }
 
 var playoffRounds = map[int][]playoffRoundInfo{
 	// note: most finals rounds have 6 potential matches due to "up to 3" overtime matches
+	BRACKET_TYPE_BRACKET_16_TEAM: {
+		{level: "ef", sets: 8, matches_per_set: 3},
+		{level: "qf", sets: 4, matches_per_set: 3},
+		{level: "sf", sets: 2, matches_per_set: 3},
+		{level: "f", sets: 1, matches_per_set: 6},
+	},
 	BRACKET_TYPE_BRACKET_8_TEAM: {
 		{level: "qf", sets: 4, matches_per_set: 3},
 		{level: "sf", sets: 2, matches_per_set: 3},
 		{level: "f", sets: 1, matches_per_set: 6},
 	},
+	BRACKET_TYPE_BRACKET_4_TEAM: {
+		{level: "sf", sets: 2, matches_per_set: 3},
+		{level: "f", sets: 1, matches_per_set: 6},
+	},
+	BRACKET_TYPE_BRACKET_2_TEAM: {
+		{level: "f", sets: 1, matches_per_set: 6},
+	},
+	// each alliance plays 2 quarterfinal and 3 semifinal matches, ranked by average score
+	BRACKET_TYPE_AVG_SCORE_8_TEAM: {
+		{level: "qf", sets: 1, matches_per_set: 8},
+		{level: "sf", sets: 1, matches_per_set: 6},
+		{level: "f", sets: 1, matches_per_set: 6},
+	},
+	// best-of-3 series, following TBA's legacy double elimination layout:
+	// winners bracket in ef1-4, qf1-2, sf1; losers bracket in ef5-6, qf3-4, sf2, f1; grand finals in f2
+	BRACKET_TYPE_LEGACY_DOUBLE_ELIM_8_TEAM: {
+		{level: "ef", sets: 4, matches_per_set: 3},
+		{level: "ef", sets: 2, matches_per_set: 3, first_set: 5},
+		{level: "qf", sets: 2, matches_per_set: 3},
+		{level: "qf", sets: 2, matches_per_set: 3, first_set: 3},
+		{level: "sf", sets: 1, matches_per_set: 3},
+		{level: "sf", sets: 1, matches_per_set: 3, first_set: 2},
+		{level: "f", sets: 1, matches_per_set: 3},
+		{level: "f", sets: 1, matches_per_set: 6, first_set: 2},
+	},
+	BRACKET_TYPE_BO3_FINALS: {
+		{level: "f", sets: 1, matches_per_set: 6},
+	},
+	// up to 3 overtime matches after 5
+	BRACKET_TYPE_BO5_FINALS: {
+		{level: "f", sets: 1, matches_per_set: 8},
+	},
 	BRACKET_TYPE_ROUND_ROBIN_6_TEAM: {
 		{level: "sf", sets: 1, matches_per_set: 15},
 		{level: "f", sets: 1, matches_per_set: 6},
@@ -66,25 +179,32 @@ var playoffRounds = map[int][]playoffRoundInfo{
 		{level: "sf", sets: 5, matches_per_set: 1},
 		{level: "f", sets: 1, matches_per_set: 6},
 	},
-	BRACKET_TYPE_CUSTOM: {},
 }
 
 func generateBracket(bracket_type int) Bracket {
 	rounds, ok := playoffRounds[bracket_type]
 	if ok {
-		codes := make(Bracket)
-		i := 1
-		for _, round := range rounds {
-			for match := 1; match <= round.matches_per_set; match++ {
-				for set := 1; set <= round.sets; set++ {
-					codes[i] = MatchCode{Level: round.level, Set: set, Match: match}
-					i++
-				}
+		return bracketFromRounds(rounds)
+	}
+	return nil
+}
+
+func bracketFromRounds(rounds []playoffRoundInfo) Bracket {
+	codes := make(Bracket)
+	i := 1
+	for _, round := range rounds {
+		first_set := round.first_set
+		if first_set == 0 {
+			first_set = 1
+		}
+		for match := 1; match <= round.matches_per_set; match++ {
+			for set := first_set; set < first_set+round.sets; set++ {
+				codes[i] = MatchCode{Level: round.level, Set: set, Match: match}
+				i++
 			}
 		}
-		return codes
 	}
-	return nil
+	return codes
 }
 
 var cachedBrackets = make(map[int]Bracket)
@@ -1,6 +1,8 @@
 package tba
 
 import (
+	"crypto/md5"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -8,8 +10,12 @@ import (
 
 func testBracket(t *testing.T, bracket Bracket, bracket_type int, name string) {
 	for i, expected_code := range bracket {
-		code := GetPlayoffCode(bracket_type, i)
-		assert.Equalf(t, expected_code, code, "playoff %d of %s", i, name)
+		code, err := GetPlayoffCode(bracket_type, i)
+		if expected_code == (MatchCode{}) {
+			assert.Errorf(t, err, "playoff %d of %s should be out of range", i, name)
+		} else if assert.NoErrorf(t, err, "playoff %d of %s", i, name) {
+			assert.Equalf(t, expected_code, code, "playoff %d of %s", i, name)
+		}
 	}
 }
 
@@ -126,7 +132,7 @@ func TestPlayoffCodes4DoubleElim(t *testing.T) {
 }
 
 var playoff_codes_custom = Bracket{
-	// for now, these should return default-initialized structs
+	// custom brackets have no matches defined
 	1: {},
 	2: {},
 	3: {},
@@ -136,3 +142,297 @@ var playoff_codes_custom = Bracket{
 func TestPlayoffCodesCustom(t *testing.T) {
 	testBracket(t, playoff_codes_custom, BRACKET_TYPE_CUSTOM, BRACKET_NAME_CUSTOM)
 }
+
+var playoff_codes_16_bracket = Bracket{
+	1:  {Level: "ef", Set: 1, Match: 1},
+	2:  {Level: "ef", Set: 2, Match: 1},
+	3:  {Level: "ef", Set: 3, Match: 1},
+	4:  {Level: "ef", Set: 4, Match: 1},
+	5:  {Level: "ef", Set: 5, Match: 1},
+	6:  {Level: "ef", Set: 6, Match: 1},
+	7:  {Level: "ef", Set: 7, Match: 1},
+	8:  {Level: "ef", Set: 8, Match: 1},
+	9:  {Level: "ef", Set: 1, Match: 2},
+	10: {Level: "ef", Set: 2, Match: 2},
+	11: {Level: "ef", Set: 3, Match: 2},
+	12: {Level: "ef", Set: 4, Match: 2},
+	13: {Level: "ef", Set: 5, Match: 2},
+	14: {Level: "ef", Set: 6, Match: 2},
+	15: {Level: "ef", Set: 7, Match: 2},
+	16: {Level: "ef", Set: 8, Match: 2},
+	17: {Level: "ef", Set: 1, Match: 3},
+	18: {Level: "ef", Set: 2, Match: 3},
+	19: {Level: "ef", Set: 3, Match: 3},
+	20: {Level: "ef", Set: 4, Match: 3},
+	21: {Level: "ef", Set: 5, Match: 3},
+	22: {Level: "ef", Set: 6, Match: 3},
+	23: {Level: "ef", Set: 7, Match: 3},
+	24: {Level: "ef", Set: 8, Match: 3},
+
+	25: {Level: "qf", Set: 1, Match: 1},
+	26: {Level: "qf", Set: 2, Match: 1},
+	27: {Level: "qf", Set: 3, Match: 1},
+	28: {Level: "qf", Set: 4, Match: 1},
+	29: {Level: "qf", Set: 1, Match: 2},
+	30: {Level: "qf", Set: 2, Match: 2},
+	31: {Level: "qf", Set: 3, Match: 2},
+	32: {Level: "qf", Set: 4, Match: 2},
+	33: {Level: "qf", Set: 1, Match: 3},
+	34: {Level: "qf", Set: 2, Match: 3},
+	35: {Level: "qf", Set: 3, Match: 3},
+	36: {Level: "qf", Set: 4, Match: 3},
+
+	37: {Level: "sf", Set: 1, Match: 1},
+	38: {Level: "sf", Set: 2, Match: 1},
+	39: {Level: "sf", Set: 1, Match: 2},
+	40: {Level: "sf", Set: 2, Match: 2},
+	41: {Level: "sf", Set: 1, Match: 3},
+	42: {Level: "sf", Set: 2, Match: 3},
+
+	43: {Level: "f", Set: 1, Match: 1},
+	44: {Level: "f", Set: 1, Match: 2},
+	45: {Level: "f", Set: 1, Match: 3},
+	46: {Level: "f", Set: 1, Match: 4},
+	47: {Level: "f", Set: 1, Match: 5},
+	48: {Level: "f", Set: 1, Match: 6},
+	49: {},
+}
+
+func TestPlayoffCodes16Bracket(t *testing.T) {
+	testBracket(t, playoff_codes_16_bracket, BRACKET_TYPE_BRACKET_16_TEAM, BRACKET_NAME_BRACKET_16_TEAM)
+}
+
+var playoff_codes_4_bracket = Bracket{
+	1: {Level: "sf", Set: 1, Match: 1},
+	2: {Level: "sf", Set: 2, Match: 1},
+	3: {Level: "sf", Set: 1, Match: 2},
+	4: {Level: "sf", Set: 2, Match: 2},
+	5: {Level: "sf", Set: 1, Match: 3},
+	6: {Level: "sf", Set: 2, Match: 3},
+
+	7:  {Level: "f", Set: 1, Match: 1},
+	8:  {Level: "f", Set: 1, Match: 2},
+	9:  {Level: "f", Set: 1, Match: 3},
+	10: {Level: "f", Set: 1, Match: 4},
+	11: {Level: "f", Set: 1, Match: 5},
+	12: {Level: "f", Set: 1, Match: 6},
+	13: {},
+}
+
+func TestPlayoffCodes4Bracket(t *testing.T) {
+	testBracket(t, playoff_codes_4_bracket, BRACKET_TYPE_BRACKET_4_TEAM, BRACKET_NAME_BRACKET_4_TEAM)
+}
+
+var playoff_codes_2_bracket = Bracket{
+	1: {Level: "f", Set: 1, Match: 1},
+	2: {Level: "f", Set: 1, Match: 2},
+	3: {Level: "f", Set: 1, Match: 3},
+	4: {Level: "f", Set: 1, Match: 4},
+	5: {Level: "f", Set: 1, Match: 5},
+	6: {Level: "f", Set: 1, Match: 6},
+	7: {},
+}
+
+func TestPlayoffCodes2Bracket(t *testing.T) {
+	testBracket(t, playoff_codes_2_bracket, BRACKET_TYPE_BRACKET_2_TEAM, BRACKET_NAME_BRACKET_2_TEAM)
+}
+
+var playoff_codes_8_avg_score = Bracket{
+	1: {Level: "qf", Set: 1, Match: 1},
+	2: {Level: "qf", Set: 1, Match: 2},
+	3: {Level: "qf", Set: 1, Match: 3},
+	4: {Level: "qf", Set: 1, Match: 4},
+	5: {Level: "qf", Set: 1, Match: 5},
+	6: {Level: "qf", Set: 1, Match: 6},
+	7: {Level: "qf", Set: 1, Match: 7},
+	8: {Level: "qf", Set: 1, Match: 8},
+
+	9:  {Level: "sf", Set: 1, Match: 1},
+	10: {Level: "sf", Set: 1, Match: 2},
+	11: {Level: "sf", Set: 1, Match: 3},
+	12: {Level: "sf", Set: 1, Match: 4},
+	13: {Level: "sf", Set: 1, Match: 5},
+	14: {Level: "sf", Set: 1, Match: 6},
+
+	15: {Level: "f", Set: 1, Match: 1},
+	16: {Level: "f", Set: 1, Match: 2},
+	17: {Level: "f", Set: 1, Match: 3},
+	18: {Level: "f", Set: 1, Match: 4},
+	19: {Level: "f", Set: 1, Match: 5},
+	20: {Level: "f", Set: 1, Match: 6},
+	21: {},
+}
+
+func TestPlayoffCodes8AvgScore(t *testing.T) {
+	testBracket(t, playoff_codes_8_avg_score, BRACKET_TYPE_AVG_SCORE_8_TEAM, BRACKET_NAME_AVG_SCORE_8_TEAM)
+}
+
+var playoff_codes_8_legacy_double_elim = Bracket{
+	1:  {Level: "ef", Set: 1, Match: 1},
+	2:  {Level: "ef", Set: 2, Match: 1},
+	3:  {Level: "ef", Set: 3, Match: 1},
+	4:  {Level: "ef", Set: 4, Match: 1},
+	5:  {Level: "ef", Set: 1, Match: 2},
+	6:  {Level: "ef", Set: 2, Match: 2},
+	7:  {Level: "ef", Set: 3, Match: 2},
+	8:  {Level: "ef", Set: 4, Match: 2},
+	9:  {Level: "ef", Set: 1, Match: 3},
+	10: {Level: "ef", Set: 2, Match: 3},
+	11: {Level: "ef", Set: 3, Match: 3},
+	12: {Level: "ef", Set: 4, Match: 3},
+
+	13: {Level: "ef", Set: 5, Match: 1},
+	14: {Level: "ef", Set: 6, Match: 1},
+	15: {Level: "ef", Set: 5, Match: 2},
+	16: {Level: "ef", Set: 6, Match: 2},
+	17: {Level: "ef", Set: 5, Match: 3},
+	18: {Level: "ef", Set: 6, Match: 3},
+
+	19: {Level: "qf", Set: 1, Match: 1},
+	20: {Level: "qf", Set: 2, Match: 1},
+	21: {Level: "qf", Set: 1, Match: 2},
+	22: {Level: "qf", Set: 2, Match: 2},
+	23: {Level: "qf", Set: 1, Match: 3},
+	24: {Level: "qf", Set: 2, Match: 3},
+
+	25: {Level: "qf", Set: 3, Match: 1},
+	26: {Level: "qf", Set: 4, Match: 1},
+	27: {Level: "qf", Set: 3, Match: 2},
+	28: {Level: "qf", Set: 4, Match: 2},
+	29: {Level: "qf", Set: 3, Match: 3},
+	30: {Level: "qf", Set: 4, Match: 3},
+
+	31: {Level: "sf", Set: 1, Match: 1},
+	32: {Level: "sf", Set: 1, Match: 2},
+	33: {Level: "sf", Set: 1, Match: 3},
+
+	34: {Level: "sf", Set: 2, Match: 1},
+	35: {Level: "sf", Set: 2, Match: 2},
+	36: {Level: "sf", Set: 2, Match: 3},
+
+	37: {Level: "f", Set: 1, Match: 1},
+	38: {Level: "f", Set: 1, Match: 2},
+	39: {Level: "f", Set: 1, Match: 3},
+
+	40: {Level: "f", Set: 2, Match: 1},
+	41: {Level: "f", Set: 2, Match: 2},
+	42: {Level: "f", Set: 2, Match: 3},
+	43: {Level: "f", Set: 2, Match: 4},
+	44: {Level: "f", Set: 2, Match: 5},
+	45: {Level: "f", Set: 2, Match: 6},
+	46: {},
+}
+
+func TestPlayoffCodes8LegacyDoubleElim(t *testing.T) {
+	testBracket(t, playoff_codes_8_legacy_double_elim, BRACKET_TYPE_LEGACY_DOUBLE_ELIM_8_TEAM, BRACKET_NAME_LEGACY_DOUBLE_ELIM_8_TEAM)
+}
+
+var playoff_codes_bo3_finals = Bracket{
+	1: {Level: "f", Set: 1, Match: 1},
+	2: {Level: "f", Set: 1, Match: 2},
+	3: {Level: "f", Set: 1, Match: 3},
+	4: {Level: "f", Set: 1, Match: 4},
+	5: {Level: "f", Set: 1, Match: 5},
+	6: {Level: "f", Set: 1, Match: 6},
+	7: {},
+}
+
+func TestPlayoffCodesBo3Finals(t *testing.T) {
+	testBracket(t, playoff_codes_bo3_finals, BRACKET_TYPE_BO3_FINALS, BRACKET_NAME_BO3_FINALS)
+}
+
+var playoff_codes_bo5_finals = Bracket{
+	1: {Level: "f", Set: 1, Match: 1},
+	2: {Level: "f", Set: 1, Match: 2},
+	3: {Level: "f", Set: 1, Match: 3},
+	4: {Level: "f", Set: 1, Match: 4},
+	5: {Level: "f", Set: 1, Match: 5},
+	6: {Level: "f", Set: 1, Match: 6},
+	7: {Level: "f", Set: 1, Match: 7},
+	8: {Level: "f", Set: 1, Match: 8},
+	9: {},
+}
+
+func TestPlayoffCodesBo5Finals(t *testing.T) {
+	testBracket(t, playoff_codes_bo5_finals, BRACKET_TYPE_BO5_FINALS, BRACKET_NAME_BO5_FINALS)
+}
+
+func TestPlayoffCodeUnknownBracket(t *testing.T) {
+	_, err := GetPlayoffCode(1000, 1)
+	assert.Error(t, err)
+	assert.Nil(t, GetBracket(1000))
+}
+
+func TestAllBracketTypesDefined(t *testing.T) {
+	for _, bracket_type := range []int{
+		BRACKET_TYPE_BRACKET_8_TEAM,
+		BRACKET_TYPE_BRACKET_16_TEAM,
+		BRACKET_TYPE_BRACKET_4_TEAM,
+		BRACKET_TYPE_AVG_SCORE_8_TEAM,
+		BRACKET_TYPE_ROUND_ROBIN_6_TEAM,
+		BRACKET_TYPE_LEGACY_DOUBLE_ELIM_8_TEAM,
+		BRACKET_TYPE_BO5_FINALS,
+		BRACKET_TYPE_BO3_FINALS,
+		BRACKET_TYPE_BRACKET_2_TEAM,
+		BRACKET_TYPE_DOUBLE_ELIM_8_TEAM,
+		BRACKET_TYPE_DOUBLE_ELIM_4_TEAM,
+	} {
+		_, err := GetPlayoffCode(bracket_type, 1)
+		assert.NoError(t, err, "bracket type %d", bracket_type)
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	params := &EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	req := SignRequest("http://localhost", "matches/update", []byte("[]"), params)
+	expected_sig := fmt.Sprintf("%x", md5.Sum([]byte("secret/api/trusted/v1/event/2023test/matches/update[]")))
+	assert.Equal(t, expected_sig, req.Sig)
+	assert.Equal(t, "auth", req.AuthId)
+	assert.Equal(t, "http://localhost/api/trusted/v1/event/2023test/matches/update", req.Url())
+}
+
+// partial keys of a bracket's matches, in FMS match order
+func bracketKeys(bracket Bracket) []string {
+	keys := make([]string, len(bracket))
+	for i := range keys {
+		keys[i] = bracket[i+1].PartialKey()
+	}
+	return keys
+}
+
+func TestCustomBracket(t *testing.T) {
+	bracket, err := ParseCustomBracket([]byte(`{
+		"rounds": [
+			{"comp_level": "sf", "sets": 2, "matches_per_set": 2},
+			{"comp_level": "f", "sets": 1, "matches_per_set": 3}
+		],
+		"matches": {"8": {"comp_level": "f", "set_number": 2, "match_number": 1}}
+	}`), false)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"sf1m1", "sf2m1", "sf1m2", "sf2m2", "f1m1", "f1m2", "f1m3", "f2m1"}, bracketKeys(bracket))
+	}
+
+	bracket, err = ParseCustomBracket([]byte(`
+matches:
+  1: {comp_level: f, set_number: 1, match_number: 1}
+  2: {comp_level: f, set_number: 1, match_number: 2}
+`), true)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"f1m1", "f1m2"}, bracketKeys(bracket))
+	}
+
+	for _, invalid := range []string{
+		`{}`,
+		`{"rounds": [{"comp_level": "qm", "sets": 1, "matches_per_set": 1}]}`,
+		`{"rounds": [{"comp_level": "f", "sets": 0, "matches_per_set": 1}]}`,
+		`{"matches": {"0": {"comp_level": "f", "set_number": 1, "match_number": 1}}}`,
+		`{"rounds": [{"comp_level": "f", "sets": 1, "matches_per_set": 2}],
+		  "matches": {"3": {"comp_level": "f", "set_number": 1, "match_number": 1}}}`,
+	} {
+		_, err = ParseCustomBracket([]byte(invalid), false)
+		assert.Error(t, err, invalid)
+	}
+
+	_, err = GetPlayoffCode(BRACKET_TYPE_CUSTOM, 1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,511 @@
+package tba
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Typed payloads for the TBA trusted API (v1). See
+// https://www.thebluealliance.com/apidocs/trusted/v1
+
+var teamKeyPattern = regexp.MustCompile(`^frc\d+[A-Z]?$`)
+var partialMatchKeyPattern = regexp.MustCompile(`^(qm\d+|(ef|qf|sf|f)\d+m\d+)$`)
+var youtubeIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+
+var compLevels = map[string]bool{
+	"qm": true,
+	"ef": true,
+	"qf": true,
+	"sf": true,
+	"f":  true,
+}
+
+func validateTeamKey(team_key string) error {
+	if !teamKeyPattern.MatchString(team_key) {
+		return fmt.Errorf("invalid team key: %q", team_key)
+	}
+	return nil
+}
+
+// A Payload is the body of a request to one trusted API endpoint
+type Payload interface {
+	// endpoint, relative to /api/trusted/v1/event/{event_key}/
+	Path() string
+	Validate() error
+}
+
+type MatchAlliance struct {
+	Teams      []string `json:"teams"`
+	Score      int      `json:"score"`
+	Surrogates []string `json:"surrogates,omitempty"`
+	Dqs        []string `json:"dqs,omitempty"`
+}
+
+type MatchAlliances struct {
+	Red  MatchAlliance `json:"red"`
+	Blue MatchAlliance `json:"blue"`
+}
+
+type Match struct {
+	CompLevel      string                            `json:"comp_level"`
+	SetNumber      int                               `json:"set_number"`
+	MatchNumber    int                               `json:"match_number"`
+	Alliances      MatchAlliances                    `json:"alliances"`
+	ScoreBreakdown map[string]map[string]interface{} `json:"score_breakdown,omitempty"`
+	TimeString     string                            `json:"time_string,omitempty"`
+	TimeUtc        string                            `json:"time_utc,omitempty"`
+	DisplayName    string                            `json:"display_name,omitempty"`
+}
+
+// key relative to the event, e.g. "qm1" or "sf2m1"
+func (m Match) PartialKey() string {
+	return MatchCode{Level: m.CompLevel, Set: m.SetNumber, Match: m.MatchNumber}.PartialKey()
+}
+
+func (a MatchAlliance) validate(name string) error {
+	if len(a.Teams) == 0 {
+		return fmt.Errorf("%s alliance has no teams", name)
+	}
+	for _, team_list := range [][]string{a.Teams, a.Surrogates, a.Dqs} {
+		for _, team := range team_list {
+			if err := validateTeamKey(team); err != nil {
+				return fmt.Errorf("%s alliance: %s", name, err)
+			}
+		}
+	}
+	if a.Score < -1 {
+		return fmt.Errorf("%s alliance: invalid score: %d", name, a.Score)
+	}
+	return nil
+}
+
+func (m Match) Validate() error {
+	if !compLevels[m.CompLevel] {
+		return fmt.Errorf("invalid comp_level: %q", m.CompLevel)
+	}
+	if m.SetNumber < 1 || m.MatchNumber < 1 {
+		return fmt.Errorf("invalid set/match number: %d/%d", m.SetNumber, m.MatchNumber)
+	}
+	if err := m.Alliances.Red.validate("red"); err != nil {
+		return err
+	}
+	if err := m.Alliances.Blue.validate("blue"); err != nil {
+		return err
+	}
+	return nil
+}
+
+type MatchList []Match
+
+func (MatchList) Path() string {
+	return "matches/update"
+}
+
+func (matches MatchList) Validate() error {
+	if len(matches) == 0 {
+		return fmt.Errorf("no matches")
+	}
+	for _, match := range matches {
+		if err := match.Validate(); err != nil {
+			return fmt.Errorf("%s: %s", match.PartialKey(), err)
+		}
+	}
+	return nil
+}
+
+// partial match keys, e.g. "qm1"
+type MatchDeleteList []string
+
+func (MatchDeleteList) Path() string {
+	return "matches/delete"
+}
+
+func (keys MatchDeleteList) Validate() error {
+	if len(keys) == 0 {
+		return fmt.Errorf("no matches")
+	}
+	for _, key := range keys {
+		if !partialMatchKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid match key: %q", key)
+		}
+	}
+	return nil
+}
+
+type Ranking struct {
+	TeamKey string
+	Rank    int
+	Played  int
+	Dqs     int
+	Wins    int
+	Losses  int
+	Ties    int
+	// values for each sort order, keyed by the names in Rankings.Breakdowns
+	Breakdowns map[string]interface{}
+}
+
+var rankingFields = []string{"team_key", "rank", "played", "dqs", "wins", "losses", "ties"}
+
+// TBA expects breakdown values alongside the other ranking fields
+func (r Ranking) MarshalJSON() ([]byte, error) {
+	out := map[string]interface{}{
+		"team_key": r.TeamKey,
+		"rank":     r.Rank,
+		"played":   r.Played,
+		"dqs":      r.Dqs,
+		"wins":     r.Wins,
+		"losses":   r.Losses,
+		"ties":     r.Ties,
+	}
+	for k, v := range r.Breakdowns {
+		out[k] = v
+	}
+	return json.Marshal(out)
+}
+
+// numeric ranking fields may be sent as numbers or numeric strings
+func rankingInt(all map[string]interface{}, field string) (int, error) {
+	switch value := all[field].(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return int(value), nil
+	case string:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %q", field, value)
+		}
+		return int(n), nil
+	default:
+		return 0, fmt.Errorf("invalid %s: %v", field, value)
+	}
+}
+
+func (r *Ranking) UnmarshalJSON(data []byte) error {
+	all := make(map[string]interface{})
+	err := json.Unmarshal(data, &all)
+	if err != nil {
+		return err
+	}
+	team_key, _ := all["team_key"].(string)
+	ints := make([]int, len(rankingFields)-1)
+	for i, field := range rankingFields[1:] {
+		ints[i], err = rankingInt(all, field)
+		if err != nil {
+			return fmt.Errorf("%s: %s", team_key, err)
+		}
+	}
+	for _, field := range rankingFields {
+		delete(all, field)
+	}
+	*r = Ranking{
+		TeamKey:    team_key,
+		Rank:       ints[0],
+		Played:     ints[1],
+		Dqs:        ints[2],
+		Wins:       ints[3],
+		Losses:     ints[4],
+		Ties:       ints[5],
+		Breakdowns: all,
+	}
+	return nil
+}
+
+type Rankings struct {
+	Breakdowns []string  `json:"breakdowns"`
+	Rankings   []Ranking `json:"rankings"`
+}
+
+func (Rankings) Path() string {
+	return "rankings/update"
+}
+
+func (r Rankings) Validate() error {
+	if len(r.Rankings) == 0 {
+		return fmt.Errorf("no rankings")
+	}
+	breakdowns := make(map[string]bool)
+	for _, name := range r.Breakdowns {
+		breakdowns[name] = true
+	}
+	for _, ranking := range r.Rankings {
+		if err := validateTeamKey(ranking.TeamKey); err != nil {
+			return err
+		}
+		if ranking.Rank < 1 {
+			return fmt.Errorf("%s: invalid rank: %d", ranking.TeamKey, ranking.Rank)
+		}
+		for name := range ranking.Breakdowns {
+			if !breakdowns[name] {
+				return fmt.Errorf("%s: unknown breakdown: %q", ranking.TeamKey, name)
+			}
+		}
+	}
+	return nil
+}
+
+// team keys of each alliance, in order of alliance number
+type AllianceSelections [][]string
+
+func (AllianceSelections) Path() string {
+	return "alliance_selections/update"
+}
+
+func (alliances AllianceSelections) Validate() error {
+	for i, alliance := range alliances {
+		if len(alliance) == 0 {
+			return fmt.Errorf("alliance %d has no teams", i+1)
+		}
+		for _, team := range alliance {
+			if err := validateTeamKey(team); err != nil {
+				return fmt.Errorf("alliance %d: %s", i+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+type Award struct {
+	NameStr string  `json:"name_str"`
+	TeamKey *string `json:"team_key"`
+	Awardee *string `json:"awardee"`
+}
+
+type AwardList []Award
+
+func (AwardList) Path() string {
+	return "awards/update"
+}
+
+func (awards AwardList) Validate() error {
+	for _, award := range awards {
+		if strings.TrimSpace(award.NameStr) == "" {
+			return fmt.Errorf("award has an empty name")
+		}
+		if award.TeamKey != nil {
+			if err := validateTeamKey(*award.TeamKey); err != nil {
+				return fmt.Errorf("%s: %s", award.NameStr, err)
+			}
+		}
+	}
+	return nil
+}
+
+type TeamList []string
+
+func (TeamList) Path() string {
+	return "team_list/update"
+}
+
+func (teams TeamList) Validate() error {
+	for _, team := range teams {
+		if err := validateTeamKey(team); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Webcast struct {
+	Url     string `json:"url,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Channel string `json:"channel,omitempty"`
+	File    string `json:"file,omitempty"`
+}
+
+// only fields that are set are changed on TBA
+type EventInfo struct {
+	FirstCode   *string            `json:"first_code,omitempty"`
+	PlayoffType *int               `json:"playoff_type,omitempty"`
+	Webcasts    *[]Webcast         `json:"webcasts,omitempty"`
+	RemapTeams  *map[string]string `json:"remap_teams,omitempty"`
+	Timezone    *string            `json:"timezone,omitempty"`
+}
+
+func (EventInfo) Path() string {
+	return "info/update"
+}
+
+func (info EventInfo) Validate() error {
+	if info.Webcasts != nil {
+		for _, webcast := range *info.Webcasts {
+			if webcast.Url == "" && (webcast.Type == "" || webcast.Channel == "") {
+				return fmt.Errorf("webcast needs a url or a type and channel")
+			}
+		}
+	}
+	if info.RemapTeams == nil {
+		return nil
+	}
+	for from, to := range *info.RemapTeams {
+		if err := validateTeamKey(from); err != nil {
+			return fmt.Errorf("remap_teams: %s", err)
+		}
+		if err := validateTeamKey(to); err != nil {
+			return fmt.Errorf("remap_teams: %s", err)
+		}
+	}
+	return nil
+}
+
+// partial match key -> YouTube video ID
+type MatchVideos map[string]string
+
+func (MatchVideos) Path() string {
+	return "match_videos/add"
+}
+
+func (videos MatchVideos) Validate() error {
+	for key, video := range videos {
+		if !partialMatchKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid match key: %q", key)
+		}
+		if !youtubeIdPattern.MatchString(video) {
+			return fmt.Errorf("%s: invalid YouTube video ID: %q", key, video)
+		}
+	}
+	return nil
+}
+
+// YouTube video IDs of event-wide media
+type MediaList []string
+
+func (MediaList) Path() string {
+	return "media/add"
+}
+
+func (media MediaList) Validate() error {
+	for _, video := range media {
+		if !youtubeIdPattern.MatchString(video) {
+			return fmt.Errorf("invalid YouTube video ID: %q", video)
+		}
+	}
+	return nil
+}
+
+// An error response from TBA
+type Error struct {
+	StatusCode int
+	Body       []byte
+	// messages parsed from the TBA "Error"/"Errors" response fields, if any
+	Messages []string
+}
+
+func (e *Error) Error() string {
+	if len(e.Messages) > 0 {
+		return fmt.Sprintf("TBA error %d: %s", e.StatusCode, strings.Join(e.Messages, "; "))
+	}
+	return fmt.Sprintf("TBA error %d: %s", e.StatusCode, e.Body)
+}
+
+// TBA reports errors as {"Error": "message"} or {"Errors": [{"field": "message"}, ...]}
+func ParseError(status_code int, body []byte) *Error {
+	out := &Error{
+		StatusCode: status_code,
+		Body:       body,
+		Messages:   make([]string, 0),
+	}
+	var parsed struct {
+		Error  string              `json:"Error"`
+		Errors []map[string]string `json:"Errors"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		if parsed.Error != "" {
+			out.Messages = append(out.Messages, parsed.Error)
+		}
+		for _, err := range parsed.Errors {
+			for field, message := range err {
+				out.Messages = append(out.Messages, fmt.Sprintf("%s: %s", field, message))
+			}
+		}
+	}
+	return out
+}
+
+// Client for the trusted API of a single event
+type Client struct {
+	TbaUrl string
+	Params EventParams
+}
+
+func NewClient(tba_url string, params EventParams) *Client {
+	return &Client{
+		TbaUrl: tba_url,
+		Params: params,
+	}
+}
+
+// validate and sign a payload without sending it
+func (c *Client) NewRequest(payload Payload) (SignedRequest, error) {
+	err := payload.Validate()
+	if err != nil {
+		return SignedRequest{}, fmt.Errorf("%s: %s", payload.Path(), err)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return SignedRequest{}, fmt.Errorf("%s: %s", payload.Path(), err)
+	}
+	return SignRequest(c.TbaUrl, payload.Path(), body, &c.Params), nil
+}
+
+// check the result of sending a request, returning an *Error if TBA rejected it
+func CheckResponse(res *http.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("TBA request failed: %s", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return ParseError(res.StatusCode, body)
+	}
+	return nil
+}
+
+func (c *Client) Send(payload Payload) error {
+	req, err := c.NewRequest(payload)
+	if err != nil {
+		return err
+	}
+	return CheckResponse(req.Send())
+}
+
+func (c *Client) UpdateMatches(matches []Match) error {
+	return c.Send(MatchList(matches))
+}
+
+func (c *Client) DeleteMatches(keys []string) error {
+	return c.Send(MatchDeleteList(keys))
+}
+
+func (c *Client) UpdateRankings(rankings Rankings) error {
+	return c.Send(rankings)
+}
+
+func (c *Client) UpdateAlliances(alliances [][]string) error {
+	return c.Send(AllianceSelections(alliances))
+}
+
+func (c *Client) UpdateAwards(awards []Award) error {
+	return c.Send(AwardList(awards))
+}
+
+func (c *Client) UpdateTeamList(teams []string) error {
+	return c.Send(TeamList(teams))
+}
+
+func (c *Client) UpdateEventInfo(info EventInfo) error {
+	return c.Send(info)
+}
+
+func (c *Client) AddMatchVideos(videos map[string]string) error {
+	return c.Send(MatchVideos(videos))
+}
+
+func (c *Client) AddMedia(media []string) error {
+	return c.Send(MediaList(media))
+}
@@ -0,0 +1,123 @@
+package tba
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func makeTestMatch() Match {
+	return Match{
+		CompLevel:   "qm",
+		SetNumber:   1,
+		MatchNumber: 5,
+		Alliances: MatchAlliances{
+			Red:  MatchAlliance{Teams: []string{"frc1", "frc2", "frc3"}, Score: 10},
+			Blue: MatchAlliance{Teams: []string{"frc4", "frc5", "frc254B"}, Score: 20},
+		},
+	}
+}
+
+func TestMatchValidate(t *testing.T) {
+	match := makeTestMatch()
+	assert.NoError(t, MatchList{match}.Validate())
+	assert.Equal(t, "qm5", match.PartialKey())
+
+	match.CompLevel = "xx"
+	assert.Error(t, MatchList{match}.Validate())
+
+	match = makeTestMatch()
+	match.Alliances.Red.Teams[0] = ""
+	assert.Error(t, MatchList{match}.Validate())
+
+	assert.Error(t, MatchList{}.Validate())
+	assert.NoError(t, MatchDeleteList{"qm1", "sf2m1"}.Validate())
+	assert.Error(t, MatchDeleteList{"2023test_qm1"}.Validate())
+}
+
+func TestRankingJSON(t *testing.T) {
+	raw := []byte(`{"breakdowns": ["Ranking Score"], "rankings": [
+		{"team_key": "frc254", "rank": 1, "played": "10", "dqs": 0, "wins": 9, "losses": 1, "ties": 0, "Ranking Score": 3.5}
+	]}`)
+	var rankings Rankings
+	assert.NoError(t, json.Unmarshal(raw, &rankings))
+	assert.NoError(t, rankings.Validate())
+	assert.Equal(t, 10, rankings.Rankings[0].Played)
+	assert.Equal(t, map[string]interface{}{"Ranking Score": 3.5}, rankings.Rankings[0].Breakdowns)
+
+	out, err := json.Marshal(rankings.Rankings[0])
+	assert.NoError(t, err)
+	var flat map[string]interface{}
+	assert.NoError(t, json.Unmarshal(out, &flat))
+	assert.Equal(t, 3.5, flat["Ranking Score"])
+	assert.Equal(t, "frc254", flat["team_key"])
+
+	rankings.Rankings[0].Breakdowns["Unknown"] = 1
+	assert.Error(t, rankings.Validate())
+}
+
+func TestParseError(t *testing.T) {
+	err := ParseError(400, []byte(`{"Errors": [{"match": "invalid"}]}`))
+	assert.Equal(t, []string{"match: invalid"}, err.Messages)
+	assert.Equal(t, "TBA error 400: match: invalid", err.Error())
+
+	err = ParseError(401, []byte(`{"Error": "bad auth"}`))
+	assert.Equal(t, []string{"bad auth"}, err.Messages)
+
+	err = ParseError(500, []byte(`oops`))
+	assert.Equal(t, "TBA error 500: oops", err.Error())
+}
+
+func TestClientSend(t *testing.T) {
+	var received_path string
+	var received_body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received_path = r.URL.Path
+		received_body, _ = ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-TBA-Auth-Id") != "auth" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"Error": "bad auth"}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, EventParams{Event: "2023test", Auth: "auth", Secret: "secret"})
+	assert.NoError(t, client.UpdateMatches([]Match{makeTestMatch()}))
+	assert.Equal(t, "/api/trusted/v1/event/2023test/matches/update", received_path)
+	var matches []Match
+	assert.NoError(t, json.Unmarshal(received_body, &matches))
+	assert.Equal(t, []Match{makeTestMatch()}, matches)
+
+	// invalid payloads are not sent
+	received_path = ""
+	assert.Error(t, client.UpdateAlliances([][]string{{"254"}}))
+	assert.Equal(t, "", received_path)
+
+	client.Params.Auth = "wrong"
+	err := client.UpdateTeamList([]string{"frc254"})
+	if assert.IsType(t, &Error{}, err) {
+		assert.Equal(t, http.StatusUnauthorized, err.(*Error).StatusCode)
+		assert.Equal(t, []string{"bad auth"}, err.(*Error).Messages)
+	}
+}
+
+func TestRequestPreview(t *testing.T) {
+	params := EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	client := NewClient("http://tba.test", params)
+	_, err := client.NewRequest(TeamList{"254"})
+	assert.Error(t, err)
+
+	req, err := client.NewRequest(TeamList{"frc254"})
+	assert.NoError(t, err)
+	expected := SignRequest("http://tba.test", "team_list/update", []byte(`["frc254"]`), &params)
+	preview := req.Preview()
+	assert.Equal(t, "POST", preview.Method)
+	assert.Equal(t, "http://tba.test/api/trusted/v1/event/2023test/team_list/update", preview.Url)
+	assert.Equal(t, `["frc254"]`, preview.Body)
+	assert.Equal(t, "auth", preview.Headers["X-TBA-Auth-Id"])
+	assert.Equal(t, expected.Sig, preview.Headers["X-TBA-Auth-Sig"])
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+var tba_read_client_mutex sync.Mutex
+var tba_read_client *tba.ReadClient
+
+// the read API key from -tba-read-key, falling back to the key store and then
+// an older config.json
+func getTBAReadKey() string {
+	if FMSConfig.TbaReadKey != "" {
+		return FMSConfig.TbaReadKey
+	}
+	if key_store != nil && key_store.ReadApiKey() != "" {
+		return key_store.ReadApiKey()
+	}
+	return getLegacyReadKey()
+}
+
+// shared read API client, so that cached responses are reused between requests
+func getTBAReadClient() (*tba.ReadClient, error) {
+	key := getTBAReadKey()
+	if key == "" {
+		return nil, errors.New("no TBA read API key configured")
+	}
+
+	tba_read_client_mutex.Lock()
+	defer tba_read_client_mutex.Unlock()
+	if tba_read_client == nil || tba_read_client.TbaUrl != FMSConfig.TbaUrl || tba_read_client.Key != key {
+		tba_read_client = tba.NewReadClient(FMSConfig.TbaUrl, key)
+	}
+	return tba_read_client, nil
+}
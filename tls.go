@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const tlsCertFilename = "tls_cert.pem"
+const tlsKeyFilename = "tls_key.pem"
+const tlsSelfSignedValidity = 365 * 24 * time.Hour
+
+type tlsOptions struct {
+	// 0 to serve HTTP only
+	Port int
+	// provided certificate and key; a self-signed certificate in the data folder is used if empty
+	CertFile string
+	KeyFile  string
+}
+
+// hostnames and addresses of this machine, for the self-signed certificate
+func getLocalTLSNames() ([]string, []net.IP) {
+	names := []string{"localhost"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	addrs, _ := net.InterfaceAddrs()
+	for _, addr := range addrs {
+		if ip_net, ok := addr.(*net.IPNet); ok && !ip_net.IP.IsLoopback() {
+			ips = append(ips, ip_net.IP)
+		}
+	}
+	return names, ips
+}
+
+func generateSelfSignedCert(cert_file string, key_file string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return err
+	}
+	names, ips := getLocalTLSNames()
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"TBA-uploader"}, CommonName: names[len(names)-1]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(tlsSelfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              names,
+		IPAddresses:           ips,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return err
+	}
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	os.MkdirAll(filepath.Dir(cert_file), os.ModePerm)
+	err = writeFileAtomic(key_file, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key_der}))
+	if err != nil {
+		return err
+	}
+	os.Chmod(key_file, 0600)
+	return writeFileAtomic(cert_file, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+// load the provided certificate, or the self-signed certificate in the data
+// folder, generating a new one if it is missing or about to expire
+func loadTLSCertificate(options tlsOptions) (tls.Certificate, error) {
+	if options.CertFile != "" || options.KeyFile != "" {
+		if options.CertFile == "" || options.KeyFile == "" {
+			return tls.Certificate{}, fmt.Errorf("both a certificate and a key file are required")
+		}
+		return tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+	}
+
+	cert_file := filepath.Join(FMSConfig.DataFolder, tlsCertFilename)
+	key_file := filepath.Join(FMSConfig.DataFolder, tlsKeyFilename)
+	cert, err := tls.LoadX509KeyPair(cert_file, key_file)
+	if err == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err == nil && time.Now().Add(7*24*time.Hour).Before(leaf.NotAfter) {
+			return cert, nil
+		}
+	}
+	logger.Printf("Generating self-signed certificate in %s\n", cert_file)
+	err = generateSelfSignedCert(cert_file, key_file)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.LoadX509KeyPair(cert_file, key_file)
+}
+
+// redirect plain HTTP requests to the HTTPS listener on tls_port
+func tlsRedirectHandler(tls_port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		target := "https://" + net.JoinHostPort(host, fmt.Sprint(tls_port)) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSelfSignedCertificate(t *testing.T) {
+	old_data_folder := FMSConfig.DataFolder
+	FMSConfig.DataFolder = t.TempDir()
+	defer func() {
+		FMSConfig.DataFolder = old_data_folder
+	}()
+
+	cert, err := loadTLSCertificate(tlsOptions{Port: 8843})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert2, err := loadTLSCertificate(tlsOptions{Port: 8843})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cert.Certificate[0], cert2.Certificate[0]) {
+		t.Error("self-signed certificate regenerated")
+	}
+	if _, err := loadTLSCertificate(tlsOptions{Port: 8843, CertFile: "cert.pem"}); err == nil {
+		t.Error("certificate without a key accepted")
+	}
+}
+
+func TestTLSRedirect(t *testing.T) {
+	w := httptest.NewRecorder()
+	tlsRedirectHandler(8843).ServeHTTP(w, httptest.NewRequest("GET", "http://10.0.100.10:8808/api/queue/list?x=1", nil))
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "https://10.0.100.10:8843/api/queue/list?x=1" {
+		t.Error("unexpected redirect:", w.Code, w.Header().Get("Location"))
+	}
+}
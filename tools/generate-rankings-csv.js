@@ -20,8 +20,13 @@ const columns = [
   ['Losses', 'losses'],
   ['Ties', 'ties'],
 ];
-for (let item of tba.RANKING_NAMES[year]) {
-  columns.push([item, item]);
+// RANKING_NAMES is only available in the browser, so take the names from the
+// converted rankings instead
+const common_keys = Object.keys(tba.convertToTBARankings.common({}));
+for (let item of Object.keys(rankings[0] || {})) {
+  if (!common_keys.includes(item)) {
+    columns.push([item, item]);
+  }
 }
 
 console.log(columns.map(c => c[0]).join(','));
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+// endpoints whose writes can be undone from the audit log
+var undoableEndpoints = map[string]bool{
+	tba.MatchList{}.Path():          true,
+	tba.MatchDeleteList{}.Path():    true,
+	tba.Rankings{}.Path():           true,
+	tba.AllianceSelections{}.Path(): true,
+}
+
+// the requests that revert one write to TBA
+type undoPlan struct {
+	// audit record of the write being undone
+	Target   auditRecord   `json:"target"`
+	Payloads []tba.Payload `json:"-"`
+	// partial match keys that are deleted from TBA or re-posted
+	Deleted  []string `json:"deleted,omitempty"`
+	Restored []string `json:"restored,omitempty"`
+	// matches that cannot be restored, with the reason
+	Skipped map[string]string `json:"skipped,omitempty"`
+}
+
+// successful writes to TBA that are still in effect, in order. Undo writes are
+// included, but writes that have been undone (or are being undone) are not.
+func getEffectiveWrites(event string) ([]auditRecord, error) {
+	records, err := readAuditLog(auditFilter{Event: event})
+	if err != nil {
+		return nil, err
+	}
+	undone := make(map[string]bool)
+	for _, record := range records {
+		if record.Result == AUDIT_RESULT_SENT && record.UndoOf != "" {
+			undone[record.UndoOf] = true
+		}
+	}
+	if upload_queue != nil {
+		for _, entry := range upload_queue.List() {
+			if entry.State == QUEUE_STATE_PENDING && entry.UndoOf != "" {
+				undone[entry.UndoOf] = true
+			}
+		}
+	}
+	writes := make([]auditRecord, 0)
+	for _, record := range records {
+		if record.Result == AUDIT_RESULT_SENT && !undone[record.key()] {
+			writes = append(writes, record)
+		}
+	}
+	return writes, nil
+}
+
+// the last version of each match posted by writes, or nil if it was deleted
+func getMatchVersions(writes []auditRecord) (map[string]*tba.Match, error) {
+	versions := make(map[string]*tba.Match)
+	for _, record := range writes {
+		switch record.Endpoint {
+		case tba.MatchList{}.Path():
+			var matches tba.MatchList
+			if err := json.Unmarshal(record.Payload, &matches); err != nil {
+				return nil, fmt.Errorf("audit record %s: %s", record.key(), err)
+			}
+			for i := range matches {
+				versions[matches[i].PartialKey()] = &matches[i]
+			}
+		case tba.MatchDeleteList{}.Path():
+			var keys []string
+			if err := json.Unmarshal(record.Payload, &keys); err != nil {
+				return nil, fmt.Errorf("audit record %s: %s", record.key(), err)
+			}
+			for _, key := range keys {
+				versions[key] = nil
+			}
+		}
+	}
+	return versions, nil
+}
+
+// partial match keys on TBA at the last read from it before a time, or nil if
+// it was not read
+func getLastMatchRead(event string, before time.Time) (map[string]bool, error) {
+	records, err := readAuditLog(auditFilter{Event: event, Until: before})
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		record := records[i]
+		if record.Result != AUDIT_RESULT_READ || record.Endpoint != auditMatchReadEndpoint || !record.Time.Before(before) {
+			continue
+		}
+		var keys []string
+		if err := json.Unmarshal(record.Payload, &keys); err != nil {
+			return nil, fmt.Errorf("audit record %s: %s", record.key(), err)
+		}
+		out := make(map[string]bool)
+		for _, key := range keys {
+			out[key] = true
+		}
+		return out, nil
+	}
+	return nil, nil
+}
+
+// find the last write to an endpoint that can be undone, and the requests that
+// restore what TBA had before it
+func planUndo(event string, endpoint string) (*undoPlan, error) {
+	if !undoableEndpoints[endpoint] {
+		return nil, fmt.Errorf("writes to %s cannot be undone", endpoint)
+	}
+	writes, err := getEffectiveWrites(event)
+	if err != nil {
+		return nil, err
+	}
+	target := -1
+	for i := len(writes) - 1; i >= 0; i-- {
+		// undo writes are not undone themselves, so that repeated undos step back through history
+		if writes[i].Endpoint == endpoint && writes[i].UndoOf == "" {
+			target = i
+			break
+		}
+	}
+	if target == -1 {
+		return nil, fmt.Errorf("no upload to %s for %s to undo", endpoint, event)
+	}
+	plan := &undoPlan{Target: writes[target], Skipped: make(map[string]string)}
+	earlier := writes[:target]
+
+	switch endpoint {
+	case tba.MatchList{}.Path(), tba.MatchDeleteList{}.Path():
+		keys := auditPayloadMatchKeys(endpoint, plan.Target.Payload)
+		versions, err := getMatchVersions(earlier)
+		if err != nil {
+			return nil, err
+		}
+		read, err := getLastMatchRead(event, plan.Target.Time)
+		if err != nil {
+			return nil, err
+		}
+		restore := make(tba.MatchList, 0)
+		for _, key := range keys {
+			version, ok := versions[key]
+			if version != nil {
+				restore = append(restore, *version)
+				plan.Restored = append(plan.Restored, key)
+			} else if endpoint == (tba.MatchList{}).Path() {
+				// only delete matches that did not exist on TBA before this write
+				if ok {
+					plan.Deleted = append(plan.Deleted, key)
+				} else if read != nil && !read[key] {
+					plan.Deleted = append(plan.Deleted, key)
+				} else {
+					plan.Skipped[key] = "no earlier version in the audit log, and TBA was not read before this write to show that it was created by it"
+				}
+			} else if ok {
+				plan.Skipped[key] = "already deleted before this write"
+			} else {
+				plan.Skipped[key] = "no earlier version in the audit log"
+			}
+		}
+		if len(plan.Deleted) > 0 {
+			plan.Payloads = append(plan.Payloads, tba.MatchDeleteList(plan.Deleted))
+		}
+		if len(restore) > 0 {
+			plan.Payloads = append(plan.Payloads, restore)
+		}
+
+	default:
+		var previous *auditRecord
+		for i := range earlier {
+			if earlier[i].Endpoint == endpoint {
+				previous = &earlier[i]
+			}
+		}
+		if previous == nil {
+			return nil, fmt.Errorf("no earlier upload to %s for %s to restore", endpoint, event)
+		}
+		var payload tba.Payload
+		if endpoint == (tba.Rankings{}).Path() {
+			payload = &tba.Rankings{}
+		} else {
+			payload = &tba.AllianceSelections{}
+		}
+		if err := json.Unmarshal(previous.Payload, payload); err != nil {
+			return nil, fmt.Errorf("audit record %s: %s", previous.key(), err)
+		}
+		plan.Payloads = append(plan.Payloads, payload)
+	}
+	if len(plan.Payloads) == 0 {
+		return nil, fmt.Errorf("nothing to restore for the write to %s at %s: %v", endpoint, plan.Target.Time, plan.Skipped)
+	}
+	return plan, nil
+}
+
+// queue the requests of a plan. Each request is only sent once the previous one
+// has been delivered, and the receipts written by the undone write are removed
+// once the last one has been, so that its matches are offered for upload again.
+func queueUndo(plan *undoPlan, params *tba.EventParams, operator string) ([]int64, error) {
+	requests := make([]tba.SignedRequest, len(plan.Payloads))
+	client := tba.NewClient(FMSConfig.TbaUrl, *params)
+	for i, payload := range plan.Payloads {
+		var err error
+		requests[i], err = client.NewRequest(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]int64, len(requests))
+	for i, request := range requests {
+		entry := &queueEntry{
+			Request:  request,
+			Operator: operator,
+			UndoOf:   plan.Target.key(),
+		}
+		if i > 0 {
+			entry.After = ids[i-1]
+		}
+		if i == len(requests)-1 {
+			entry.RemoveReceipts = plan.Target.Receipts
+		}
+		ids[i] = upload_queue.submitEntry(entry, nil)
+	}
+	publishUploadQueueStatus()
+	logger.Printf("undo: %s for %s: reverting the write at %s\n", plan.Target.Endpoint, params.Event, plan.Target.Time)
+	return ids, nil
+}
+
+type undoResult struct {
+	undoPlan
+	RequestIds []int64              `json:"request_ids,omitempty"`
+	DryRun     []tba.RequestPreview `json:"dry_run,omitempty"`
+}
+
+// revert the last write to endpoint (e.g. matches/update) for an event
+func apiUndo(w http.ResponseWriter, r *http.Request) {
+	params := checkRequestEventParams(r)
+	endpoint := checkRequestQueryParam(r, "endpoint")
+	plan, err := planUndo(params.Event, endpoint)
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	result := undoResult{undoPlan: *plan}
+	operator := getRequestOperator(r)
+
+	if isRequestDryRun(r) {
+		for _, payload := range plan.Payloads {
+			preview, err := previewTBAPayload(payload, params, operator)
+			if err != nil {
+				apiPanicBadRequest("%s", err)
+			}
+			result.DryRun = append(result.DryRun, preview)
+		}
+		writeApiResponse(w, http.StatusOK, apiResponse{Status: API_STATUS_DRY_RUN, Data: result})
+		return
+	}
+
+	result.RequestIds, err = queueUndo(plan, params, operator)
+	if err != nil {
+		apiPanicBadRequest("%s", err)
+	}
+	for i, id := range result.RequestIds {
+		res := upload_queue.Wait(id, tbaRequestWaitTimeout)
+		if res.State == QUEUE_STATE_FAILED {
+			message := res.Error
+			if i > 0 {
+				message = fmt.Sprintf("undo partially applied: request %d was delivered, but request %d failed: %s", result.RequestIds[i-1], id, res.Error)
+			}
+			apiPanicError(APIError{
+				code:       http.StatusInternalServerError,
+				error_code: API_ERROR_TBA,
+				message:    message,
+				tba_status: res.TbaStatus,
+				tba_body:   res.TbaBody,
+			})
+		} else if res.State == QUEUE_STATE_UNKNOWN {
+			apiPanicInternal("%s", res.Error)
+		} else if res.State == QUEUE_STATE_PENDING {
+			writeApiResponse(w, http.StatusAccepted, apiResponse{Status: API_STATUS_QUEUED, Data: result})
+			return
+		}
+	}
+	sendJson(w, result)
+}
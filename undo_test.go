@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+func TestUndoMatches(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	match_folder := getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test")
+	os.MkdirAll(match_folder, os.ModePerm)
+	upload := func(ids string, body string) {
+		t.Helper()
+		if status, res := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids="+ids, "secret", body); status != http.StatusOK {
+			t.Fatalf("upload failed: %d %s", status, res)
+		}
+	}
+	undo := func() (int, string) {
+		return postWebTest(t, server.URL+"/api/undo?endpoint=matches/update", "secret", "")
+	}
+
+	upload("1-1", webTestMatches)
+	// record which matches TBA has, so that matches created after this can be deleted
+	old_read_key := FMSConfig.TbaReadKey
+	FMSConfig.TbaReadKey = "read"
+	defer func() { FMSConfig.TbaReadKey = old_read_key }()
+	if _, err := reconcileMatches("2023test", MATCH_LEVEL_QUAL); err != nil {
+		t.Fatal("reconcileMatches:", err)
+	}
+	upload("1-2,2-1", `[{
+		"comp_level": "qm", "set_number": 1, "match_number": 1,
+		"alliances": {"red": {"teams": ["frc1"], "score": 30}, "blue": {"teams": ["frc4"], "score": 20}}
+	}, {
+		"comp_level": "qm", "set_number": 1, "match_number": 2,
+		"alliances": {"red": {"teams": ["frc1"], "score": 5}, "blue": {"teams": ["frc4"], "score": 6}}
+	}]`)
+
+	// qm1 is restored to its first version, and qm2 is deleted
+	if status, res := undo(); status != http.StatusOK {
+		t.Fatalf("undo failed: %d %s", status, res)
+	}
+	matches := fake.Matches("2023test")
+	if _, ok := matches["qm2"]; ok {
+		t.Error("created match not deleted")
+	}
+	if score := matches["qm1"].Alliances.Red.Score; score != 10 {
+		t.Errorf("modified match not restored: got red score %d, expected 10", score)
+	}
+	if isFile(path.Join(match_folder, "2-1.receipt")) {
+		t.Error("receipt of undone upload not removed")
+	}
+
+	// undoing again targets the first upload instead of the undo, but nothing
+	// shows that qm1 did not exist on TBA before it, so it is not deleted
+	plan, err := planUndo("2023test", "matches/update")
+	if err == nil || plan != nil {
+		t.Errorf("undo of an upload before any read: got plan %v, error %v", plan, err)
+	}
+	if status, _ := undo(); status != http.StatusBadRequest {
+		t.Errorf("undo with nothing to undo: got status %d, expected %d", status, http.StatusBadRequest)
+	}
+	if _, ok := fake.Matches("2023test")["qm1"]; !ok {
+		t.Error("match deleted without proof that the undone upload created it")
+	}
+}
+
+func TestUndoAlliances(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	first := tba.AllianceSelections{{"frc1", "frc2", "frc3"}, {"frc4", "frc5", "frc6"}}
+	postWebTest(t, server.URL+"/api/alliances/upload", "secret", `[["frc1", "frc2", "frc3"], ["frc4", "frc5", "frc6"]]`)
+	postWebTest(t, server.URL+"/api/alliances/upload", "secret", `[["frc4", "frc5", "frc6"], ["frc1", "frc2", "frc3"]]`)
+
+	status, res := postWebTest(t, server.URL+"/api/undo?endpoint=alliance_selections/update&dry_run=true", "secret", "")
+	if status != http.StatusOK || len(fake.Requests()) != 2 {
+		t.Errorf("dry run undo sent a request: %d %s", status, res)
+	}
+	if status, res := postWebTest(t, server.URL+"/api/undo?endpoint=alliance_selections/update", "secret", ""); status != http.StatusOK {
+		t.Fatalf("undo failed: %d %s", status, res)
+	}
+	if alliances := fake.Alliances("2023test"); !reflect.DeepEqual(alliances, first) {
+		t.Errorf("got alliances %v, expected %v", alliances, first)
+	}
+	if status, _ := postWebTest(t, server.URL+"/api/undo?endpoint=alliance_selections/update", "secret", ""); status != http.StatusBadRequest {
+		t.Errorf("undo without an earlier upload: got status %d, expected %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestUndoQueued(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	match_folder := getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test")
+	os.MkdirAll(match_folder, os.ModePerm)
+	old_read_key := FMSConfig.TbaReadKey
+	FMSConfig.TbaReadKey = "read"
+	defer func() { FMSConfig.TbaReadKey = old_read_key }()
+	if _, err := reconcileMatches("2023test", MATCH_LEVEL_QUAL); err != nil {
+		t.Fatal("reconcileMatches:", err)
+	}
+	if status, res := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids=1-1", "secret", webTestMatches); status != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", status, res)
+	}
+
+	// the undo is only delivered after the request has returned
+	old_timeout := tbaRequestWaitTimeout
+	tbaRequestWaitTimeout = 0
+	defer func() { tbaRequestWaitTimeout = old_timeout }()
+	upload_queue.mutex.Lock()
+	upload_queue.paused = true
+	upload_queue.mutex.Unlock()
+	status, res := postWebTest(t, server.URL+"/api/undo?endpoint=matches/update", "secret", "")
+	if status != http.StatusAccepted {
+		t.Fatalf("undo not queued: %d %s", status, res)
+	}
+	upload_queue.mutex.Lock()
+	upload_queue.paused = false
+	upload_queue.mutex.Unlock()
+	upload_queue.notify()
+
+	for _, entry := range upload_queue.List() {
+		if result := upload_queue.Wait(entry.Id, 5*time.Second); result.State != QUEUE_STATE_SENT {
+			t.Fatal("undo not delivered:", result)
+		}
+	}
+	if _, ok := fake.Matches("2023test")["qm1"]; ok {
+		t.Error("created match not deleted")
+	}
+	if isFile(path.Join(match_folder, "1-1.receipt")) {
+		t.Error("receipt of undone upload not removed after delivery")
+	}
+}
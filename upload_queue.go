@@ -0,0 +1,701 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+const (
+	QUEUE_STATE_PENDING = "pending"
+	QUEUE_STATE_FAILED  = "failed"
+	QUEUE_STATE_SENT    = "sent"
+	// not in the queue, and not delivered or removed by this process
+	QUEUE_STATE_UNKNOWN = "unknown"
+)
+
+const queueMaxBackoff = 5 * time.Minute
+
+// how long the results of entries that have left the queue are kept for Wait
+const queueResultTTL = 10 * time.Minute
+
+// match receipts to write once a queued request has been delivered
+type queueReceipts struct {
+	Folder   string   `json:"folder"`
+	MatchIds []string `json:"match_ids"`
+}
+
+type queueEntry struct {
+	Id          int64             `json:"id"`
+	Created     time.Time         `json:"created"`
+	Request     tba.SignedRequest `json:"request"`
+	Hash        string            `json:"hash"`
+	State       string            `json:"state"`
+	Attempts    int               `json:"attempts"`
+	NextAttempt time.Time         `json:"next_attempt"`
+	LastError   string            `json:"last_error"`
+	// status and body of the last TBA error response
+	LastStatus   int            `json:"last_status,omitempty"`
+	LastResponse string         `json:"last_response,omitempty"`
+	Receipts     *queueReceipts `json:"receipts,omitempty"`
+	// who queued the request, for the audit log
+	Operator string `json:"operator,omitempty"`
+	// key of the audit record of the write that this request undoes
+	UndoOf string `json:"undo_of,omitempty"`
+	// match receipts to remove once delivered, e.g. those of an undone write
+	RemoveReceipts *queueReceipts `json:"remove_receipts,omitempty"`
+	// ID of a request that must be delivered first. If it fails, this request
+	// fails too, so that e.g. an undo is not half-applied.
+	After int64 `json:"after,omitempty"`
+}
+
+// requests to the same endpoint of the same event are delivered in order. A
+// failed request holds back the rest of its lane until it is retried or removed.
+func (e *queueEntry) lane() string {
+	return e.Request.Event + "/" + e.Request.Path
+}
+
+type queueResult struct {
+	State     string
+	Error     string
+	TbaStatus int
+	TbaBody   string
+}
+
+type queueDoneResult struct {
+	result   queueResult
+	finished time.Time
+}
+
+type uploadQueue struct {
+	mutex   sync.Mutex
+	folder  string
+	entries map[int64]*queueEntry
+	next_id int64
+	paused  bool
+	waiters map[int64][]chan queueResult
+	// results of entries that have left the queue, kept for queueResultTTL
+	done map[int64]queueDoneResult
+	wake chan struct{}
+	// closed to stop run, which closes stopped once it has returned
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+var upload_queue *uploadQueue
+
+var errQueueLocked = errors.New("upload queue is in use by another process")
+
+// take the lock file of a queue folder, which is held for as long as the
+// process runs so that two processes never deliver the same entries
+func lockQueueFolder(folder string, wait bool) (*os.File, error) {
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path.Join(folder, "lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f, wait)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// lock file of the queue folder, kept open (and locked) until exit
+var upload_queue_lock *os.File
+
+func newUploadQueue(folder string) (*uploadQueue, error) {
+	q := &uploadQueue{
+		folder:  folder,
+		entries: make(map[int64]*queueEntry),
+		next_id: 1,
+		waiters: make(map[int64][]chan queueResult),
+		done:    make(map[int64]queueDoneResult),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	err := os.MkdirAll(folder, os.ModePerm)
+	if err != nil {
+		return nil, err
+	}
+	files, err := listFilesWithExtension(folder, "json")
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(path.Join(folder, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var entry queueEntry
+		err = json.Unmarshal(raw, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %s", file.Name(), err)
+		}
+		q.entries[entry.Id] = &entry
+		if entry.Id >= q.next_id {
+			q.next_id = entry.Id + 1
+		}
+	}
+	return q, nil
+}
+
+func initUploadQueue() {
+	folder := path.Join(FMSConfig.DataFolder, "upload_queue")
+	var err error
+	upload_queue_lock, err = lockQueueFolder(folder, false)
+	if err == errQueueLocked {
+		logger.Printf("Waiting for another process to release the upload queue in %s\n", folder)
+		upload_queue_lock, err = lockQueueFolder(folder, true)
+	}
+	if err != nil {
+		logger.Fatalf("Could not lock upload queue: %s\n", err)
+	}
+	upload_queue, err = newUploadQueue(folder)
+	if err != nil {
+		logger.Fatalf("Could not load upload queue: %s\n", err)
+	}
+	if len(upload_queue.entries) > 0 {
+		logger.Printf("Loaded %d queued TBA requests\n", len(upload_queue.entries))
+	}
+	go upload_queue.run()
+}
+
+// load the upload queue for a command-line upload without delivering it in
+// the background. Fails if another process (e.g. the web server) holds it.
+func initCliUploadQueue() error {
+	if upload_queue != nil {
+		return nil
+	}
+	folder := path.Join(FMSConfig.DataFolder, "upload_queue")
+	var err error
+	upload_queue_lock, err = lockQueueFolder(folder, false)
+	if err == errQueueLocked {
+		return fmt.Errorf("the upload queue in %s is in use by another process, probably the web server; upload through it instead", folder)
+	} else if err != nil {
+		return err
+	}
+	upload_queue, err = newUploadQueue(folder)
+	return err
+}
+
+func (q *uploadQueue) entryPath(id int64) string {
+	return path.Join(q.folder, fmt.Sprintf("%010d.json", id))
+}
+
+// callers must hold q.mutex
+func (q *uploadQueue) save(entry *queueEntry) {
+	out, err := json.MarshalIndent(entry, "", "  ")
+	if err == nil {
+		tmp_path := q.entryPath(entry.Id) + ".tmp"
+		err = ioutil.WriteFile(tmp_path, out, os.ModePerm)
+		if err == nil {
+			err = os.Rename(tmp_path, q.entryPath(entry.Id))
+		}
+	}
+	if err != nil {
+		logger.Printf("upload queue: failed to save request %d: %s\n", entry.Id, err)
+	}
+}
+
+// callers must hold q.mutex
+func (q *uploadQueue) finish(entry *queueEntry, result queueResult) {
+	if result.State == QUEUE_STATE_SENT {
+		delete(q.entries, entry.Id)
+		os.Remove(q.entryPath(entry.Id))
+	} else {
+		q.save(entry)
+	}
+	now := time.Now()
+	for id, done := range q.done {
+		if now.Sub(done.finished) > queueResultTTL {
+			delete(q.done, id)
+		}
+	}
+	q.done[entry.Id] = queueDoneResult{result: result, finished: now}
+	for _, ch := range q.waiters[entry.Id] {
+		ch <- result
+	}
+	delete(q.waiters, entry.Id)
+
+	if result.State == QUEUE_STATE_FAILED {
+		for _, other := range q.entries {
+			if other.After == entry.Id && other.State == QUEUE_STATE_PENDING {
+				other.State = QUEUE_STATE_FAILED
+				other.LastError = fmt.Sprintf("not sent because request %d failed", entry.Id)
+				record := newAuditRecord(other, AUDIT_RESULT_FAILED)
+				record.Error = other.LastError
+				writeAuditRecord(record)
+				q.finish(other, queueResult{State: QUEUE_STATE_FAILED, Error: other.LastError})
+			}
+		}
+	}
+}
+
+func (q *uploadQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// identifies identical requests, so that they are only sent once
+func requestHash(request tba.SignedRequest) string {
+	return fmt.Sprintf("%x", md5.Sum(append([]byte(request.Path), request.Body...)))
+}
+
+// add a signed request to the queue. If the last pending request of the same
+// lane is identical, it is reused instead of sending the same payload twice.
+func (q *uploadQueue) Submit(request tba.SignedRequest, receipts *queueReceipts, operator string) int64 {
+	return q.submitEntry(&queueEntry{Request: request, Operator: operator}, receipts)
+}
+
+// add a new entry with Request (and optionally Operator and UndoOf) set
+func (q *uploadQueue) submitEntry(entry *queueEntry, receipts *queueReceipts) int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	entry.Hash = requestHash(entry.Request)
+	var last *queueEntry
+	for _, existing := range q.entries {
+		if existing.State == QUEUE_STATE_PENDING && existing.lane() == entry.lane() && (last == nil || existing.Id > last.Id) {
+			last = existing
+		}
+	}
+	// an older identical request followed by a different one must not be reused,
+	// since that would deliver them in the wrong order
+	if last != nil && last.Hash == entry.Hash && last.UndoOf == entry.UndoOf {
+		if receipts != nil {
+			if last.Receipts == nil {
+				last.Receipts = &queueReceipts{Folder: receipts.Folder}
+			}
+			last.Receipts.MatchIds = append(last.Receipts.MatchIds, receipts.MatchIds...)
+			q.save(last)
+		}
+		logger.Printf("upload queue: coalesced %s with request %d\n", entry.lane(), last.Id)
+		return last.Id
+	}
+
+	entry.Id = q.next_id
+	q.next_id++
+	entry.Created = time.Now()
+	entry.State = QUEUE_STATE_PENDING
+	entry.NextAttempt = entry.Created
+	entry.Receipts = receipts
+	q.entries[entry.Id] = entry
+	q.save(entry)
+	q.notify()
+	return entry.Id
+}
+
+// the result of an entry that has been delivered, rejected or removed, or
+// false if it is still pending. Callers must hold q.mutex.
+func (q *uploadQueue) finalResult(id int64) (queueResult, bool) {
+	if entry, ok := q.entries[id]; ok {
+		if entry.State == QUEUE_STATE_FAILED {
+			return queueResult{State: QUEUE_STATE_FAILED, Error: entry.LastError, TbaStatus: entry.LastStatus, TbaBody: entry.LastResponse}, true
+		}
+		return queueResult{}, false
+	}
+	if done, ok := q.done[id]; ok {
+		return done.result, true
+	}
+	return queueResult{State: QUEUE_STATE_UNKNOWN, Error: fmt.Sprintf("request %d is not in the upload queue", id)}, true
+}
+
+// wait for a queued request to be delivered or rejected. Returns a pending
+// result if neither happens before the timeout, and an unknown result if id
+// was never queued.
+func (q *uploadQueue) Wait(id int64, timeout time.Duration) queueResult {
+	q.mutex.Lock()
+	if result, ok := q.finalResult(id); ok {
+		q.mutex.Unlock()
+		return result
+	}
+	ch := make(chan queueResult, 1)
+	q.waiters[id] = append(q.waiters[id], ch)
+	q.mutex.Unlock()
+
+	select {
+	case result := <-ch:
+		return result
+	case <-time.After(timeout):
+		q.mutex.Lock()
+		defer q.mutex.Unlock()
+		last_error := ""
+		if entry, ok := q.entries[id]; ok {
+			last_error = entry.LastError
+			if blocker := q.laneBlocker(entry); blocker != nil && last_error == "" {
+				last_error = fmt.Sprintf("waiting for failed request %d to be retried or removed", blocker.Id)
+			}
+		}
+		return queueResult{State: QUEUE_STATE_PENDING, Error: last_error}
+	}
+}
+
+// match IDs in match_folder that are waiting in the queue to be uploaded
+func (q *uploadQueue) QueuedMatchIds(match_folder string) map[string]bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	out := make(map[string]bool)
+	for _, entry := range q.entries {
+		if entry.State == QUEUE_STATE_PENDING && entry.Receipts != nil && entry.Receipts.Folder == match_folder {
+			for _, id := range entry.Receipts.MatchIds {
+				out[id] = true
+			}
+		}
+	}
+	return out
+}
+
+// all entries, in delivery order
+func (q *uploadQueue) List() []queueEntry {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	out := make([]queueEntry, 0, len(q.entries))
+	for _, entry := range q.entries {
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Id < out[j].Id
+	})
+	return out
+}
+
+// the first failed entry queued before entry in its lane, if any. It holds
+// back the rest of the lane until it is retried or removed, since e.g. a later
+// match upload could otherwise be overwritten by a retried earlier one.
+// Callers must hold q.mutex.
+func (q *uploadQueue) laneBlocker(entry *queueEntry) *queueEntry {
+	var blocker *queueEntry
+	for _, other := range q.entries {
+		if other.State == QUEUE_STATE_FAILED && other.lane() == entry.lane() && other.Id < entry.Id && (blocker == nil || other.Id < blocker.Id) {
+			blocker = other
+		}
+	}
+	return blocker
+}
+
+// callers must hold q.mutex
+func (q *uploadQueue) nextEntries(now time.Time) []*queueEntry {
+	heads := make(map[string]*queueEntry)
+	for _, entry := range q.entries {
+		if entry.State != QUEUE_STATE_PENDING {
+			continue
+		}
+		if head, ok := heads[entry.lane()]; !ok || entry.Id < head.Id {
+			heads[entry.lane()] = entry
+		}
+	}
+	out := make([]*queueEntry, 0)
+	for _, head := range heads {
+		if _, waiting := q.entries[head.After]; waiting && head.After != 0 {
+			continue
+		}
+		if q.laneBlocker(head) != nil {
+			continue
+		}
+		if !now.Before(head.NextAttempt) {
+			out = append(out, head)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Id < out[j].Id
+	})
+	return out
+}
+
+func queueBackoff(attempts int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempts && backoff < queueMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > queueMaxBackoff {
+		backoff = queueMaxBackoff
+	}
+	return backoff
+}
+
+// TBA rejected the request itself, so retrying will not help
+func isPermanentTBAError(status_code int) bool {
+	return status_code >= 400 && status_code < 500 && status_code != http.StatusTooManyRequests && status_code != http.StatusRequestTimeout
+}
+
+func (q *uploadQueue) deliver(entry *queueEntry) {
+	q.mutex.Lock()
+	request := entry.Request
+	q.mutex.Unlock()
+
+	res, err := request.Send()
+	var status_code int
+	var res_body []byte
+	if err == nil {
+		status_code = res.StatusCode
+		res_body, _ = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.entries[entry.Id]; !ok {
+		// removed while sending
+		return
+	}
+	entry.Attempts++
+	if err == nil && status_code == http.StatusOK {
+		if entry.Receipts != nil {
+			uploaded := tba.MatchList{}
+			json.Unmarshal(entry.Request.Body, &uploaded)
+			writeMatchReceipts(entry.Receipts.Folder, entry.Request.Event, entry.Receipts.MatchIds, uploaded)
+		}
+		if entry.RemoveReceipts != nil {
+			for _, fms_id := range entry.RemoveReceipts.MatchIds {
+				os.Remove(path.Join(entry.RemoveReceipts.Folder, fms_id+".receipt"))
+			}
+		}
+		if entry.Request.Path == (tba.AllianceSelections{}).Path() {
+			var alliances tba.AllianceSelections
+			json.Unmarshal(entry.Request.Body, &alliances)
+			if err := saveEventAlliances(entry.Request.Event, alliances); err != nil {
+				logger.Printf("upload queue: failed to save alliances: %s\n", err)
+			}
+		}
+		logger.Printf("upload queue: delivered request %d (%s)\n", entry.Id, entry.lane())
+		record := newAuditRecord(entry, AUDIT_RESULT_SENT)
+		record.TbaStatus, record.TbaResponse = status_code, string(res_body)
+		writeAuditRecord(record)
+		q.finish(entry, queueResult{State: QUEUE_STATE_SENT})
+		return
+	}
+
+	if err != nil {
+		entry.LastError = fmt.Sprintf("TBA request failed: %s", err)
+		entry.LastStatus, entry.LastResponse = 0, ""
+	} else {
+		entry.LastError = tba.ParseError(status_code, res_body).Error()
+		entry.LastStatus, entry.LastResponse = status_code, string(res_body)
+	}
+	result := AUDIT_RESULT_RETRY
+	if err == nil && isPermanentTBAError(status_code) {
+		result = AUDIT_RESULT_FAILED
+	}
+	record := newAuditRecord(entry, result)
+	record.TbaStatus, record.TbaResponse, record.Error = entry.LastStatus, entry.LastResponse, entry.LastError
+	writeAuditRecord(record)
+
+	if result == AUDIT_RESULT_FAILED {
+		entry.State = QUEUE_STATE_FAILED
+		logger.Printf("upload queue: request %d (%s) rejected: %s\n", entry.Id, entry.lane(), entry.LastError)
+		q.finish(entry, queueResult{State: QUEUE_STATE_FAILED, Error: entry.LastError, TbaStatus: status_code, TbaBody: entry.LastResponse})
+		return
+	}
+	entry.NextAttempt = time.Now().Add(queueBackoff(entry.Attempts))
+	logger.Printf("upload queue: request %d (%s) attempt %d failed, retrying at %s: %s\n",
+		entry.Id, entry.lane(), entry.Attempts, entry.NextAttempt.Format(time.RFC3339), entry.LastError)
+	q.save(entry)
+}
+
+func (q *uploadQueue) run() {
+	defer close(q.stopped)
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		// entries queued before dry-run mode was enabled are held until it is disabled
+		dry_run := isDryRun()
+		q.mutex.Lock()
+		var entries []*queueEntry
+		if !q.paused && !dry_run {
+			entries = q.nextEntries(time.Now())
+		}
+		q.mutex.Unlock()
+
+		for _, entry := range entries {
+			q.deliver(entry)
+		}
+		if len(entries) > 0 {
+			publishUploadQueueStatus()
+			continue
+		}
+
+		select {
+		case <-q.stop:
+			return
+		case <-q.wake:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// stop run, waiting for any delivery in progress to finish. Must only be
+// called once, after run has been started.
+func (q *uploadQueue) Stop() {
+	close(q.stop)
+	<-q.stopped
+}
+
+// deliver one entry now, retrying until timeout, without running the rest of
+// the queue. If earlier entries of its lane are still pending or have failed,
+// it is left queued so that requests are delivered in order.
+func (q *uploadQueue) DeliverNow(id int64, timeout time.Duration) queueResult {
+	deadline := time.Now().Add(timeout)
+	for {
+		q.mutex.Lock()
+		if result, ok := q.finalResult(id); ok {
+			q.mutex.Unlock()
+			return result
+		}
+		entry := q.entries[id]
+		for _, other := range q.entries {
+			if other.State == QUEUE_STATE_PENDING && other.lane() == entry.lane() && other.Id < entry.Id {
+				q.mutex.Unlock()
+				return queueResult{State: QUEUE_STATE_PENDING, Error: fmt.Sprintf("queued behind request %d", other.Id)}
+			}
+		}
+		if _, waiting := q.entries[entry.After]; waiting && entry.After != 0 {
+			q.mutex.Unlock()
+			return queueResult{State: QUEUE_STATE_PENDING, Error: fmt.Sprintf("waiting for request %d", entry.After)}
+		}
+		if blocker := q.laneBlocker(entry); blocker != nil {
+			q.mutex.Unlock()
+			return queueResult{State: QUEUE_STATE_PENDING, Error: fmt.Sprintf("waiting for failed request %d to be retried or removed", blocker.Id)}
+		}
+		next_attempt := entry.NextAttempt
+		q.mutex.Unlock()
+
+		if next_attempt.After(deadline) {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+			return queueResult{State: QUEUE_STATE_PENDING, Error: entry.LastError}
+		}
+		time.Sleep(time.Until(next_attempt))
+		q.deliver(entry)
+	}
+}
+
+type uploadQueueStatus struct {
+	Paused bool `json:"paused"`
+	// held because dry-run mode is enabled
+	DryRun  bool `json:"dry_run"`
+	Pending int  `json:"pending"`
+	Failed  int  `json:"failed"`
+}
+
+func (q *uploadQueue) Status() uploadQueueStatus {
+	dry_run := isDryRun()
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	status := uploadQueueStatus{Paused: q.paused, DryRun: dry_run}
+	for _, entry := range q.entries {
+		if entry.State == QUEUE_STATE_FAILED {
+			status.Failed++
+		} else {
+			status.Pending++
+		}
+	}
+	return status
+}
+
+func publishUploadQueueStatus() {
+	err := wsStateUpdate(map[string]interface{}{
+		"upload_queue": upload_queue.Status(),
+	})
+	if err != nil {
+		logger.Printf("upload queue: failed to publish status: %s\n", err)
+	}
+}
+
+// validate a TBA request and submit it to the upload queue without waiting for it to be delivered
+// operator is recorded in the audit log
+func queueTBAPayload(payload tba.Payload, params *tba.EventParams, receipts *queueReceipts, operator string) (int64, error) {
+	request, err := tba.NewClient(FMSConfig.TbaUrl, *params).NewRequest(payload)
+	if err != nil {
+		return 0, err
+	}
+	id := upload_queue.Submit(request, receipts, operator)
+	publishUploadQueueStatus()
+	return id, nil
+}
+
+func checkRequestQueueEntries(r *http.Request) []int64 {
+	ids := make([]int64, 0)
+	if r.URL.Query().Get("id") == "" {
+		for _, entry := range upload_queue.List() {
+			ids = append(ids, entry.Id)
+		}
+		return ids
+	}
+	id, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		apiPanicBadRequest("invalid id: %s", err)
+	}
+	return append(ids, id)
+}
+
+func apiQueueList(w http.ResponseWriter, r *http.Request) {
+	sendJson(w, map[string]interface{}{
+		"status":  upload_queue.Status(),
+		"entries": upload_queue.List(),
+	})
+}
+
+// retry a failed or backed-off request (or all requests if no id is given) immediately
+func apiQueueRetry(w http.ResponseWriter, r *http.Request) {
+	ids := checkRequestQueueEntries(r)
+	upload_queue.mutex.Lock()
+	for _, id := range ids {
+		if entry, ok := upload_queue.entries[id]; ok {
+			entry.State = QUEUE_STATE_PENDING
+			entry.NextAttempt = time.Now()
+			upload_queue.save(entry)
+		}
+	}
+	upload_queue.mutex.Unlock()
+	upload_queue.notify()
+	publishUploadQueueStatus()
+}
+
+func apiQueueRemove(w http.ResponseWriter, r *http.Request) {
+	ids := checkRequestQueueEntries(r)
+	upload_queue.mutex.Lock()
+	for _, id := range ids {
+		if entry, ok := upload_queue.entries[id]; ok {
+			entry.LastError = "removed from queue"
+			entry.State = QUEUE_STATE_FAILED
+			record := newAuditRecord(entry, AUDIT_RESULT_REMOVED)
+			record.Error = fmt.Sprintf("removed by %s", getRequestOperator(r))
+			writeAuditRecord(record)
+			upload_queue.finish(entry, queueResult{State: QUEUE_STATE_FAILED, Error: entry.LastError})
+			delete(upload_queue.entries, id)
+			os.Remove(upload_queue.entryPath(id))
+		}
+	}
+	upload_queue.mutex.Unlock()
+	publishUploadQueueStatus()
+}
+
+func apiQueuePause(w http.ResponseWriter, r *http.Request) {
+	upload_queue.mutex.Lock()
+	upload_queue.paused = (r.URL.Query().Get("paused") != "false")
+	upload_queue.mutex.Unlock()
+	upload_queue.notify()
+	publishUploadQueueStatus()
+}
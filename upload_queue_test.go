@@ -0,0 +1,242 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lethosor/TBA-uploader/tba"
+)
+
+type testTBAServer struct {
+	mutex    sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *testTBAServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status = s.statuses[0]
+		s.statuses = s.statuses[1:]
+	}
+	if status == http.StatusOK {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.bodies = append(s.bodies, string(body))
+	}
+	w.WriteHeader(status)
+}
+
+func deliverQueuedRequests(q *uploadQueue) {
+	q.mutex.Lock()
+	entries := q.nextEntries(time.Now().Add(time.Hour))
+	q.mutex.Unlock()
+	for _, entry := range entries {
+		q.deliver(entry)
+	}
+}
+
+func TestUploadQueue(t *testing.T) {
+	tba_server := &testTBAServer{statuses: []int{http.StatusInternalServerError}}
+	server := httptest.NewServer(tba_server)
+	defer server.Close()
+
+	folder := t.TempDir()
+	q, err := newUploadQueue(path.Join(folder, "queue"))
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	params := &tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	id1 := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("1"), params), &queueReceipts{
+		Folder:   folder,
+		MatchIds: []string{"1-1"},
+	}, "test")
+	id2 := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("2"), params), nil, "test")
+	if id := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("2"), params), nil, "test"); id != id2 {
+		t.Errorf("identical request not coalesced: got %d, expected %d", id, id2)
+	}
+	if !q.QueuedMatchIds(folder)["1-1"] {
+		t.Error("queued match ID not reported")
+	}
+
+	// reload from disk
+	q, err = newUploadQueue(path.Join(folder, "queue"))
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	if len(q.List()) != 2 {
+		t.Fatalf("wrong number of entries after reload: %d", len(q.List()))
+	}
+
+	// first attempt fails, so nothing after it in the same lane can be sent
+	deliverQueuedRequests(q)
+	if len(tba_server.bodies) != 0 {
+		t.Error("request delivered out of order:", tba_server.bodies)
+	}
+	if entries := q.List(); entries[0].Id != id1 || entries[0].Attempts != 1 || entries[0].LastError == "" {
+		t.Errorf("failure not recorded: %#v", entries[0])
+	}
+	if fileExists(path.Join(folder, "1-1.receipt")) {
+		t.Error("receipt written before delivery")
+	}
+
+	deliverQueuedRequests(q)
+	deliverQueuedRequests(q)
+	if len(tba_server.bodies) != 2 || tba_server.bodies[0] != "1" || tba_server.bodies[1] != "2" {
+		t.Error("wrong delivery order:", tba_server.bodies)
+	}
+	if len(q.List()) != 0 {
+		t.Error("delivered requests not removed")
+	}
+	if !fileExists(path.Join(folder, "1-1.receipt")) {
+		t.Error("receipt not written after delivery")
+	}
+
+	// rejected requests are not retried
+	tba_server.statuses = []int{http.StatusUnauthorized}
+	id3 := q.Submit(tba.SignRequest(server.URL, "rankings/update", []byte("3"), params), nil, "test")
+	deliverQueuedRequests(q)
+	if result := q.Wait(id3, time.Millisecond); result.State != QUEUE_STATE_FAILED {
+		t.Error("rejected request not marked as failed:", result)
+	}
+	if result := q.Wait(id2, time.Millisecond); result.State != QUEUE_STATE_SENT {
+		t.Error("delivered request not reported as sent:", result)
+	}
+	if result := q.Wait(1000, time.Millisecond); result.State != QUEUE_STATE_UNKNOWN {
+		t.Error("request that was never queued not reported as unknown:", result)
+	}
+
+	// only the last pending request of a lane is reused
+	id4 := q.Submit(tba.SignRequest(server.URL, "rankings/update", []byte("4"), params), nil, "test")
+	q.Submit(tba.SignRequest(server.URL, "rankings/update", []byte("5"), params), nil, "test")
+	if id := q.Submit(tba.SignRequest(server.URL, "rankings/update", []byte("4"), params), nil, "test"); id == id4 {
+		t.Error("request coalesced with one queued before a different request")
+	}
+}
+
+func TestQueueBackoff(t *testing.T) {
+	if queueBackoff(1) != time.Second || queueBackoff(3) != 4*time.Second {
+		t.Error("unexpected backoff:", queueBackoff(1), queueBackoff(3))
+	}
+	if queueBackoff(100) != queueMaxBackoff {
+		t.Error("backoff not capped:", queueBackoff(100))
+	}
+}
+
+func TestUploadQueueDeliverNow(t *testing.T) {
+	tba_server := &testTBAServer{}
+	server := httptest.NewServer(tba_server)
+	defer server.Close()
+
+	folder := path.Join(t.TempDir(), "queue")
+	lock, err := lockQueueFolder(folder, false)
+	if err != nil {
+		t.Fatal("lockQueueFolder:", err)
+	}
+	if _, err := lockQueueFolder(folder, false); err != errQueueLocked {
+		t.Error("queue locked twice:", err)
+	}
+	lock.Close()
+
+	q, err := newUploadQueue(folder)
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	params := &tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	old_id := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("1"), params), nil, "test")
+	q.Submit(tba.SignRequest(server.URL, "rankings/update", []byte("2"), params), nil, "test")
+	id := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("3"), params), nil, "test")
+
+	// an earlier request in the same lane must be delivered first
+	if result := q.DeliverNow(id, time.Second); result.State != QUEUE_STATE_PENDING {
+		t.Error("request delivered out of order:", result)
+	}
+	if result := q.DeliverNow(old_id, time.Second); result.State != QUEUE_STATE_SENT {
+		t.Error("request not delivered:", result)
+	}
+	if len(tba_server.bodies) != 1 || tba_server.bodies[0] != "1" {
+		t.Error("other requests delivered:", tba_server.bodies)
+	}
+}
+
+func TestUploadQueueAfter(t *testing.T) {
+	tba_server := &testTBAServer{statuses: []int{http.StatusUnauthorized}}
+	server := httptest.NewServer(tba_server)
+	defer server.Close()
+
+	q, err := newUploadQueue(path.Join(t.TempDir(), "queue"))
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	params := &tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	first := q.Submit(tba.SignRequest(server.URL, "matches/delete", []byte("1"), params), nil, "test")
+	second := q.submitEntry(&queueEntry{Request: tba.SignRequest(server.URL, "matches/update", []byte("2"), params), After: first}, nil)
+
+	// the second request waits for the first, and fails with it
+	deliverQueuedRequests(q)
+	if result := q.Wait(first, time.Millisecond); result.State != QUEUE_STATE_FAILED {
+		t.Error("rejected request not marked as failed:", result)
+	}
+	if result := q.Wait(second, time.Millisecond); result.State != QUEUE_STATE_FAILED {
+		t.Error("dependent request not failed:", result)
+	}
+	if len(tba_server.bodies) != 0 {
+		t.Error("dependent request sent:", tba_server.bodies)
+	}
+}
+
+func TestUploadQueueFailedHead(t *testing.T) {
+	tba_server := &testTBAServer{statuses: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(tba_server)
+	defer server.Close()
+
+	q, err := newUploadQueue(path.Join(t.TempDir(), "queue"))
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	params := &tba.EventParams{Event: "2023test", Auth: "auth", Secret: "secret"}
+	id1 := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("1"), params), nil, "test")
+	id2 := q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("2"), params), nil, "test")
+
+	// the rejected request holds back the rest of its lane
+	deliverQueuedRequests(q)
+	deliverQueuedRequests(q)
+	if len(tba_server.bodies) != 0 {
+		t.Error("request delivered after a failed one:", tba_server.bodies)
+	}
+	if result := q.Wait(id2, time.Millisecond); result.State != QUEUE_STATE_PENDING || result.Error == "" {
+		t.Error("held request not reported as waiting:", result)
+	}
+	if result := q.DeliverNow(id2, time.Millisecond); result.State != QUEUE_STATE_PENDING {
+		t.Error("held request delivered by DeliverNow:", result)
+	}
+
+	// until it is retried
+	q.mutex.Lock()
+	q.entries[id1].State = QUEUE_STATE_PENDING
+	q.mutex.Unlock()
+	deliverQueuedRequests(q)
+	deliverQueuedRequests(q)
+	if len(tba_server.bodies) != 2 || tba_server.bodies[0] != "1" || tba_server.bodies[1] != "2" {
+		t.Error("wrong delivery order after retry:", tba_server.bodies)
+	}
+
+	// results are kept for Wait only for a while
+	q.mutex.Lock()
+	q.done[id1] = queueDoneResult{result: q.done[id1].result, finished: time.Now().Add(-2 * queueResultTTL)}
+	q.mutex.Unlock()
+	q.Submit(tba.SignRequest(server.URL, "matches/update", []byte("3"), params), nil, "test")
+	deliverQueuedRequests(q)
+	if result := q.Wait(id1, time.Millisecond); result.State != QUEUE_STATE_UNKNOWN {
+		t.Error("expired result not pruned:", result)
+	}
+	if result := q.Wait(id2, time.Millisecond); result.State != QUEUE_STATE_SENT {
+		t.Error("recent result pruned:", result)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"embed"
 	"encoding/json"
 	"fmt"
@@ -9,7 +10,6 @@ import (
 	"net/http"
 	"os"
 	"path"
-	"path/filepath"
 	"runtime/debug"
 	"strconv"
 	"strings"
@@ -21,22 +21,66 @@ import (
 	"github.com/lethosor/TBA-uploader/tba"
 )
 
-// @todo hack
-var keys_json []byte
+// machine-readable error codes returned in API error responses
+const (
+	API_ERROR_BAD_REQUEST        = "bad_request"
+	API_ERROR_MISSING_PARAM      = "missing_param"
+	API_ERROR_MISSING_AUTH       = "missing_event_auth"
+	API_ERROR_UNAUTHORIZED       = "unauthorized"
+	API_ERROR_FORBIDDEN          = "forbidden"
+	API_ERROR_NOT_FOUND          = "not_found"
+	API_ERROR_METHOD_NOT_ALLOWED = "method_not_allowed"
+	API_ERROR_TOO_LARGE          = "too_large"
+	API_ERROR_KEY_STORE_LOCKED   = "key_store_locked"
+	API_ERROR_PROXY_DENIED       = "proxy_denied"
+	API_ERROR_UPSTREAM           = "upstream_failed"
+	API_ERROR_TBA                = "tba_error"
+	API_ERROR_INTERNAL           = "internal"
+)
+
+// default error codes by HTTP status
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:            API_ERROR_BAD_REQUEST,
+	http.StatusUnauthorized:          API_ERROR_UNAUTHORIZED,
+	http.StatusForbidden:             API_ERROR_FORBIDDEN,
+	http.StatusNotFound:              API_ERROR_NOT_FOUND,
+	http.StatusMethodNotAllowed:      API_ERROR_METHOD_NOT_ALLOWED,
+	http.StatusRequestEntityTooLarge: API_ERROR_TOO_LARGE,
+	http.StatusBadGateway:            API_ERROR_UPSTREAM,
+}
 
 type APIError struct {
-	code    int
-	message string
+	code       int
+	error_code string
+	message    string
+	// set if the error is a response from TBA
+	tba_status int
+	tba_body   string
 }
 
-func apiPanicCode(code int, message string, args ...interface{}) {
-	logger.Printf("API Error %d: "+message, append([]interface{}{code}, args...)...)
-	panic(APIError{
-		code:    code,
-		message: fmt.Sprintf(message, args...),
+func apiPanicError(err APIError) {
+	if err.error_code == "" {
+		err.error_code = apiErrorCodes[err.code]
+	}
+	if err.error_code == "" {
+		err.error_code = API_ERROR_INTERNAL
+	}
+	logger.Printf("API Error %d (%s): %s", err.code, err.error_code, err.message)
+	panic(err)
+}
+
+func apiPanicErrorCode(code int, error_code string, message string, args ...interface{}) {
+	apiPanicError(APIError{
+		code:       code,
+		error_code: error_code,
+		message:    fmt.Sprintf(message, args...),
 	})
 }
 
+func apiPanicCode(code int, message string, args ...interface{}) {
+	apiPanicErrorCode(code, "", message, args...)
+}
+
 func apiPanicBadRequest(message string, args ...interface{}) {
 	apiPanicCode(http.StatusBadRequest, message, args...)
 }
@@ -45,7 +89,68 @@ func apiPanicInternal(message string, args ...interface{}) {
 	apiPanicCode(http.StatusInternalServerError, message, args...)
 }
 
+// every /api response (except successful proxy responses) is wrapped in an apiResponse
+const (
+	API_STATUS_OK      = "ok"
+	API_STATUS_QUEUED  = "queued"
+	API_STATUS_DRY_RUN = "dry_run"
+	API_STATUS_ERROR   = "error"
+)
+
+type apiResponseError struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	TbaStatus int    `json:"tba_status,omitempty"`
+	TbaBody   string `json:"tba_body,omitempty"`
+}
+
+type apiResponse struct {
+	Status string            `json:"status"`
+	Data   any               `json:"data,omitempty"`
+	Error  *apiResponseError `json:"error,omitempty"`
+}
+
+func writeApiResponse(w http.ResponseWriter, code int, res apiResponse) {
+	out, err := json.Marshal(res)
+	if err != nil {
+		logger.Printf("json encode failed: %s\n", err)
+		code = http.StatusInternalServerError
+		out, _ = json.Marshal(apiResponse{
+			Status: API_STATUS_ERROR,
+			Error:  &apiResponseError{Code: API_ERROR_INTERNAL, Message: fmt.Sprintf("json encode failed: %s", err)},
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(out)
+}
+
+func sendApiError(w http.ResponseWriter, err APIError) {
+	writeApiResponse(w, err.code, apiResponse{
+		Status: API_STATUS_ERROR,
+		Error: &apiResponseError{
+			Code:      err.error_code,
+			Message:   err.message,
+			TbaStatus: err.tba_status,
+			TbaBody:   err.tba_body,
+		},
+	})
+}
+
+// event credentials for a request. Keys in the key store take precedence, so
+// that browsers only need to send X-Event; otherwise X-Auth and X-Secret are
+// required.
 func getRequestEventParams(r *http.Request) (*tba.EventParams, bool) {
+	event := r.Header.Get("X-Event")
+	if key_store != nil && len(event) > 0 {
+		if key, ok := key_store.Get(event); ok {
+			return &tba.EventParams{
+				Event:  event,
+				Auth:   key.Auth,
+				Secret: key.Secret,
+			}, true
+		}
+	}
 	if len(r.Header.Get("X-Event")) > 0 && len(r.Header.Get("X-Auth")) > 0 && len(r.Header.Get("X-Secret")) > 0 {
 		return &tba.EventParams{
 			Event:  r.Header.Get("X-Event"),
@@ -59,7 +164,7 @@ func getRequestEventParams(r *http.Request) (*tba.EventParams, bool) {
 func checkRequestEventParams(r *http.Request) *tba.EventParams {
 	params, ok := getRequestEventParams(r)
 	if params == nil || !ok {
-		apiPanicBadRequest("missing event/auth parameters")
+		apiPanicErrorCode(http.StatusBadRequest, API_ERROR_MISSING_AUTH, "missing event/auth parameters")
 	}
 	return params
 }
@@ -86,7 +191,7 @@ func checkRequestLevel(r *http.Request) int {
 func checkRequestQueryParam(r *http.Request, param string) string {
 	res := r.URL.Query().Get(param)
 	if res == "" {
-		apiPanicBadRequest("missing parameter: %s", param)
+		apiPanicErrorCode(http.StatusBadRequest, API_ERROR_MISSING_PARAM, "missing parameter: %s", param)
 	}
 	return res
 }
@@ -122,28 +227,75 @@ func parseEventYear(event string) int {
 	return year
 }
 
-func apiTBARequest(path string, w http.ResponseWriter, r *http.Request) {
+// how long upload requests wait for the upload queue before responding
+var tbaRequestWaitTimeout = 10 * time.Second
+
+type tbaRequestQueued struct {
+	RequestId int64  `json:"request_id"`
+	Message   string `json:"message"`
+}
+
+func apiTBARequestWithReceipts(payload tba.Payload, receipts *queueReceipts, w http.ResponseWriter, r *http.Request) {
 	params := checkRequestEventParams(r)
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		apiPanicInternal("read failed: %s", err)
 	}
-
-	res, err := tba.SendRequest(FMSConfig.TbaUrl, path, body, params)
+	err = json.Unmarshal(body, payload)
 	if err != nil {
-		apiPanicInternal("TBA request failed: %s", err)
+		apiPanicBadRequest("invalid %s payload: %s", payload.Path(), err)
+	}
+	if isRequestDryRun(r) {
+		sendTBADryRun(w, payload, params, getRequestOperator(r))
+		return
 	}
 
-	if res.StatusCode != http.StatusOK {
-		res_body, _ := ioutil.ReadAll(res.Body)
-		apiPanicInternal("TBA error %d: %s", res.StatusCode, res_body)
+	id, err := queueTBAPayload(payload, params, receipts, getRequestOperator(r))
+	if err != nil {
+		apiPanicBadRequest("%s", err)
 	}
+	result := upload_queue.Wait(id, tbaRequestWaitTimeout)
+	if result.State == QUEUE_STATE_FAILED {
+		apiPanicError(APIError{
+			code:       http.StatusInternalServerError,
+			error_code: API_ERROR_TBA,
+			message:    result.Error,
+			tba_status: result.TbaStatus,
+			tba_body:   result.TbaBody,
+		})
+	} else if result.State == QUEUE_STATE_UNKNOWN {
+		apiPanicInternal("%s", result.Error)
+	} else if result.State == QUEUE_STATE_PENDING {
+		writeApiResponse(w, http.StatusAccepted, apiResponse{
+			Status: API_STATUS_QUEUED,
+			Data:   tbaRequestQueued{RequestId: id, Message: fmt.Sprintf("queued (request %d): %s", id, result.Error)},
+		})
+		return
+	}
+
+	sendJson(w, nil)
+}
+
+func apiTBARequest(payload tba.Payload, w http.ResponseWriter, r *http.Request) {
+	apiTBARequestWithReceipts(payload, nil, w, r)
+}
+
+// server settings as returned and accepted by the config API
+type apiFMSConfig struct {
+	fmsConfig
+	// null to use the default hosts
+	ProxyAllowedHosts []string `json:"proxy_allowed_hosts"`
+}
 
-	w.Write([]byte("ok"))
+func getApiFMSConfig() apiFMSConfig {
+	config_mutex.Lock()
+	defer config_mutex.Unlock()
+	// copied, so that decoding a request into the result cannot change proxy_allowed_hosts
+	return apiFMSConfig{fmsConfig: FMSConfig, ProxyAllowedHosts: append([]string(nil), proxy_allowed_hosts...)}
 }
 
 func marshalFMSConfig(w http.ResponseWriter) ([]byte, error) {
-	out, err := json.Marshal(FMSConfig)
+	out, err := json.Marshal(getApiFMSConfig())
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		logger.Printf("JSON.Marshal(FMSConfig): %s\n", err)
@@ -153,12 +305,15 @@ func marshalFMSConfig(w http.ResponseWriter) ([]byte, error) {
 }
 
 func sendJson(w http.ResponseWriter, val any) {
-	out, err := json.Marshal(val)
-	if err != nil {
-		apiPanicInternal("json encode failed: %s", err)
+	writeApiResponse(w, http.StatusOK, apiResponse{Status: API_STATUS_OK, Data: val})
+}
+
+// send JSON that has already been encoded
+func sendRawJson(w http.ResponseWriter, out []byte) {
+	if !json.Valid(out) {
+		apiPanicInternal("invalid JSON response")
 	}
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(out)
+	sendJson(w, json.RawMessage(out))
 }
 
 func jsVersion(w http.ResponseWriter, r *http.Request) {
@@ -183,6 +338,16 @@ func jsBrackets(w http.ResponseWriter, r *http.Request) {
 			brackets[i] = bracket
 		}
 	}
+	if event := r.URL.Query().Get("event"); event != "" {
+		if !eventKeyPattern.MatchString(event) {
+			apiPanicBadRequest("invalid event key: %q", event)
+		}
+		if bracket, err := getEventBracket(event, BRACKET_TYPE_CUSTOM); err == nil {
+			brackets[BRACKET_TYPE_CUSTOM] = bracket
+		} else if getCustomBracketPath(event) != "" {
+			logger.Printf("%s: custom bracket: %s\n", event, err)
+		}
+	}
 
 	out, err := json.Marshal(brackets)
 	if err != nil {
@@ -193,188 +358,158 @@ func jsBrackets(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(");"))
 }
 
-func apiGetFMSConfig(w http.ResponseWriter, r *http.Request) {
-	out, err := marshalFMSConfig(w)
-	if err == nil {
-		w.Write(out)
+func jsRankingNames(w http.ResponseWriter, r *http.Request) {
+	out, err := json.Marshal(rankingNames)
+	if err != nil {
+		apiPanicInternal("%s", err)
 	}
+	w.Write([]byte("window.RANKING_NAMES=Object.freeze("))
+	w.Write(out)
+	w.Write([]byte(");"))
+}
+
+func apiGetFMSConfig(w http.ResponseWriter, r *http.Request) {
+	sendJson(w, getApiFMSConfig())
 }
 
 func apiSetFMSConfig(w http.ResponseWriter, r *http.Request) {
 	body, _ := ioutil.ReadAll(r.Body)
-	err := json.Unmarshal(body, &FMSConfig)
-	resp := make(map[string]interface{})
-	resp["ok"] = (err == nil)
+	new_config := getApiFMSConfig()
+	err := json.Unmarshal(body, &new_config)
+	if err == nil {
+		err = validateConfigUrl("fms_url", new_config.FmsUrl)
+	}
+	if err == nil {
+		err = validateConfigUrl("tba_url", new_config.TbaUrl)
+	}
+	if err == nil {
+		for _, host := range new_config.ProxyAllowedHosts {
+			if err = validateProxyHost(host); err != nil {
+				err = fmt.Errorf("proxy_allowed_hosts: %s", err)
+				break
+			}
+		}
+	}
 	if err != nil {
-		resp["error"] = err.Error()
+		apiPanicBadRequest("%s", err)
+	}
+	config_mutex.Lock()
+	if new_config.DataFolder != FMSConfig.DataFolder {
+		config_mutex.Unlock()
+		// config.json and everything else is stored in the data folder
+		apiPanicBadRequest("data_folder can only be changed with -data-folder")
+	}
+	// settings changed here are saved, even if they were set by flags
+	if new_config.FmsUrl != FMSConfig.FmsUrl {
+		delete(config_flag_fields, "fms_url")
+	}
+	if new_config.TbaUrl != FMSConfig.TbaUrl {
+		delete(config_flag_fields, "tba_url")
+	}
+	if new_config.DryRun != FMSConfig.DryRun {
+		delete(config_flag_fields, "dry_run")
 	}
-	resp["config"] = FMSConfig
-	out, err := json.Marshal(resp)
+	FMSConfig = new_config.fmsConfig
+	proxy_allowed_hosts = new_config.ProxyAllowedHosts
+	err = saveServerConfigLocked()
+	config_mutex.Unlock()
+	out, _ := json.Marshal(new_config)
 	logger.Printf("Changed FMS config: %s\n", out)
-	w.Write(out)
 	if err != nil {
-		logger.Printf("apiSetFMSConfig: Marshal failed: %s\n", err)
+		apiPanicInternal("config changed but could not be saved: %s", err)
 	}
+	sendJson(w, new_config)
 }
 
+// events in the key store, for the web UI to sync. Secrets are never returned,
+// since the server resolves them itself.
 func apiKeysFetch(w http.ResponseWriter, r *http.Request) {
-	w.Write(keys_json)
+	keys := make(map[string]keyStoreEventInfo)
+	if key_store != nil {
+		for event, auth := range key_store.List() {
+			keys[event] = keyStoreEventInfo{Auth: auth}
+		}
+	}
+	sendJson(w, keys)
 }
 
+// import keys synced from the web UI into the key store
 func apiKeysUpdate(w http.ResponseWriter, r *http.Request) {
-	var err error
-	keys_json, err = ioutil.ReadAll(r.Body)
+	checkKeyStore()
+	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		apiPanicBadRequest("failed to read body: %v", err)
 	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		apiPanicBadRequest("invalid keys: %s", err)
+	}
+	if err := importKeysJson(keys); err != nil {
+		apiPanicInternal("failed to update key store: %s", err)
+	}
+	sendJson(w, nil)
 }
 
 func apiUploadEventInfo(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("info/update", w, r)
+	apiTBARequest(&tba.EventInfo{}, w, r)
 }
 
 func apiUploadTeams(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("team_list/update", w, r)
+	apiTBARequest(&tba.TeamList{}, w, r)
 }
 
 func apiUploadAlliances(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("alliance_selections/update", w, r)
+	// alliances are saved by the upload queue once TBA has accepted them
+	apiTBARequest(&tba.AllianceSelections{}, w, r)
 }
 
 func apiUploadAwards(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("awards/update", w, r)
+	apiTBARequest(&tba.AwardList{}, w, r)
 }
 
+// if level and ids (FMS match IDs) are given, receipts for those matches are
+// written once the upload has been delivered
 func apiUploadMatches(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("matches/update", w, r)
+	var receipts *queueReceipts
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		params := checkRequestEventParams(r)
+		receipts = &queueReceipts{
+			Folder:   getMatchDownloadPath(checkRequestLevel(r), params.Event),
+			MatchIds: strings.Split(ids, ","),
+		}
+		if err := validateFmsMatchIds(receipts.MatchIds); err != nil {
+			apiPanicBadRequest("%s", err)
+		}
+	}
+	apiTBARequestWithReceipts(&tba.MatchList{}, receipts, w, r)
 }
 
 func apiFetchMatches(w http.ResponseWriter, r *http.Request) {
-	download_all := (r.URL.Query().Get("all") != "")
-	level := checkRequestLevel(r)
-	playoff_type := checkRequestQueryParamInt(r, "playoff_type")
-	enabled_extra_rps := checkRequestQueryParamBoolArray(r, "enabled_extra_rps")
-	var event_year = parseEventYear(r.URL.Query().Get("event"))
-	var match_folder = getMatchDownloadPath(level, r.URL.Query().Get("event"))
-	var files []string
-	var err error
-	if download_all {
-		files, err = downloadAllMatches(level, r.URL.Query().Get("event"))
-	} else {
-		files, err = downloadNewMatches(level, r.URL.Query().Get("event"))
-	}
-	if err != nil {
-		apiPanicInternal("match downloaded failed: %s", err)
-	}
-
-	if files != nil {
-		for i := 0; i < len(files); i++ {
-			logger.Printf("Downloaded %s\n", files[i])
-			fname := filepath.Base(files[i])
-			match_number, err := strconv.Atoi(strings.Split(fname, "-")[0])
-			if err != nil {
-				apiPanicInternal("%s: failed to parse match ID", fname)
-			}
-			folder := filepath.Dir(files[i])
-
-			match_extra_path := path.Join(folder, replaceExtension(fname, "extrajson"))
-			var extra_info fms_parser.ExtraMatchInfo
-			if isFile(match_extra_path) {
-				raw, _ := ioutil.ReadFile(match_extra_path)
-				err := json.Unmarshal(raw, &extra_info)
-				if err != nil {
-					apiPanicInternal("failed to parse %s: %v", match_extra_path, err)
-				}
-			}
-
-			is_playoff := (level == MATCH_LEVEL_PLAYOFF)
-			if extra_info.MatchCodeOverride != nil {
-				is_playoff = (extra_info.MatchCodeOverride.Level != "qm")
-			}
-
-			match_info, err := fms_parser.ParseHTMLtoJSON(event_year, files[i], fms_parser.FMSParseConfig{
-				Playoff:         is_playoff,
-				EnabledExtraRps: enabled_extra_rps,
-			})
-			if err != nil {
-				apiPanicInternal("failed to parse %s: %s", fname, err)
-			}
-
-			if level == MATCH_LEVEL_MANUAL {
-				defaults := fms_parser.GetDefaultBreakdowns(event_year)
-				if defaults != nil {
-					for _, alliance := range []string{"red", "blue"} {
-						alliance_breakdown := match_info["score_breakdown"].(map[string]map[string]any)[alliance]
-						for key, default_value := range defaults {
-							if _, ok := alliance_breakdown[key]; !ok {
-								alliance_breakdown[key] = default_value
-							}
-						}
-					}
-				}
-			}
-
-			if extra_info.MatchCodeOverride != nil {
-				match_info["comp_level"] = extra_info.MatchCodeOverride.Level
-				match_info["set_number"] = extra_info.MatchCodeOverride.Set
-				match_info["match_number"] = extra_info.MatchCodeOverride.Match
-			} else if level == MATCH_LEVEL_PLAYOFF {
-				// playoffs
-				code := tba.GetPlayoffCode(playoff_type, match_number)
-				match_info["comp_level"] = code.Level
-				match_info["set_number"] = code.Set
-				match_info["match_number"] = code.Match
-			} else {
-				match_info["comp_level"] = "qm"
-				match_info["set_number"] = 1
-				match_info["match_number"] = match_number
-			}
-
-			match_json, err := jsonMarshalOptionalIndent(match_info, level == MATCH_LEVEL_MANUAL, "  ")
-			if err != nil {
-				apiPanicInternal("%s: JSON serialization failed %s", fname, err)
-			}
-
-			fname_json := replaceExtension(fname, "json")
-			ioutil.WriteFile(path.Join(folder, fname_json), match_json, os.ModePerm)
-
-			// remove any receipts for newly-downloaded files
-			fname_receipt := replaceExtension(fname, "receipt")
-			os.Remove(path.Join(folder, fname_receipt))
-		}
+	options := matchParseOptions{
+		Level:           checkRequestLevel(r),
+		Event:           r.URL.Query().Get("event"),
+		PlayoffType:     checkRequestQueryParamInt(r, "playoff_type"),
+		EnabledExtraRps: checkRequestQueryParamBoolArray(r, "enabled_extra_rps"),
 	}
-
-	match_json_list := make([]map[string]interface{}, 0)
-	json_files, err := listFilesWithExtension(match_folder, "json")
+	match_json_list, err := fetchPendingMatches(options, r.URL.Query().Get("all") != "")
 	if err != nil {
-		apiPanicInternal("download folder %s scan failed: %s", match_folder, err)
+		apiPanicInternal("%s", err)
 	}
+	sendJson(w, match_json_list)
+}
 
-	for _, json_file := range json_files {
-		json_path := path.Join(match_folder, json_file.Name())
-		receipt_path := replaceExtension(json_path, "receipt")
-		if fileExists(receipt_path) {
-			// receipt exists, match was already uploaded to TBA
-			continue
-		}
-
-		match_info := make(map[string]interface{})
-		contents, err := ioutil.ReadFile(json_path)
-		err = json.Unmarshal(contents, &match_info)
-		if err != nil {
-			apiPanicInternal("failed to parse %s: %s", json_path, err)
-		}
-
-		match_info["_fms_id"] = strings.Split(json_file.Name(), ".")[0]
-		match_json_list = append(match_json_list, match_info)
+// the matches that apiFetchMatches would return, without downloading from FMS
+func apiListMatches(w http.ResponseWriter, r *http.Request) {
+	level := checkRequestLevel(r)
+	event := checkRequestQueryParam(r, "event")
+	if !eventKeyPattern.MatchString(event) {
+		apiPanicBadRequest("invalid event key: %q", event)
 	}
-
-	output, err := json.Marshal(match_json_list)
+	match_json_list, err := loadPendingMatches(getMatchDownloadPath(level, event))
 	if err != nil {
-		apiPanicInternal("json encode failed: %s", err)
+		apiPanicInternal("%s", err)
 	}
-
-	w.Write(output)
+	sendJson(w, match_json_list)
 }
 
 func apiMarkMatchesUploaded(w http.ResponseWriter, r *http.Request) {
@@ -387,43 +522,30 @@ func apiMarkMatchesUploaded(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		apiPanicBadRequest("failed to parse match ID list: %s", err)
 	}
-	for _, match_id := range match_ids {
-		ioutil.WriteFile(path.Join(match_folder, match_id+".receipt"), []byte(match_id), os.ModePerm)
+	if err := validateFmsMatchIds(match_ids); err != nil {
+		apiPanicBadRequest("%s", err)
 	}
+	writeMatchReceipts(match_folder, params.Event, match_ids, nil)
 }
 
 func apiPurgeMatches(w http.ResponseWriter, r *http.Request) {
 	params := checkRequestEventParams(r)
 	level := checkRequestLevel(r)
-	match_folder := getMatchDownloadPath(level, params.Event)
 	all := (r.URL.Query().Get("all") != "")
-	match_ids := make(map[string]bool)
+	match_ids := make([]string, 0)
 	if !all {
-		match_id_list := make([]string, 0)
-		body, err := ioutil.ReadAll(r.Body)
-		err = json.Unmarshal(body, &match_id_list)
+		body, _ := ioutil.ReadAll(r.Body)
+		err := json.Unmarshal(body, &match_ids)
 		if err != nil {
 			apiPanicBadRequest("failed to parse match ID list: %s", err)
 		}
-		for _, mid := range match_id_list {
-			match_ids[mid] = true
+		if err := validateFmsMatchIds(match_ids); err != nil {
+			apiPanicBadRequest("%s", err)
 		}
 	}
-
-	match_files, err := ioutil.ReadDir(match_folder)
+	err := purgeMatches(level, params.Event, match_ids, all)
 	if err != nil {
-		apiPanicInternal("download folder %s scan failed: %s", match_folder, err)
-	}
-	for _, file := range match_files {
-		if _, in_match_ids := match_ids[strings.Split(file.Name(), ".")[0]]; in_match_ids || all {
-			ext := filepath.Ext(file.Name())
-			if (level != MATCH_LEVEL_MANUAL && (ext == ".html" || ext == ".json")) || ext == ".receipt" {
-				err := os.Remove(path.Join(match_folder, file.Name()))
-				if err != nil {
-					logger.Printf("purge: failed to delete %s: %v\n", file.Name(), err)
-				}
-			}
-		}
+		apiPanicInternal("%s", err)
 	}
 }
 
@@ -432,6 +554,9 @@ func apiMatchLoadExtra(w http.ResponseWriter, r *http.Request) {
 	event_year := parseEventYear(params.Event)
 	level := checkRequestLevel(r)
 	id := checkRequestQueryParam(r, "id")
+	if err := validateFmsMatchIds([]string{id}); err != nil {
+		apiPanicBadRequest("%s", err)
+	}
 
 	extra_filename := path.Join(getMatchDownloadPath(level, params.Event), id+".extrajson")
 	extra_json, err := ioutil.ReadFile(extra_filename)
@@ -442,13 +567,16 @@ func apiMatchLoadExtra(w http.ResponseWriter, r *http.Request) {
 		}
 		extra_json, _ = json.Marshal(tmp)
 	}
-	w.Write(extra_json)
+	sendRawJson(w, extra_json)
 }
 
 func apiMatchSaveExtra(w http.ResponseWriter, r *http.Request) {
 	params := checkRequestEventParams(r)
 	level := checkRequestLevel(r)
 	id := checkRequestQueryParam(r, "id")
+	if err := validateFmsMatchIds([]string{id}); err != nil {
+		apiPanicBadRequest("%s", err)
+	}
 
 	extra_filename := path.Join(getMatchDownloadPath(level, params.Event), id+".extrajson")
 	var tmp fms_parser.ExtraMatchInfo
@@ -460,7 +588,7 @@ func apiMatchSaveExtra(w http.ResponseWriter, r *http.Request) {
 }
 
 func apiDeleteMatches(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("matches/delete", w, r)
+	apiTBARequest(&tba.MatchDeleteList{}, w, r)
 }
 
 func apiCreateMatch(w http.ResponseWriter, r *http.Request) {
@@ -498,7 +626,7 @@ func apiCreateMatch(w http.ResponseWriter, r *http.Request) {
 	}
 	new_file.Close()
 
-	w.Write([]byte(fmt.Sprintf("Created new match %s. Edit at: %s", new_code, new_path)))
+	sendJson(w, fmt.Sprintf("Created new match %s. Edit at: %s", new_code, new_path))
 }
 
 func apiFetchRankings(w http.ResponseWriter, r *http.Request) {
@@ -508,19 +636,19 @@ func apiFetchRankings(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		apiPanicInternal("ranking fetch failed: %s", err)
 	}
-	w.Write(out)
+	sendRawJson(w, out)
 }
 
 func apiUploadRankings(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("rankings/update", w, r)
+	apiTBARequest(&tba.Rankings{}, w, r)
 }
 
 func apiUploadVideos(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("match_videos/add", w, r)
+	apiTBARequest(&tba.MatchVideos{}, w, r)
 }
 
 func apiUploadMedia(w http.ResponseWriter, r *http.Request) {
-	apiTBARequest("media/add", w, r)
+	apiTBARequest(&tba.MediaList{}, w, r)
 }
 
 func apiFetchReport(w http.ResponseWriter, r *http.Request) {
@@ -537,60 +665,64 @@ func apiFetchReport(w http.ResponseWriter, r *http.Request) {
 	sendJson(w, out)
 }
 
-func apiProxy(w http.ResponseWriter, r *http.Request) {
-	url := r.URL.Query().Get("url")
-	if url == "" {
-		apiPanicBadRequest("url param is required")
-	}
-
-	request, err := http.NewRequest(r.Method, url, r.Body)
-	if err != nil {
-		apiPanicInternal("unable to open proxy request: %v", err)
-	}
-	request.Header = r.Header.Clone()
-	request.Header.Del("Cookie")
+// records whether a handler has written a response
+type apiResponseWriter struct {
+	http.ResponseWriter
+	written bool
+}
 
-	client := http.Client{Timeout: 5 * time.Second}
-	response, err := client.Do(request)
-	if err != nil {
-		apiPanicCode(http.StatusBadGateway, "proxy request failed: %v", err)
-	}
-	response_body, err := ioutil.ReadAll(response.Body)
+func (w *apiResponseWriter) WriteHeader(code int) {
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
 
-	for header_name, header_values := range response.Header {
-		for _, header_value := range header_values {
-			w.Header().Set(header_name, header_value)
-		}
-	}
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+func (w *apiResponseWriter) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
 
-	w.WriteHeader(response.StatusCode)
-	w.Write(response_body)
+// register an API route that requires at least the given role
+func handleFuncWrapper(r *mux.Router, route string, role authRole, handler func(w http.ResponseWriter, r *http.Request)) *mux.Route {
+	return r.HandleFunc(route, wrapApiHandler(route, role, handler))
 }
 
-func handleFuncWrapper(r *mux.Router, route string, handler func(w http.ResponseWriter, r *http.Request)) {
-	r.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+// recover from API errors and check the request role before calling handler
+func wrapApiHandler(route string, role authRole, handler func(w http.ResponseWriter, r *http.Request)) func(w http.ResponseWriter, r *http.Request) {
+	is_api := strings.HasPrefix(route, "/api/")
+	return func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if r := recover(); r != nil {
 				switch err := r.(type) {
 				case APIError:
-					w.WriteHeader(err.code)
-					w.Write([]byte(err.message))
+					sendApiError(w, err)
 				default:
 					logger.Printf("Internal error: %v\n%s", r, debug.Stack())
-					w.WriteHeader(http.StatusInternalServerError)
-					w.Write([]byte(fmt.Sprintf("Unknown internal error: %v", err)))
+					sendApiError(w, APIError{
+						code:       http.StatusInternalServerError,
+						error_code: API_ERROR_INTERNAL,
+						message:    fmt.Sprintf("Unknown internal error: %v", err),
+					})
 				}
 			}
 		}()
-		handler(w, r)
-	})
+		checkRequestRole(r, role)
+		if !is_api {
+			handler(w, r)
+			return
+		}
+		// API handlers that do not send anything succeeded with no data
+		api_w := &apiResponseWriter{ResponseWriter: w}
+		handler(api_w, r)
+		if !api_w.written {
+			sendJson(w, nil)
+		}
+	}
 }
 
 //go:embed web/dist/*
 var embeddedFS embed.FS
 
-func RunWebServer(port int, web_folder string) {
+func RunWebServer(port int, web_folder string, tls_options tlsOptions) {
 	r := mux.NewRouter()
 	var web_files http.FileSystem
 	if web_folder != "" {
@@ -599,36 +731,86 @@ func RunWebServer(port int, web_folder string) {
 		subfs, _ := fs.Sub(embeddedFS, "web/dist")
 		web_files = http.FS(subfs)
 	}
-	handleFuncWrapper(r, "/js/version.js", jsVersion)
-	handleFuncWrapper(r, "/js/fms_config.js", jsFMSConfig)
-	handleFuncWrapper(r, "/js/brackets.js", jsBrackets)
-	handleFuncWrapper(r, "/api/fms_config/get", apiGetFMSConfig)
-	handleFuncWrapper(r, "/api/fms_config/set", apiSetFMSConfig)
-	handleFuncWrapper(r, "/api/keys/fetch", apiKeysFetch)
-	handleFuncWrapper(r, "/api/keys/update", apiKeysUpdate)
-	handleFuncWrapper(r, "/api/info/upload", apiUploadEventInfo)
-	handleFuncWrapper(r, "/api/teams/upload", apiUploadTeams)
-	handleFuncWrapper(r, "/api/alliances/upload", apiUploadAlliances)
-	handleFuncWrapper(r, "/api/awards/upload", apiUploadAwards)
-	handleFuncWrapper(r, "/api/matches/fetch", apiFetchMatches)
-	handleFuncWrapper(r, "/api/matches/upload", apiUploadMatches)
-	handleFuncWrapper(r, "/api/matches/mark_uploaded", apiMarkMatchesUploaded)
-	handleFuncWrapper(r, "/api/matches/purge", apiPurgeMatches)
-	handleFuncWrapper(r, "/api/matches/extra", apiMatchLoadExtra)
-	handleFuncWrapper(r, "/api/matches/extra/save", apiMatchSaveExtra)
-	handleFuncWrapper(r, "/api/matches/delete", apiDeleteMatches)
-	handleFuncWrapper(r, "/api/matches/create", apiCreateMatch)
-	handleFuncWrapper(r, "/api/rankings/fetch", apiFetchRankings)
-	handleFuncWrapper(r, "/api/rankings/upload", apiUploadRankings)
-	handleFuncWrapper(r, "/api/videos/upload", apiUploadVideos)
-	handleFuncWrapper(r, "/api/media/upload", apiUploadMedia)
-	handleFuncWrapper(r, "/api/report/fetch", apiFetchReport)
-	handleFuncWrapper(r, "/api/proxy", apiProxy)
+	handleFuncWrapper(r, "/js/version.js", ROLE_NONE, jsVersion)
+	handleFuncWrapper(r, "/js/fms_config.js", ROLE_VIEWER, jsFMSConfig)
+	handleFuncWrapper(r, "/js/brackets.js", ROLE_NONE, jsBrackets)
+	handleFuncWrapper(r, "/js/rankings.js", ROLE_NONE, jsRankingNames)
+	handleFuncWrapper(r, "/api/fms_config/get", ROLE_VIEWER, apiGetFMSConfig)
+	handleFuncWrapper(r, "/api/fms_config/set", ROLE_ADMIN, apiSetFMSConfig)
+	handleFuncWrapper(r, "/api/keys/fetch", ROLE_OPERATOR, apiKeysFetch)
+	handleFuncWrapper(r, "/api/keys/update", ROLE_ADMIN, apiKeysUpdate)
+	handleFuncWrapper(r, "/api/keys/list", ROLE_VIEWER, apiKeyStoreList)
+	handleFuncWrapper(r, "/api/keys/set", ROLE_ADMIN, apiKeyStoreSet)
+	handleFuncWrapper(r, "/api/keys/delete", ROLE_ADMIN, apiKeyStoreDelete)
+	handleFuncWrapper(r, "/api/info/upload", ROLE_OPERATOR, apiUploadEventInfo)
+	handleFuncWrapper(r, "/api/teams/upload", ROLE_OPERATOR, apiUploadTeams)
+	handleFuncWrapper(r, "/api/alliances/upload", ROLE_OPERATOR, apiUploadAlliances)
+	handleFuncWrapper(r, "/api/awards/upload", ROLE_OPERATOR, apiUploadAwards)
+	handleFuncWrapper(r, "/api/matches/fetch", ROLE_OPERATOR, apiFetchMatches)
+	handleFuncWrapper(r, "/api/matches/upload", ROLE_OPERATOR, apiUploadMatches)
+	handleFuncWrapper(r, "/api/matches/mark_uploaded", ROLE_OPERATOR, apiMarkMatchesUploaded)
+	handleFuncWrapper(r, "/api/matches/purge", ROLE_ADMIN, apiPurgeMatches)
+	handleFuncWrapper(r, "/api/matches/extra", ROLE_VIEWER, apiMatchLoadExtra)
+	handleFuncWrapper(r, "/api/matches/extra/save", ROLE_OPERATOR, apiMatchSaveExtra)
+	handleFuncWrapper(r, "/api/matches/delete", ROLE_ADMIN, apiDeleteMatches)
+	handleFuncWrapper(r, "/api/matches/create", ROLE_OPERATOR, apiCreateMatch)
+	handleFuncWrapper(r, "/api/rankings/fetch", ROLE_OPERATOR, apiFetchRankings)
+	handleFuncWrapper(r, "/api/rankings/upload", ROLE_OPERATOR, apiUploadRankings)
+	handleFuncWrapper(r, "/api/videos/upload", ROLE_OPERATOR, apiUploadVideos)
+	handleFuncWrapper(r, "/api/media/upload", ROLE_OPERATOR, apiUploadMedia)
+	handleFuncWrapper(r, "/api/report/fetch", ROLE_OPERATOR, apiFetchReport)
+	handleFuncWrapper(r, "/api/proxy", ROLE_OPERATOR, apiProxy)
+	handleFuncWrapper(r, "/api/auto_upload/start", ROLE_OPERATOR, apiAutoUploadStart)
+	handleFuncWrapper(r, "/api/auto_upload/stop", ROLE_OPERATOR, apiAutoUploadStop)
+	handleFuncWrapper(r, "/api/auto_upload/update", ROLE_OPERATOR, apiAutoUploadUpdate)
+	handleFuncWrapper(r, "/api/auto_upload/status", ROLE_VIEWER, apiAutoUploadStatus)
+	handleFuncWrapper(r, "/api/queue/list", ROLE_VIEWER, apiQueueList)
+	handleFuncWrapper(r, "/api/queue/retry", ROLE_OPERATOR, apiQueueRetry)
+	handleFuncWrapper(r, "/api/queue/remove", ROLE_ADMIN, apiQueueRemove)
+	handleFuncWrapper(r, "/api/queue/pause", ROLE_OPERATOR, apiQueuePause)
+	handleFuncWrapper(r, "/api/audit", ROLE_OPERATOR, apiAuditLog)
+	handleFuncWrapper(r, "/api/undo", ROLE_ADMIN, apiUndo)
+	handleFuncWrapper(r, "/api/reconcile", ROLE_VIEWER, apiReconcile)
+	handleFuncWrapper(r, "/api/reconcile/requeue", ROLE_OPERATOR, apiReconcileRequeue)
+	handleFuncWrapper(r, "/api/events/settings/get", ROLE_VIEWER, apiGetEventSettings)
+	handleFuncWrapper(r, "/api/events/settings/set", ROLE_OPERATOR, apiSetEventSettings)
+	handleFuncWrapper(r, "/api/bracket/state", ROLE_VIEWER, apiBracketState)
+	handleFuncWrapper(r, "/api/bracket/precreate", ROLE_OPERATOR, apiBracketPrecreate)
+	handleFuncWrapper(r, "/api/auth/status", ROLE_NONE, apiAuthStatus)
+	handleFuncWrapper(r, "/api/auth/login", ROLE_NONE, apiAuthLogin)
+	handleFuncWrapper(r, "/api/auth/logout", ROLE_NONE, apiAuthLogout)
+	handleFuncWrapper(r, "/api/auth/update", ROLE_ADMIN, apiAuthUpdate)
+	registerApiV2(r)
 	wsStateInit(r, "/ws")
 	r.PathPrefix("/").Handler(http.FileServer(web_files))
 	addr := fmt.Sprintf(":%d", port)
-	logger.Printf("Serving on %s\n", addr)
-	err := http.ListenAndServe(addr, r)
+	if tls_options.Port == 0 {
+		logger.Printf("Serving on %s\n", addr)
+		err := http.ListenAndServe(addr, r)
+		if err != nil {
+			logger.Fatalf("Could not start server: %s\n", err)
+		}
+		return
+	}
+
+	cert, err := loadTLSCertificate(tls_options)
+	if err != nil {
+		logger.Fatalf("Could not load TLS certificate: %s\n", err)
+	}
+	tls_server := &http.Server{
+		Addr:      fmt.Sprintf(":%d", tls_options.Port),
+		Handler:   r,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12},
+	}
+	go func() {
+		logger.Printf("Redirecting HTTP on %s to HTTPS\n", addr)
+		err := http.ListenAndServe(addr, tlsRedirectHandler(tls_options.Port))
+		if err != nil {
+			logger.Printf("Could not start HTTP redirect: %s\n", err)
+		}
+	}()
+	logger.Printf("Serving HTTPS on %s\n", tls_server.Addr)
+	err = tls_server.ListenAndServeTLS("", "")
 	if err != nil {
 		logger.Fatalf("Could not start server: %s\n", err)
 	}
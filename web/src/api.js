@@ -1,6 +1,34 @@
 import utils from 'src/utils.js';
 
+const dryRunHandlers = [];
+
+// responses from the server API are wrapped in {status, data, error}; callers
+// only see data. Proxied responses are passed through unchanged. Requests that
+// were not sent to TBA because of dry-run mode are passed to dryRunHandlers.
+$.ajaxPrefilter(function(options) {
+    const url = String(options.url);
+    if (url.startsWith('/api/') && !url.startsWith('/api/proxy')) {
+        options.converters = Object.assign({}, options.converters, {
+            'text json': function(text) {
+                const res = JSON.parse(text);
+                if (res && res.status == 'dry_run') {
+                    // some operations (e.g. undo) would send several requests
+                    const requests = Array.isArray(res.data.dry_run) ? res.data.dry_run : [res.data];
+                    requests.forEach((request) => dryRunHandlers.forEach((handler) => handler(request)));
+                }
+                return (res && typeof res == 'object' && 'status' in res) ? res.data : res;
+            },
+        });
+    }
+});
+
 export default Object.freeze({
+    // handler is called with {method, url, headers, body} for each request
+    // that would have been sent to TBA
+    onDryRun(handler) {
+        dryRunHandlers.push(handler);
+    },
+
     async postJson({url, headers, body}) {
         return new Promise((resolve, reject) => {
             $.ajax(url, {
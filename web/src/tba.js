@@ -123,34 +123,9 @@ const tba = Object.freeze({
         },
     }),
 
-    RANKING_NAMES: Object.freeze({
-        2018: [
-            "Ranking Score",
-            "End Game",
-            "Auto",
-            "Ownership",
-            "Vault",
-        ],
-        2019: [
-            "Ranking Score",
-            "Cargo",
-            "Hatch Panel",
-            "HAB Climb",
-            "Sandstorm Bonus",
-        ],
-        2022: [
-            "Ranking Score",
-            "Avg Match",
-            "Avg Hangar",
-            "Avg Taxi + Auto Cargo",
-        ],
-        2023: [
-            "Ranking Score",
-            "Avg Match",
-            "Avg Charge Station",
-            "Avg Auto",
-        ],
-    }),
+    // served by the uploader from rankingNames in rankings.go; not available
+    // outside the browser (e.g. in tools/)
+    RANKING_NAMES: typeof RANKING_NAMES == 'undefined' ? Object.freeze({}) : RANKING_NAMES,
 
     generateRankingsFromMatchResults: function(matchResults, year) {
         const getMatchTeams = function(match) {
@@ -57,6 +57,14 @@ const utils = Object.freeze({
 
     parseErrorText(res) {
         if (res.responseText) {
+            // API errors are sent as {status: 'error', error: {code, message}}
+            try {
+                const body = JSON.parse(res.responseText);
+                if (body && body.error && body.error.message) {
+                    return body.error.message;
+                }
+            }
+            catch (e) {}
             return res.responseText;
         }
         return parseGenericResponseError(res);
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -29,8 +30,8 @@ func wsStateInit(r *mux.Router, prefix string) {
 		logger.Printf("WS.Global: "+f, v...)
 	}
 
-	handleFuncWrapper(r, prefix+"/state/post", wsStatePostMessage)
-	handleFuncWrapper(r, prefix+"/state/subscribe", wsStateSubscribe)
+	handleFuncWrapper(r, prefix+"/state/post", ROLE_OPERATOR, wsStatePostMessage)
+	handleFuncWrapper(r, prefix+"/state/subscribe", ROLE_VIEWER, wsStateSubscribe)
 }
 
 func wsStatePostMessage(w http.ResponseWriter, r *http.Request) {
@@ -41,19 +42,32 @@ func wsStatePostMessage(w http.ResponseWriter, r *http.Request) {
 		apiPanicBadRequest("invalid json: %v", err)
 	}
 
+	err = wsStateUpdate(msg)
+	if err != nil {
+		apiPanicInternal("%v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// merge msg into the global state and publish the result to all subscribers
+func wsStateUpdate(msg map[string]interface{}) error {
+	if ws_global_server == nil {
+		// web server not running
+		return nil
+	}
 	ws_global_mutex.Lock()
 	for k, v := range msg {
 		ws_global_state[k] = v
 	}
-	body, err = json.Marshal(ws_global_state)
+	body, err := json.Marshal(ws_global_state)
 	ws_global_mutex.Unlock()
 
 	if err != nil {
-		apiPanicInternal("could not serialize message body: %v", err)
+		return fmt.Errorf("could not serialize message body: %v", err)
 	}
 	ws_global_server.publish(body)
-
-	w.WriteHeader(http.StatusAccepted)
+	return nil
 }
 
 func wsStateSubscribe(w http.ResponseWriter, r *http.Request) {
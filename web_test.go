@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lethosor/TBA-uploader/tba/faketba"
+)
+
+const webTestMatches = `[{
+	"comp_level": "qm", "set_number": 1, "match_number": 1,
+	"alliances": {
+		"red": {"teams": ["frc1", "frc2", "frc3"], "score": 10},
+		"blue": {"teams": ["frc4", "frc5", "frc6"], "score": 20}
+	}
+}]`
+
+// start a web server backed by a fake TBA server and a fresh upload queue
+func startWebTestServer(t *testing.T) (*httptest.Server, *faketba.Server) {
+	fake := faketba.NewServer()
+	fake.AddCredentials("2023test", "auth", "secret")
+	tba_server := httptest.NewServer(fake)
+	t.Cleanup(tba_server.Close)
+
+	old_tba_url, old_data_folder, old_queue := FMSConfig.TbaUrl, FMSConfig.DataFolder, upload_queue
+	FMSConfig.TbaUrl = tba_server.URL
+	FMSConfig.DataFolder = t.TempDir()
+	var err error
+	upload_queue, err = newUploadQueue(path.Join(FMSConfig.DataFolder, "upload_queue"))
+	if err != nil {
+		t.Fatal("newUploadQueue:", err)
+	}
+	go upload_queue.run()
+	t.Cleanup(func() {
+		upload_queue.Stop()
+		FMSConfig.TbaUrl, FMSConfig.DataFolder, upload_queue = old_tba_url, old_data_folder, old_queue
+	})
+
+	r := mux.NewRouter()
+	handleFuncWrapper(r, "/api/matches/upload", ROLE_OPERATOR, apiUploadMatches)
+	handleFuncWrapper(r, "/api/alliances/upload", ROLE_OPERATOR, apiUploadAlliances)
+	handleFuncWrapper(r, "/api/undo", ROLE_ADMIN, apiUndo)
+	registerApiV2(r)
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	return server, fake
+}
+
+func postWebTest(t *testing.T, url string, secret string, body string) (int, string) {
+	request, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	request.Header.Set("X-Event", "2023test")
+	request.Header.Set("X-Auth", "auth")
+	request.Header.Set("X-Secret", secret)
+	res, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	res_body, _ := ioutil.ReadAll(res.Body)
+	return res.StatusCode, string(res_body)
+}
+
+func TestWebUploadMatches(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	url := server.URL + "/api/matches/upload?level=2&ids=1-1"
+	os.MkdirAll(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), os.ModePerm)
+
+	status, body := postWebTest(t, url, "secret", webTestMatches)
+	if status != http.StatusOK {
+		t.Fatalf("upload failed: %d %s", status, body)
+	}
+	if _, ok := fake.Matches("2023test")["qm1"]; !ok {
+		t.Error("match not stored on TBA")
+	}
+	if !fileExists(path.Join(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), "1-1.receipt")) {
+		t.Error("receipt not written")
+	}
+
+	var res apiResponse
+	if json.Unmarshal([]byte(body), &res) != nil || res.Status != API_STATUS_OK {
+		t.Error("unexpected response:", body)
+	}
+
+	status, body = postWebTest(t, url, "wrong", webTestMatches)
+	if status != http.StatusInternalServerError || !strings.Contains(body, "signature") {
+		t.Errorf("expected signature failure, got %d %s", status, body)
+	}
+	res = apiResponse{}
+	if json.Unmarshal([]byte(body), &res) != nil || res.Status != API_STATUS_ERROR || res.Error == nil {
+		t.Fatal("unexpected error response:", body)
+	}
+	if res.Error.Code != API_ERROR_TBA || res.Error.TbaStatus != http.StatusUnauthorized || res.Error.TbaBody == "" {
+		t.Error("TBA error details missing:", body)
+	}
+
+	status, body = postWebTest(t, url, "secret", `[{"comp_level": "qm"}]`)
+	if status != http.StatusBadRequest || !strings.Contains(body, `"code":"bad_request"`) {
+		t.Errorf("invalid match accepted: %d %s", status, body)
+	}
+}
+
+func TestWebUploadRetry(t *testing.T) {
+	server, fake := startWebTestServer(t)
+	old_timeout := tbaRequestWaitTimeout
+	tbaRequestWaitTimeout = 200 * time.Millisecond
+	defer func() { tbaRequestWaitTimeout = old_timeout }()
+
+	fake.InjectFault(faketba.Fault{Status: http.StatusInternalServerError})
+	status, body := postWebTest(t, server.URL+"/api/alliances/upload", "secret", `[["frc1", "frc2", "frc3"]]`)
+	if status != http.StatusAccepted || !strings.Contains(body, "500") {
+		t.Fatalf("expected request to be queued, got %d %s", status, body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(fake.Alliances("2023test")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(fake.Alliances("2023test")) != 1 {
+		t.Error("queued request not retried")
+	}
+}
+
+func TestWebRejectsInvalidMatchIds(t *testing.T) {
+	server, _ := startWebTestServer(t)
+	os.MkdirAll(getMatchDownloadPath(MATCH_LEVEL_QUAL, "2023test"), os.ModePerm)
+	ioutil.WriteFile(path.Join(FMSConfig.DataFolder, "outside.json"), []byte(webTestMatches), os.ModePerm)
+
+	for _, body := range []string{`["../../../outside"]`, `["1-1/"]`, `["12"]`} {
+		if status, res := postWebTest(t, server.URL+"/api/v2/events/2023test/levels/qual/reconcile/requeue", "secret", body); status != http.StatusBadRequest {
+			t.Errorf("requeue of %s not rejected: %d %s", body, status, res)
+		}
+	}
+	if status, res := postWebTest(t, server.URL+"/api/matches/upload?level=2&ids=../1-1", "secret", webTestMatches); status != http.StatusBadRequest {
+		t.Errorf("upload with invalid receipt ID not rejected: %d %s", status, res)
+	}
+}